@@ -0,0 +1,453 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+// audit log actions
+const (
+	AuditTrigger = "trigger"
+	AuditRerun   = "rerun" // a trigger of a build already triggered for the same merge commit and pattern
+	AuditCancel  = "cancel"
+)
+
+// auditModeDirect is the mode recorded for builds triggered without discovery (--service with --all or a test regex).
+const auditModeDirect = "direct"
+
+// AuditRecord is a line of the audit log, every build tctest triggers or cancels.
+type AuditRecord struct {
+	Time        time.Time         `json:"time"`
+	Action      string            `json:"action"`
+	Run         string            `json:"run"` // shared by the builds triggered by one command (or PR for watch and serve)
+	User        string            `json:"user"`
+	Repo        string            `json:"repo,omitempty"`
+	PR          int               `json:"pr,omitempty"`
+	MergeSHA    string            `json:"merge_sha,omitempty"`
+	Branch      string            `json:"branch,omitempty"`
+	Service     string            `json:"service,omitempty"`
+	Matrix      string            `json:"matrix,omitempty"`
+	BuildTypeID string            `json:"build_type_id"`
+	BuildID     int               `json:"build_id"`
+	URL         string            `json:"url,omitempty"`
+	Pattern     string            `json:"pattern,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+}
+
+// buildKey identifies the build of the code a record is for, records with the same key are the same build.
+func (r AuditRecord) buildKey() string {
+	return strings.Join([]string{r.Repo, strconv.Itoa(r.PR), r.Branch, r.MergeSHA, r.BuildTypeID, r.Matrix, r.Pattern}, "\x00")
+}
+
+// secretPropertyRe matches the names of properties whose values are redacted in the audit log.
+var secretPropertyRe = regexp.MustCompile(`(?i)(secret|passw|token|credential|cert|sas_|api_?key|access_?key|private_?key|client_?key)`)
+
+const redacted = "[REDACTED]"
+
+func redactProperties(properties []tc.Property) map[string]string {
+	if len(properties) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(properties))
+	for _, p := range properties {
+		v := p.Value
+		if secretPropertyRe.MatchString(p.Name) {
+			v = redacted
+		}
+		m[p.Name] = v
+	}
+	return m
+}
+
+func (f *FlagData) auditLogPath() (string, error) {
+	dir, err := f.stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// newAuditRunID returns an ID for the builds about to be triggered, time based so runs sort.
+func newAuditRunID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// auditUser returns the OS user tctest is running as.
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// newAuditRecord fills in the fields every record shares.
+func (f *FlagData) newAuditRecord(action string) AuditRecord {
+	if f.runID == "" {
+		f.runID = newAuditRunID()
+	}
+
+	return AuditRecord{
+		Time:   time.Now().UTC(),
+		Action: action,
+		Run:    f.runID,
+		User:   auditUser(),
+		Repo:   f.GH.Repo,
+	}
+}
+
+// audit appends a record to the audit log. The build has already been triggered or cancelled by then, so failing
+// to write the log only warns.
+func (f *FlagData) audit(rec AuditRecord) {
+	path, err := f.auditLogPath()
+	if err == nil {
+		err = appendAuditRecord(path, rec)
+	}
+	if err != nil {
+		cout.Errorf("  <yellow>WARNING:</> unable to write the audit log: %v\n", err)
+	}
+}
+
+func appendAuditRecord(path string, rec AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) //nolint:gosec // path is within the state dir
+	if err != nil {
+		return fmt.Errorf("opening audit log %s: %w", path, err)
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("writing audit log %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// readAuditLog returns every record in the audit log, oldest first. Lines that can't be parsed (a write cut short)
+// are skipped.
+func readAuditLog(path string) ([]AuditRecord, error) {
+	file, err := os.Open(path) //nolint:gosec // path is within the state dir
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			clog.Log.Debugf("skipping audit log line %d: %v", line, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit log %s: %w", path, err)
+	}
+
+	return records, nil
+}
+
+// auditLog reads the audit log, for the commands that look builds up in it.
+func (f *FlagData) auditLog() ([]AuditRecord, error) {
+	path, err := f.auditLogPath()
+	if err != nil {
+		return nil, err
+	}
+	return readAuditLog(path)
+}
+
+// latestTriggers indexes the latest trigger of each build in the audit log by its buildKey.
+func latestTriggers(records []AuditRecord) map[string]AuditRecord {
+	latest := map[string]AuditRecord{}
+	for _, r := range records {
+		if r.Action == AuditTrigger || r.Action == AuditRerun {
+			latest[r.buildKey()] = r
+		}
+	}
+	return latest
+}
+
+// auditTriggers answers "did I already test this?" for each trigger. The audit log is read on the first trigger and
+// the index kept up to date after that, so long running commands such as serve and watch don't re-read the whole
+// log for every build.
+type auditTriggers struct {
+	mu     sync.Mutex
+	latest map[string]AuditRecord
+}
+
+// previous returns the latest earlier trigger of the same build as rec, and records rec as the latest.
+func (t *auditTriggers) previous(f *FlagData, rec AuditRecord) *AuditRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.latest == nil {
+		records, err := f.auditLog()
+		if err != nil {
+			clog.Log.Debugf("unable to check the audit log for earlier triggers: %v", err)
+		}
+		t.latest = latestTriggers(records)
+	}
+
+	key := rec.buildKey()
+	prev, ok := t.latest[key]
+	t.latest[key] = rec
+	if !ok {
+		return nil
+	}
+	return &prev
+}
+
+// auditTrigger records a triggered build, as a rerun when the same build was triggered before.
+func (f *FlagData) auditTrigger(spec BuildSpec, properties []tc.Property, buildID int, buildURL string) {
+	rec := f.newAuditRecord(AuditTrigger)
+	rec.Branch = spec.Branch
+	rec.Service = spec.Service
+	rec.Matrix = spec.Matrix.String()
+	rec.BuildTypeID = spec.TypeID
+	rec.BuildID = buildID
+	rec.URL = buildURL
+	rec.Pattern = spec.TestRegEx
+	rec.Properties = redactProperties(properties)
+	rec.Mode = spec.Mode
+	if spec.PR != nil {
+		rec.PR = spec.PR.Number
+		rec.MergeSHA = spec.PR.MergeSHA
+	}
+
+	if f.triggers == nil {
+		f.triggers = &auditTriggers{}
+	}
+	if prev := f.triggers.previous(f, rec); prev != nil {
+		rec.Action = AuditRerun
+		cout.Printf("  <yellow>already triggered</> as build <green>%d</> on %s by %s\n", prev.BuildID, prev.Time.Local().Format("2006-01-02 15:04"), prev.User)
+	}
+
+	f.audit(rec)
+}
+
+// lastRun returns the builds triggered by the most recent run in the audit log.
+func lastRun(records []AuditRecord) []AuditRecord {
+	run := ""
+	for i := len(records) - 1; i >= 0; i-- {
+		if a := records[i].Action; a == AuditTrigger || a == AuditRerun {
+			run = records[i].Run
+			break
+		}
+	}
+	if run == "" {
+		return nil
+	}
+
+	var builds []AuditRecord
+	for _, r := range records {
+		if r.Run == run && (r.Action == AuditTrigger || r.Action == AuditRerun) {
+			builds = append(builds, r)
+		}
+	}
+	return builds
+}
+
+// lastRunBuilds returns the builds from the last run, for results --last and cancel --last.
+func (f *FlagData) lastRunBuilds() ([]AuditRecord, error) {
+	records, err := f.auditLog()
+	if err != nil {
+		return nil, err
+	}
+	builds := lastRun(records)
+	if len(builds) == 0 {
+		return nil, errors.New("no triggered builds in the audit log")
+	}
+	return builds, nil
+}
+
+// parseSince parses a --since duration, which also accepts whole days such as 7d.
+func parseSince(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid --since %q, expected a duration such as 12h or 7d", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid --since %q, expected a duration such as 12h or 7d", s)
+	}
+	return d, nil
+}
+
+// HistoryCmd prints the audit log, filtered by --pr and --since.
+func (f *FlagData) HistoryCmd() error {
+	var since time.Time
+	if f.History.Since != "" {
+		d, err := parseSince(f.History.Since)
+		if err != nil {
+			return err
+		}
+		since = time.Now().Add(-d)
+	}
+
+	records, err := f.auditLog()
+	if err != nil {
+		return err
+	}
+
+	matched := []AuditRecord{}
+	for _, r := range records {
+		if f.History.PR != 0 && r.PR != f.History.PR {
+			continue
+		}
+		if r.Time.Before(since) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+
+	if cout.Level == cout.VerbosityJSON {
+		cout.PrintJSON(matched)
+		return nil
+	}
+
+	for _, r := range matched {
+		what := r.Branch
+		if r.PR != 0 {
+			what = "#" + strconv.Itoa(r.PR)
+		}
+		if r.Service != "" {
+			what += " [" + r.Service + "]"
+		}
+		if r.Matrix != "" {
+			what += " {" + r.Matrix + "}"
+		}
+
+		cout.Printf("%s <yellow>%-7s</> <cyan>%s</> %s build <green>%d</> <darkGray>%s</>", r.Time.Local().Format("2006-01-02 15:04"), r.Action, what, r.BuildTypeID, r.BuildID, r.User)
+		if r.Pattern != "" {
+			cout.Printf(" %s", r.Pattern)
+		}
+		cout.Printf("\n")
+	}
+	cout.Printf("<yellow>%d</> of <yellow>%d</> audit log entries\n", len(matched), len(records))
+
+	return nil
+}
+
+// CancelCmd cancels builds by ID, or with --last the builds triggered by the last run.
+func (f *FlagData) CancelCmd(buildIDs []int) error {
+	var builds []AuditRecord
+	for _, id := range buildIDs {
+		builds = append(builds, AuditRecord{BuildID: id})
+	}
+	if f.Last {
+		last, err := f.lastRunBuilds()
+		if err != nil {
+			return err
+		}
+		builds = append(builds, last...)
+	}
+	if len(builds) == 0 {
+		return errors.New("no builds to cancel, pass build IDs or --last")
+	}
+
+	server, err := f.NewTCServer()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range builds {
+		status, state, err := server.BuildState(b.BuildID)
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("HTTP status %d", status)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("getting build %d state: %w", b.BuildID, err))
+			continue
+		}
+		if state == "finished" {
+			cout.Printf("build <green>%d</> has already finished\n", b.BuildID)
+			continue
+		}
+
+		cout.Printf("cancelling %s build <green>%d</>\n", state, b.BuildID)
+		if f.DryRun {
+			continue
+		}
+		if err := server.CancelBuild(b.BuildID, state == "queued", "cancelled by "+auditUser()+" via tctest cancel"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		f.auditCancel(b)
+	}
+
+	return errors.Join(errs...)
+}
+
+// auditCancel records cancelling a build, keeping what is known about it.
+func (f *FlagData) auditCancel(b AuditRecord) {
+	rec := f.newAuditRecord(AuditCancel)
+	rec.PR = b.PR
+	rec.MergeSHA = b.MergeSHA
+	rec.Branch = b.Branch
+	rec.Service = b.Service
+	rec.Matrix = b.Matrix
+	rec.BuildTypeID = b.BuildTypeID
+	rec.BuildID = b.BuildID
+	rec.URL = b.URL
+	f.audit(rec)
+}
+
+// BuildResultsForLastRunCmd shows the results of each build triggered by the last run.
+func (f *FlagData) BuildResultsForLastRunCmd() error {
+	builds, err := f.lastRunBuilds()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, b := range builds {
+		what := b.Branch
+		if b.PR != 0 {
+			what = "PR #" + strconv.Itoa(b.PR)
+		}
+		if b.Service != "" {
+			what += " [" + b.Service + "]"
+		}
+		if b.Matrix != "" {
+			what += " {" + b.Matrix + "}"
+		}
+		cout.Printf("<cyan>%s</> build <green>%d</> <darkGray>%s</>\n", what, b.BuildID, b.URL)
+
+		if err := f.BuildResultsCmd(b.BuildID); err != nil {
+			cout.Errorf("<red>ERROR:</> %v\n", err)
+			errs = append(errs, err)
+		}
+		cout.Println()
+	}
+
+	return errors.Join(errs...)
+}
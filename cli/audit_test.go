@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+func TestParseSince(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"7d", 7 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"d", 0, true},
+		{"-1d", 0, true},
+		{"-2h", 0, true},
+		{"week", 0, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseSince(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactProperties(t *testing.T) {
+	t.Parallel()
+
+	got := redactProperties([]tc.Property{
+		{Name: "ARM_CLIENT_SECRET", Value: "s3cret"},
+		{Name: "env.GITHUB_TOKEN", Value: "ghp_123"},
+		{Name: "ARM_CLIENT_CERTIFICATE_PASSWORD", Value: "pw"},
+		{Name: "AWS_SECRET_ACCESS_KEY", Value: "key"},
+		{Name: "ARM_TEST_LOCATION", Value: "westeurope"},
+		{Name: "TEST_PATTERN", Value: "TestAcc"},
+		{Name: "PR_AUTHOR", Value: "katbyte"},
+	})
+
+	want := map[string]string{
+		"ARM_CLIENT_SECRET":               redacted,
+		"env.GITHUB_TOKEN":                redacted,
+		"ARM_CLIENT_CERTIFICATE_PASSWORD": redacted,
+		"AWS_SECRET_ACCESS_KEY":           redacted,
+		"ARM_TEST_LOCATION":               "westeurope",
+		"TEST_PATTERN":                    "TestAcc",
+		"PR_AUTHOR":                       "katbyte",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestAuditLogRuns(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	records := []AuditRecord{
+		{Action: AuditTrigger, Run: "a", PR: 1, MergeSHA: "sha1", BuildTypeID: "TF_DNS", Pattern: "TestAcc", BuildID: 1},
+		{Action: AuditTrigger, Run: "b", PR: 1, MergeSHA: "sha2", BuildTypeID: "TF_DNS", Pattern: "TestAcc", BuildID: 2},
+		{Action: AuditTrigger, Run: "b", PR: 2, MergeSHA: "sha3", BuildTypeID: "TF_DNS", Pattern: "TestAcc", BuildID: 3},
+		{Action: AuditCancel, Run: "c", BuildID: 3},
+	}
+	for _, r := range records {
+		if err := appendAuditRecord(path, r); err != nil {
+			t.Fatalf("appending: %v", err)
+		}
+	}
+
+	// a write cut short doesn't lose the rest of the log
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"action":"trig` + "\n")
+	_ = f.Close()
+
+	read, err := readAuditLog(path)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	if len(read) != len(records) {
+		t.Fatalf("read %d records, want %d", len(read), len(records))
+	}
+
+	last := lastRun(read)
+	if len(last) != 2 || last[0].BuildID != 2 || last[1].BuildID != 3 {
+		t.Errorf("lastRun = %+v, want builds 2 and 3", last)
+	}
+
+	// the log is only read for the first trigger, later ones are checked against the builds triggered since
+	flags := &FlagData{}
+	flags.StateDir = filepath.Dir(path)
+	triggers := &auditTriggers{}
+	again := AuditRecord{PR: 1, MergeSHA: "sha1", BuildTypeID: "TF_DNS", Pattern: "TestAcc", BuildID: 5}
+	if prev := triggers.previous(flags, again); prev == nil || prev.BuildID != 1 {
+		t.Errorf("previous = %+v, want build 1", prev)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if prev := triggers.previous(flags, again); prev == nil || prev.BuildID != 5 {
+		t.Errorf("previous = %+v, want build 5", prev)
+	}
+	if prev := triggers.previous(flags, AuditRecord{PR: 1, MergeSHA: "sha4", BuildTypeID: "TF_DNS", Pattern: "TestAcc"}); prev != nil {
+		t.Errorf("previous = %+v for a new merge commit, want nil", prev)
+	}
+
+	if missing, err := readAuditLog(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || missing != nil {
+		t.Errorf("reading a missing log = %v, %v, want nothing", missing, err)
+	}
+}
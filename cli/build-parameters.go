@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+// BuildParameterPresetAuto selects the preset of the --repo's provider, see presetForRepo.
+const BuildParameterPresetAuto = "auto"
+
+// BuildParameterPresets are the branch and test pattern parameter layouts of known TeamCity projects, selected
+// with --build-parameter-preset. Values are templates rendered with BuildTemplateData.
+var BuildParameterPresets = map[string][]string{
+	// every parameter name tctest has historically sent, for build configurations of other repos that read any of
+	// them as there is no telling which
+	"legacy": {
+		"teamcity.build.branch={{.Branch}}",
+		"BRANCH_NAME={{.Branch}}",
+		"TEST_PATTERN={{.TestPattern}}",
+		"TEST_PREFIX={{.TestPattern}}",
+	},
+	"azurerm": {
+		"teamcity.build.branch={{.Branch}}",
+		"TEST_PREFIX={{.TestPattern}}",
+	},
+	"aws": {
+		"BRANCH_NAME={{.Branch}}",
+		"TEST_PATTERN={{.TestPattern}}",
+	},
+}
+
+// buildParameterTemplates returns the NAME=TEMPLATE parameters to send with every build: --build-parameter when
+// set, otherwise the selected preset.
+func (f *FlagData) buildParameterTemplates() []string {
+	if len(f.TC.Build.BuildParameters) > 0 {
+		return f.TC.Build.BuildParameters
+	}
+	preset := f.TC.Build.ParameterPreset
+	if preset == BuildParameterPresetAuto {
+		preset = presetForRepo(f.GH.Repo)
+	}
+	return BuildParameterPresets[preset]
+}
+
+// presetForRepo returns the build parameter preset of a repo's provider, so its builds only carry the parameters
+// its build configurations read, or legacy for any other repo.
+func presetForRepo(repo string) string {
+	switch path.Base(repo) {
+	case "terraform-provider-azurerm":
+		return "azurerm"
+	case "terraform-provider-aws":
+		return "aws"
+	}
+	return "legacy"
+}
+
+// renderBuildParameters renders NAME=TEMPLATE parameters into TeamCity properties.
+func renderBuildParameters(params []string, data BuildTemplateData) ([]tc.Property, error) {
+	props := make([]tc.Property, 0, len(params))
+	for _, p := range params {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("unable to parse build parameter '%s': expected NAME=TEMPLATE", p)
+		}
+
+		rendered, err := renderValue("build parameter", name, value, data)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, tc.Property{Name: name, Value: rendered})
+	}
+
+	return props, nil
+}
+
+// mergeProperties appends the extra properties to the build parameters. A property replaces any earlier one of the
+// same name rather than sending both, so --properties override the parameters, and the service map and --matrix
+// values appended after them override --properties.
+func mergeProperties(params, extra []tc.Property) []tc.Property {
+	all := slices.Concat(params, extra)
+	merged := make([]tc.Property, 0, len(all))
+	for i, p := range all {
+		if !slices.ContainsFunc(all[i+1:], func(later tc.Property) bool { return later.Name == p.Name }) {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// validateBuildParameters checks the preset exists and the parameter templates render, so mistakes are reported
+// before anything is triggered.
+func validateBuildParameters(preset string, params []string) error {
+	if len(params) == 0 {
+		if _, ok := BuildParameterPresets[preset]; !ok && preset != BuildParameterPresetAuto {
+			valid := append([]string{BuildParameterPresetAuto}, slices.Sorted(maps.Keys(BuildParameterPresets))...)
+			return fmt.Errorf("unknown --build-parameter-preset %q (valid: %s)", preset, strings.Join(valid, ", "))
+		}
+		return nil
+	}
+
+	if _, err := renderBuildParameters(params, placeholderTemplateData); err != nil {
+		return fmt.Errorf("invalid --build-parameter: %w", err)
+	}
+	return nil
+}
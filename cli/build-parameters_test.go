@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+func TestRenderBuildParameters(t *testing.T) {
+	t.Parallel()
+
+	data := BuildTemplateData{PR: 12, Branch: "refs/pull/12/merge", TestPattern: "TestAccFoo_"}
+
+	got, err := renderBuildParameters([]string{
+		"teamcity.build.branch={{.Branch}}",
+		"env.TESTARGS=-run={{.TestPattern}} -timeout 180m",
+		"STATIC=a=b",
+	}, data)
+	if err != nil {
+		t.Fatalf("renderBuildParameters unexpected error: %v", err)
+	}
+
+	want := []tc.Property{
+		{Name: "teamcity.build.branch", Value: "refs/pull/12/merge"},
+		{Name: "env.TESTARGS", Value: "-run=TestAccFoo_ -timeout 180m"},
+		{Name: "STATIC", Value: "a=b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("renderBuildParameters = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"NOVALUE", "=x", "A={{.Nope}}"} {
+		if _, err := renderBuildParameters([]string{bad}, data); err == nil {
+			t.Errorf("renderBuildParameters(%q) expected an error", bad)
+		}
+	}
+	if _, err := renderBuildParameters([]string{"A={{.Nope}}"}, data); err == nil || !strings.Contains(err.Error(), "template for build parameter A") {
+		t.Errorf("renderBuildParameters error = %v, want it to name the build parameter", err)
+	}
+}
+
+func TestBuildParameterTemplates(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		preset string
+		repo   string
+		params []string
+		want   []string
+	}{
+		{preset: "auto", repo: "hashicorp/terraform-provider-azurerm", want: BuildParameterPresets["azurerm"]},
+		{preset: "auto", repo: "hashicorp/terraform-provider-aws", want: BuildParameterPresets["aws"]},
+		{preset: "auto", repo: "example/terraform-provider-example", want: BuildParameterPresets["legacy"]},
+		{preset: "legacy", repo: "hashicorp/terraform-provider-azurerm", want: BuildParameterPresets["legacy"]},
+		{preset: "auto", repo: "hashicorp/terraform-provider-aws", params: []string{"TF_ACC_TEST_REGEX={{.TestPattern}}"}, want: []string{"TF_ACC_TEST_REGEX={{.TestPattern}}"}},
+	}
+	for _, tt := range cases {
+		f := FlagData{}
+		f.GH.Repo = tt.repo
+		f.TC.Build.ParameterPreset = tt.preset
+		f.TC.Build.BuildParameters = tt.params
+		if got := f.buildParameterTemplates(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("buildParameterTemplates(%s, %s, %v) = %v, want %v", tt.preset, tt.repo, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestMergeProperties(t *testing.T) {
+	t.Parallel()
+
+	params := []tc.Property{{Name: "BRANCH_NAME", Value: "b"}, {Name: "TEST_PATTERN", Value: "TestAcc"}}
+	extra := []tc.Property{{Name: "TEST_PATTERN", Value: "TestAccOverride"}, {Name: "X", Value: "1"}}
+
+	want := []tc.Property{{Name: "BRANCH_NAME", Value: "b"}, {Name: "TEST_PATTERN", Value: "TestAccOverride"}, {Name: "X", Value: "1"}}
+	if got := mergeProperties(params, extra); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeProperties = %v, want %v", got, want)
+	}
+
+	// a --matrix value repeating a --properties key replaces it
+	extra = []tc.Property{{Name: "ARM_TEST_LOCATION", Value: "westeurope"}, {Name: "X", Value: "1"}, {Name: "ARM_TEST_LOCATION", Value: "eastus2"}}
+	want = []tc.Property{{Name: "BRANCH_NAME", Value: "b"}, {Name: "TEST_PATTERN", Value: "TestAcc"}, {Name: "X", Value: "1"}, {Name: "ARM_TEST_LOCATION", Value: "eastus2"}}
+	if got := mergeProperties(params, extra); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeProperties = %v, want %v", got, want)
+	}
+}
+
+func TestValidateBuildParameters(t *testing.T) {
+	t.Parallel()
+
+	if err := validateBuildParameters("azurerm", nil); err != nil {
+		t.Errorf("azurerm preset: unexpected error: %v", err)
+	}
+	if err := validateBuildParameters("auto", nil); err != nil {
+		t.Errorf("auto preset: unexpected error: %v", err)
+	}
+	if err := validateBuildParameters("nope", nil); err == nil {
+		t.Error("unknown preset: expected an error")
+	}
+	// explicit parameters replace the preset entirely
+	if err := validateBuildParameters("nope", []string{"TF_ACC_TEST_REGEX={{.TestPattern}}"}); err != nil {
+		t.Errorf("explicit parameters: unexpected error: %v", err)
+	}
+}
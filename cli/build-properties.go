@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/v89/github"
+)
+
+// PullRequestDetails holds the PR fields build property templates and the audit log reference, fetched from GitHub
+// once per PR before its builds are triggered.
+type PullRequestDetails struct {
+	Number   int
+	MergeSHA string
+	Author   string
+	Labels   []string
+}
+
+func NewPullRequestDetails(pr *github.PullRequest) *PullRequestDetails {
+	labels := make([]string, 0, len(pr.Labels))
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
+	}
+
+	return &PullRequestDetails{
+		Number:   pr.GetNumber(),
+		MergeSHA: pr.GetMergeCommitSHA(),
+		Author:   pr.GetUser().GetLogin(),
+		Labels:   labels,
+	}
+}
+
+// BuildTemplateData is what --properties, --build-parameter (and service map property) values are rendered with,
+// once per build:
+//
+//	RESOURCE_PREFIX=pr{{.PR}}{{.Service}};COMMIT={{.MergeSHA}}
+type BuildTemplateData struct {
+	PR          int
+	Service     string
+	Branch      string
+	TestPattern string
+	MergeSHA    string
+	Author      string
+	Labels      templateList
+	TestCount   int
+}
+
+// templateList prints as a comma separated list in templates while still supporting {{range}}.
+type templateList []string
+
+func (l templateList) String() string {
+	return strings.Join(l, ",")
+}
+
+func (spec BuildSpec) templateData() BuildTemplateData {
+	d := BuildTemplateData{
+		Service:     spec.Service,
+		Branch:      spec.Branch,
+		TestPattern: spec.TestRegEx,
+		TestCount:   spec.TestCount,
+	}
+
+	if pr := spec.PR; pr != nil {
+		d.PR = pr.Number
+		d.MergeSHA = pr.MergeSHA
+		d.Author = pr.Author
+		d.Labels = pr.Labels
+	}
+
+	return d
+}
+
+// isTemplated returns true if the properties string contains any template actions.
+func isTemplated(properties string) bool {
+	return strings.Contains(properties, "{{")
+}
+
+// renderProperties renders each value of a KEY1=VALUE1;KEY2=VALUE2 properties string as a text/template.
+// Values are rendered individually, after splitting, so a rendered value (such as a label) can't inject
+// additional properties.
+func renderProperties(properties string, data BuildTemplateData) (string, error) {
+	if !isTemplated(properties) {
+		return properties, nil
+	}
+
+	props := strings.Split(properties, ";")
+	for i, p := range props {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || !isTemplated(value) {
+			continue // malformed properties are reported by TriggerBuild
+		}
+
+		rendered, err := renderValue("property", key, value, data)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(rendered, ";") {
+			return "", fmt.Errorf("rendered value of property %s contains ';': %q", key, rendered)
+		}
+		props[i] = key + "=" + rendered
+	}
+
+	return strings.Join(props, ";"), nil
+}
+
+// renderValue renders a single property or parameter value as a text/template, kind naming which in errors.
+func renderValue(kind, name, value string, data BuildTemplateData) (string, error) {
+	if !isTemplated(value) {
+		return value, nil
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("parsing template for %s %s: %w", kind, name, err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering template for %s %s: %w", kind, name, err)
+	}
+
+	return b.String(), nil
+}
+
+// placeholderTemplateData is used to check templates render before any real build data is known.
+var placeholderTemplateData = BuildTemplateData{
+	PR:          1,
+	Service:     "service",
+	Branch:      "refs/pull/1/merge",
+	TestPattern: "TestAcc",
+	MergeSHA:    "0000000000000000000000000000000000000000",
+	Author:      "author",
+	Labels:      templateList{"label"},
+	TestCount:   1,
+}
+
+// validatePropertyTemplates renders the properties with placeholder data so template errors are reported up
+// front instead of when the first build is triggered.
+func validatePropertyTemplates(properties string) error {
+	_, err := renderProperties(properties, placeholderTemplateData)
+	if err != nil {
+		return fmt.Errorf("invalid build property template: %w", err)
+	}
+	return nil
+}
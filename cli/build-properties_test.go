@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderProperties(t *testing.T) {
+	t.Parallel()
+
+	data := BuildTemplateData{
+		PR:        1234,
+		Service:   "network",
+		Branch:    "refs/pull/1234/merge",
+		MergeSHA:  "abc123",
+		Author:    "katbyte",
+		Labels:    templateList{"service/network", "bug"},
+		TestCount: 3,
+	}
+
+	cases := map[string]string{
+		``:                                      ``,
+		`A=1;B=2`:                               `A=1;B=2`,
+		`RESOURCE_PREFIX=pr{{.PR}}{{.Service}}`: `RESOURCE_PREFIX=pr1234network`,
+		`SHA={{.MergeSHA}};BY={{.Author}}`:      `SHA=abc123;BY=katbyte`,
+		`LABELS={{.Labels}}`:                    `LABELS=service/network,bug`,
+		`N={{.TestCount}};STATIC=x=y`:           `N=3;STATIC=x=y`,
+		`FIRST={{index .Labels 0}}`:             `FIRST=service/network`,
+	}
+	for in, want := range cases {
+		got, err := renderProperties(in, data)
+		if err != nil {
+			t.Errorf("renderProperties(%q) unexpected error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("renderProperties(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderPropertiesErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		`A={{.Nope}}`:   "rendering template for property A",
+		`A={{.PR`:       "parsing template for property A",
+		`A={{.Author}}`: "contains ';'",
+	}
+	for in, want := range cases {
+		_, err := renderProperties(in, BuildTemplateData{Author: "x;INJECTED=1"})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("renderProperties(%q) error = %v, want it to contain %q", in, err, want)
+		}
+	}
+}
@@ -5,12 +5,12 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/katbyte/tctest/lib/cout"
-	"github.com/katbyte/tctest/lib/gh"
 	"github.com/katbyte/tctest/lib/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -39,6 +39,11 @@ func Make() (*cobra.Command, error) {
 It can also pull the tests to run for a PR on github
 Complete documentation is available at https://github.com/katbyte/tctest`,
 		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			profile, err := ApplyProfile(cmd.Root().PersistentFlags())
+			if err != nil {
+				return err
+			}
+
 			switch {
 			case viper.GetBool("silent"):
 				cout.Level = cout.VerbositySilent
@@ -50,6 +55,16 @@ Complete documentation is available at https://github.com/katbyte/tctest`,
 				cout.Level = cout.VerbosityVerbose
 			}
 
+			if profile != "" {
+				cout.Verbosef("%s\n", profile)
+			}
+			cwd, _ := os.Getwd() // without it no repo is detected
+			notes, detected := applyRepoDefaults(viper.GetViper(), cwd)
+			detectedDefaults = detected
+			for _, note := range notes {
+				cout.Verbosef("%s\n", note)
+			}
+
 			if viper.GetBool("all") && len(viper.GetStringSlice("add-tests")) > 0 {
 				return errors.New("cannot use --add-tests together with --all, --all already runs all tests")
 			}
@@ -64,6 +79,41 @@ Complete documentation is available at https://github.com/katbyte/tctest`,
 				}
 			}
 
+			if err := validatePropertyTemplates(viper.GetString("properties")); err != nil {
+				return err
+			}
+
+			if err := validateBuildParameters(viper.GetString("build-parameter-preset"), viper.GetStringSlice("build-parameter")); err != nil {
+				return err
+			}
+
+			if _, err := ParseMatrix(viper.GetStringSlice("matrix")); err != nil {
+				return err
+			}
+
+			if err := validateForkPolicy(viper.GetString("fork-policy")); err != nil {
+				return err
+			}
+
+			if err := validateGraphFormat(viper.GetString("graph-format")); err != nil {
+				return err
+			}
+
+			if err := validateMode(viper.GetString("mode")); err != nil {
+				return err
+			}
+
+			if err := validateVendorMode(viper.GetString("local-vendor-mode")); err != nil {
+				return err
+			}
+
+			loadedServiceMap, loadedSmokeSuites = nil, nil
+			if p := viper.GetString("service-map"); p != "" {
+				if loadedServiceMap, loadedSmokeSuites, err = LoadServiceMap(p); err != nil {
+					return err
+				}
+			}
+
 			// TODO: remove once --buildtypeid is removed
 			return resolveBuildTypeID(cmd)
 		},
@@ -87,7 +137,7 @@ Complete documentation is available at https://github.com/katbyte/tctest`,
 	root.AddCommand(&cobra.Command{
 		Use:           "branch [branchName] [test regex]",
 		Short:         "triggers acceptance tests matching regex for a branch name",
-		Long:          `Triggers a TeamCity build for the given branch with the specified test regex passed as TEST_PATTERN/TEST_PREFIX.`,
+		Long:          `Triggers a TeamCity build for the given branch with the specified test regex passed as the test pattern build parameter(s), see --build-parameter-preset.`,
 		Aliases:       []string{"b"},
 		Args:          cobra.ExactArgs(2),
 		PreRunE:       ValidateParams([]string{"server", "build-type-id"}),
@@ -104,8 +154,18 @@ Complete documentation is available at https://github.com/katbyte/tctest`,
 			cmd.SilenceUsage = true
 			f := GetFlags()
 
-			_, _, err := f.BuildCmd(f.TC.Build.TypeID, branch, testRegEx, "")
-			return err
+			var errs []error
+			for _, cell := range f.matrixCells() {
+				spec := f.newBuildSpec(f.TC.Build.TypeID, branch, testRegEx, "")
+				applyMatrixCell(&spec, cell)
+				if err := f.waitForSlot(""); err != nil {
+					return errors.Join(append(errs, err)...)
+				}
+				if _, _, err := f.BuildCmd(spec); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
 		},
 	})
 
@@ -115,7 +175,7 @@ Complete documentation is available at https://github.com/katbyte/tctest`,
 		Long: `Discovers and triggers acceptance tests for one or more PRs (comma-separated).
 
 By default, tests are auto-discovered from the PR's changed files. If a test_regex
-is provided, it overrides auto-discovery and is sent directly as TEST_PATTERN/TEST_PREFIX.
+is provided, it overrides auto-discovery and is sent directly as the test pattern build parameter(s).
 Use --all to run all tests (sends TestAcc as the regex). A test_regex, --all, and
 --add-tests are mutually exclusive.`,
 		Args: cobra.RangeArgs(1, 2),
@@ -163,9 +223,13 @@ Use --all to run all tests (sends TestAcc as the regex). A test_regex, --all, an
 		Long: `Discovers and triggers acceptance tests for all open PRs matching the specified filters.
 
 By default, tests are auto-discovered from each PR's changed files. If a test_regex
-is provided, it overrides auto-discovery and is sent directly as TEST_PATTERN/TEST_PREFIX
+is provided, it overrides auto-discovery and is sent directly as the test pattern build parameter(s)
 for every matching PR. Use --all to run all tests (sends TestAcc as the regex).
-A test_regex, --all, and --add-tests are mutually exclusive.`,
+A test_regex, --all, and --add-tests are mutually exclusive.
+
+PRs from forks are held for review unless their author is trusted or they are labelled
+--safe-to-test-label, see --fork-policy. So are PRs changing CI
+configuration, scripts, go.mod replace directives, TestMain or sweepers, see --allow-sensitive.`,
 		Args: cobra.RangeArgs(0, 1),
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if err := ValidateParams([]string{"server", "build-type-id", "repo", "fileregex", "splitteston"})(cmd, args); err != nil {
@@ -183,7 +247,7 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 			// At this point command validation has been done so any more errors don't require help to be printed
 			cmd.SilenceUsage = true
 			f := GetFlags()
-			r := f.NewRepo()
+			f.unattended = true
 
 			cout.Printf("Filters:\n")
 			filters, err := f.GetFilters()
@@ -191,36 +255,14 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 				return fmt.Errorf("error creating filters: %w", err)
 			}
 
-			// get all pull requests
-			cout.Printf("Retrieving all prs for <white>%s</>/<cyan>%s</>...", r.Owner, r.Name)
-			prs, err := r.GetAllPullRequests(gh.PRStateOpen) // todo should this return a list not map? probably
+			_, matched, err := f.FilterPrs(filters)
 			if err != nil {
-				return fmt.Errorf("error retrieving PRs: %w", err)
+				return err
 			}
-			cout.Printf(" found <yellow>%d</>\n", len(*prs))
 
 			prTitles := make(map[int]string)
-			cout.Printf("Filtering:\n")
-			for _, pr := range *prs {
-				cout.Printf("  #<cyan>%d</> <gray>(%s)</>\n", pr.GetNumber(), pr.GetHTMLURL())
-
-				passed := true
-				for _, f := range filters {
-					ok, err := f.PR(pr)
-					if err != nil {
-						return fmt.Errorf("ERROR: running filter %s: %w", f.Name, err)
-					}
-					if !ok {
-						passed = false
-						break
-					}
-				}
-
-				if passed {
-					prTitles[pr.GetNumber()] = pr.GetTitle()
-				}
-
-				cout.Println()
+			for _, pr := range matched {
+				prTitles[pr.GetNumber()] = pr.GetTitle()
 			}
 
 			cout.Printf("testing <yellow>%d</> prs\n\n", len(prTitles))
@@ -230,9 +272,87 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 	})
 
 	root.AddCommand(&cobra.Command{
-		Use:           "list #",
-		Short:         "attempts to discover what acceptance tests to run for a PR",
-		Long:          `For a given PR number, attempts to discover and list what acceptance tests would run for it, without actually triggering a build.`,
+		Use:   "watch [-a author1,katbyte] [-l with-this-label,-not-this-label]",
+		Short: "polls for open PRs matching filters and triggers tests when they get new commits",
+		Long: `Every --watch-interval, finds the open PRs matching the prs filters and triggers discovered
+acceptance tests for each one whose head or merge SHA changed since it was last tested.
+
+The SHAs and build IDs each PR was tested with are kept in a state file under --state-dir
+(default ~/.tctest.d), so restarting watch doesn't retrigger unchanged PRs.`,
+		Args:          cobra.NoArgs,
+		PreRunE:       ValidateParams([]string{"server", "build-type-id", "repo", "fileregex", "splitteston"}),
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return GetFlags().WatchCmd()
+		},
+	})
+
+	root.AddCommand(&cobra.Command{
+		Use:   "serve --webhooks",
+		Short: "runs an HTTP server that triggers tests from GitHub webhook events",
+		Long: `Listens on --listen for GitHub webhook events at /webhooks, verifying each against --webhook-secret.
+
+A pull_request event adding one of --webhook-trigger-labels, new commits (synchronize) on a PR
+carrying one of them, or a PR comment starting with --comment-command triggers
+discovered acceptance tests for the PR, the same as 'tctest pr'. Comments are handled as
+described in 'tctest comments --help'.`,
+		Args:          cobra.NoArgs,
+		PreRunE:       ValidateParams([]string{"server", "build-type-id", "repo", "fileregex", "splitteston"}),
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return GetFlags().ServeCmd()
+		},
+	})
+
+	root.AddCommand(&cobra.Command{
+		Use:   "comments #",
+		Short: "runs the /tctest commands in a PR's comments",
+		Long: `Runs the --comment-command (default /tctest) commands in a PR's comments that haven't been
+acknowledged with a rocket reaction yet:
+
+  /tctest                             discover and run tests, like 'tctest pr'
+  /tctest TestAccFoo_basic            run an explicit test regex
+  /tctest --service network --all     run all tests for a service (--add-tests is also accepted)
+  /tctest cancel                      cancel the PR's queued and running builds
+
+Commands are only run for users with write permission on the repo or on --comment-allowlist.
+Each one is acknowledged with a rocket reaction and answered with the queued build links.`,
+		Args:          cobra.ExactArgs(1),
+		PreRunE:       ValidateParams([]string{"server", "build-type-id", "repo", "fileregex", "splitteston"}),
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pr, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("pr should be a number: %w", err)
+			}
+
+			cmd.SilenceUsage = true
+
+			f := GetFlags()
+			if f.Comments.Command == "" {
+				return errors.New("--comment-command can't be empty for the comments command")
+			}
+			return f.CommentsCmd(pr)
+		},
+	})
+
+	root.AddCommand(&cobra.Command{
+		Use:   "list #",
+		Short: "attempts to discover what acceptance tests to run for a PR",
+		Long: `For a given PR number, attempts to discover and list what acceptance tests would run for it, without actually triggering a build.
+
+With --json it prints the discovery as a JSON object: the changed files and their types, the test files and
+how each was discovered, the tests and test pattern for each service, the discovery mode and the merge SHA.
+
+With --graph it writes the graph explored by discovery, as Graphviz DOT or with --graph-format mermaid: the changed
+files, the helper packages traced at each depth, the resource files reached and the tests selected. --graph - prints
+it instead, with --quiet or --silent so it's the only output.
+
+--explain and --explain-all show the chain from the changed file to each selected test.`,
 		Args:          cobra.ExactArgs(1),
 		PreRunE:       ValidateParams([]string{"repo", "fileregex", "splitteston", "acctest-file-suffix-regexes"}),
 		SilenceErrors: true,
@@ -244,20 +364,47 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 
 			cmd.SilenceUsage = true
 
-			_, err = GetFlags().GetPrTests(pr, "")
+			f := GetFlags()
+			if err := validateGraphOutput(f.Graph, cout.Level); err != nil {
+				return err
+			}
+			d, err := f.DiscoverPrTests(pr, "", nil, nil)
+			if err != nil {
+				return err
+			}
 
-			return err
+			if f.Graph != "" {
+				if err := f.WriteGraph(d); err != nil {
+					return err
+				}
+			}
+
+			cout.PrintJSON(d)
+			return nil
 		},
 	})
 
 	resultsCmd := &cobra.Command{
-		Use:           "results #",
-		Short:         "shows the test results for a specified TC build ID",
-		Long:          "Shows the test results for a specified TC build ID. If the build is still in progress, it will warn the user that results may be incomplete.",
-		Args:          cobra.ExactArgs(1),
+		Use:   "results [#|--last]",
+		Short: "shows the test results for a specified TC build ID",
+		Long: `Shows the test results for a specified TC build ID, or with --last for each build triggered by the
+last tctest run in the audit log. If a build is still in progress, it will warn the user that results may be incomplete.`,
+		Args:          cobra.RangeArgs(0, 1),
 		PreRunE:       ValidateParams([]string{"server"}),
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			f := GetFlags()
+			if f.Last {
+				if len(args) > 0 {
+					return errors.New("a build ID can't be combined with --last")
+				}
+				cmd.SilenceUsage = true
+				return f.BuildResultsForLastRunCmd()
+			}
+			if len(args) == 0 {
+				return errors.New("a build ID or --last is required")
+			}
+
 			buildID, err := strconv.Atoi(args[0])
 			if err != nil {
 				return fmt.Errorf("build ID should be a number: %w", err)
@@ -265,7 +412,7 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 
 			cmd.SilenceUsage = true
 
-			return GetFlags().BuildResultsCmd(buildID)
+			return f.BuildResultsCmd(buildID)
 		},
 	}
 
@@ -290,6 +437,58 @@ A test_regex, --all, and --add-tests are mutually exclusive.`,
 
 	root.AddCommand(resultsCmd)
 
+	root.AddCommand(&cobra.Command{
+		Use:   "cancel [# ...|--last]",
+		Short: "cancels queued and running builds",
+		Long: `Cancels the specified TC build IDs, or with --last the builds triggered by the last tctest run in the
+audit log. Finished builds are skipped.`,
+		PreRunE:       ValidateParams([]string{"server"}),
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var buildIDs []int
+			for _, a := range args {
+				id, err := strconv.Atoi(a)
+				if err != nil {
+					return fmt.Errorf("build ID should be a number: %w", err)
+				}
+				buildIDs = append(buildIDs, id)
+			}
+
+			cmd.SilenceUsage = true
+
+			return GetFlags().CancelCmd(buildIDs)
+		},
+	})
+
+	root.AddCommand(&cobra.Command{
+		Use:   "history [--pr #] [--since 7d] [--json]",
+		Short: "shows the builds tctest has triggered and cancelled",
+		Long: `Shows the audit log of every build triggered or cancelled by tctest on this machine, kept in
+audit.jsonl under --state-dir (default ~/.tctest.d). Use --json for the full records.`,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return GetFlags().HistoryCmd()
+		},
+	})
+
+	root.AddCommand(&cobra.Command{
+		Use:   "doctor",
+		Short: "checks the configuration, credentials and local clone",
+		Long: `Checks the GitHub token, its scopes and rate limit, the TeamCity server, credentials and build
+types, git and the --local-repo-path clone, and shows where each setting came from. Every problem
+found is printed with a fix.`,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return DoctorCmd(cmd.Flags())
+		},
+	})
+
 	if err := configureFlags(root); err != nil {
 		return nil, fmt.Errorf("unable to configure flags: %w", err)
 	}
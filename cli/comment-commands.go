@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/spf13/pflag"
+)
+
+// errNotACommand is returned by ParseCommentCommand for comments that aren't tctest commands.
+var errNotACommand = errors.New("not a tctest command")
+
+// CommentCommand is a `/tctest [cancel | test_regex] [--all] [--service s1,s2] [--add-tests t1,t2]` PR comment.
+// Only these flags are accepted, so a comment can't change the server, build type or properties.
+type CommentCommand struct {
+	Cancel    bool
+	TestRegEx string
+	RunAll    bool
+	Services  []string
+	AddTests  []string
+}
+
+// ParseCommentCommand parses the first line of a comment body starting with command.
+func ParseCommentCommand(body, command string) (*CommentCommand, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != command {
+		return nil, errNotACommand
+	}
+
+	var c CommentCommand
+	fs := pflag.NewFlagSet(command, pflag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&c.RunAll, "all", false, "")
+	fs.StringSliceVar(&c.Services, "service", nil, "")
+	fs.StringSliceVar(&c.AddTests, "add-tests", nil, "")
+	if err := fs.Parse(fields[1:]); err != nil {
+		return nil, fmt.Errorf("%s: %w", command, err)
+	}
+
+	args := fs.Args()
+	switch {
+	case len(args) > 1:
+		return nil, fmt.Errorf("%s takes at most one test regex, got %q", command, strings.Join(args, " "))
+	case len(args) == 1 && args[0] == "cancel":
+		if fs.NFlag() > 0 {
+			return nil, fmt.Errorf("%s cancel doesn't take any flags", command)
+		}
+		c.Cancel = true
+		return &c, nil
+	case len(args) == 1:
+		c.TestRegEx = args[0]
+	}
+
+	// the same combinations the CLI rejects
+	if c.TestRegEx != "" && (c.RunAll || len(c.AddTests) > 0) {
+		return nil, fmt.Errorf("%s: a test regex can't be combined with --all or --add-tests", command)
+	}
+	if c.RunAll && len(c.AddTests) > 0 {
+		return nil, fmt.Errorf("%s: --add-tests can't be combined with --all", command)
+	}
+
+	return &c, nil
+}
+
+// apply returns a copy of the flags with the command's arguments in place of the CLI's, and the test regex. A command
+// from someone who can push is the review, so it isn't held, but one from a user only on --comment-allowlist still
+// is when the PR is from an untrusted fork or changes sensitive files.
+func (c CommentCommand) apply(f *FlagData, canPush bool) (*FlagData, string) {
+	cf := *f
+	cf.RunAllTests = c.RunAll
+	cf.Services = c.Services
+	cf.AddTests = c.AddTests
+	cf.Triggered = nil
+	cf.unattended = !canPush
+	return &cf, c.TestRegEx
+}
+
+// writePermissions are the repo permissions that can push, which may run commands without being on
+// --comment-allowlist.
+var writePermissions = []string{"admin", "maintain", "write"}
+
+// canRunCommentCommands returns whether the user may run commands, being on the allowlist or able to push to the
+// repo, and whether they can push.
+func (f *FlagData) canRunCommentCommands(user string) (allowed, canPush bool, err error) {
+	perm, err := f.NewRepo().GetPermissionLevel(user)
+	if err != nil {
+		return false, false, err
+	}
+	canPush = slices.Contains(writePermissions, perm)
+	return canPush || slices.Contains(f.Comments.Allowlist, user), canPush, nil
+}
+
+// The reactions tctest marks a command comment handled with: run, denied and unparseable.
+const (
+	reactionRun        = "rocket"
+	reactionDenied     = "-1"
+	reactionUnparsable = "confused"
+)
+
+var handledReactions = []string{reactionRun, reactionDenied, reactionUnparsable}
+
+// HandleCommentCommand runs a PR comment's tctest command: it checks the author may run commands, acknowledges
+// the comment with a 🚀 reaction, triggers (or cancels) the builds and replies with the build links. Commands from
+// users who may not run them get a 👎 and no reply, ones that don't parse a 😕 and a reply saying why.
+// Comments that aren't commands are ignored.
+func (f *FlagData) HandleCommentCommand(pr int, title string, comment *github.IssueComment) error {
+	c, parseErr := ParseCommentCommand(comment.GetBody(), f.Comments.Command)
+	if errors.Is(parseErr, errNotACommand) {
+		return nil
+	}
+
+	// checked before anything is posted, so only those who may run commands can make tctest comment
+	r := f.NewRepo()
+	user := comment.GetUser().GetLogin()
+	ok, canPush, err := f.canRunCommentCommands(user)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		cout.Printf("  ignoring command from <yellow>%s</>: no write permission and not on --comment-allowlist\n", user)
+		return r.AddCommentReaction(comment.GetID(), reactionDenied)
+	}
+
+	// each reaction marks the comment handled before replying, so the comments command won't reply again
+	if parseErr != nil {
+		if err := r.AddCommentReaction(comment.GetID(), reactionUnparsable); err != nil {
+			return err
+		}
+		return r.CreateComment(pr, fmt.Sprintf("@%s unable to parse the command: %v", user, parseErr))
+	}
+
+	// acknowledged first, so the comments command won't run it again even if triggering fails
+	if err := r.AddCommentReaction(comment.GetID(), reactionRun); err != nil {
+		return err
+	}
+
+	if c.Cancel {
+		return f.cancelPrBuilds(pr, user)
+	}
+
+	cf, testRegEx := c.apply(f, canPush)
+	runErr := cf.GetAndRunPrsTests(map[int]string{pr: title}, testRegEx)
+
+	var reply strings.Builder
+	if len(cf.Triggered) > 0 {
+		fmt.Fprintf(&reply, "@%s triggered %d build(s):\n\n", user, len(cf.Triggered))
+		for _, b := range cf.Triggered {
+			name := b.Service
+			if name == "" {
+				name = b.BuildTypeID
+			}
+			if b.Matrix != "" {
+				name += " (" + b.Matrix + ")"
+			}
+			fmt.Fprintf(&reply, "- %s: [build %d](%s)\n", name, b.BuildID, b.URL)
+		}
+	} else if len(cf.Held) > 0 {
+		fmt.Fprintf(&reply, "@%s the PR is held for review, a command from someone who can push to the repo will test it", user)
+	} else if !f.DryRun {
+		fmt.Fprintf(&reply, "@%s no builds were triggered", user)
+	}
+	if runErr != nil {
+		fmt.Fprintf(&reply, "\n\n:warning: %v", runErr)
+	}
+
+	if f.DryRun {
+		clog.Log.Debugf("dry run, not replying:\n%s", reply.String())
+		return runErr
+	}
+	if err := r.CreateComment(pr, reply.String()); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return runErr
+}
+
+// cancelPrBuilds cancels the queued and running builds tctest triggered for a PR, in any build type, and replies with
+// what was cancelled.
+func (f *FlagData) cancelPrBuilds(pr int, user string) error {
+	server, err := f.NewTCServer()
+	if err != nil {
+		return err
+	}
+
+	var cancelled []string
+	var errs []error
+	builds, err := server.GetActiveBuildsWithTag(tctestTag, prBranch(pr))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, b := range builds {
+		cout.Printf("  cancelling %s build <green>%d</> @ <darkGray>%s</>\n", b.State, b.ID, b.BuildTypeID)
+		if f.DryRun {
+			continue
+		}
+		if err := server.CancelBuild(b.ID, b.State == "queued", "cancelled by "+user+" via "+f.Comments.Command+" cancel"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cancelled = append(cancelled, fmt.Sprintf("- [build %d](%s) (%s)", b.ID, b.URL, b.State))
+		f.auditCancel(AuditRecord{PR: pr, Branch: b.Branch, BuildTypeID: b.BuildTypeID, BuildID: b.ID, URL: b.URL})
+	}
+
+	reply := fmt.Sprintf("@%s no queued or running builds to cancel", user)
+	if len(cancelled) > 0 {
+		reply = fmt.Sprintf("@%s cancelled %d build(s):\n\n%s", user, len(cancelled), strings.Join(cancelled, "\n"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		reply += "\n\n:warning: " + err.Error()
+	}
+
+	if f.DryRun {
+		return errors.Join(errs...)
+	}
+	return errors.Join(append(errs, f.NewRepo().CreateComment(pr, reply))...)
+}
+
+// commentHandled returns whether tctest, logged in as bot, has already reacted to a command comment. Reactions from
+// anyone else don't count, or they could stop a command from running.
+func (f *FlagData) commentHandled(c *github.IssueComment, bot string) (bool, error) {
+	if c.GetReactions().GetTotalCount() == 0 {
+		return false, nil
+	}
+
+	reactions, err := f.NewRepo().ListCommentReactions(c.GetID())
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(reactions, func(r *github.Reaction) bool {
+		return strings.EqualFold(r.GetUser().GetLogin(), bot) && slices.Contains(handledReactions, r.GetContent())
+	}), nil
+}
+
+// CommentsCmd polls a PR's comments and runs the tctest commands it hasn't reacted to yet.
+func (f *FlagData) CommentsCmd(pr int) error {
+	r := f.NewRepo()
+
+	ghpr, err := r.GetPullRequest(pr)
+	if err != nil {
+		return err
+	}
+
+	bot, err := r.TokenInfo()
+	if err != nil {
+		return fmt.Errorf("finding the user tctest reacts to comments as: %w", err)
+	}
+
+	comments, err := r.ListIssueComments(pr)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	handled := 0
+	for _, c := range comments {
+		if !isCommand(c.GetBody(), f.Comments.Command) {
+			continue
+		}
+		done, err := f.commentHandled(c, bot.Login)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if done {
+			clog.Log.Debugf("comment %d already handled", c.GetID())
+			continue
+		}
+
+		cout.Printf("PR <cyan>#%d</> comment by <yellow>%s</>: <darkGray>%s</>\n", pr, c.GetUser().GetLogin(), strings.TrimSpace(strings.SplitN(c.GetBody(), "\n", 2)[0]))
+		if err := f.HandleCommentCommand(pr, ghpr.GetTitle(), c); err != nil {
+			cout.Errorf("  <red>ERROR:</> %v\n", err)
+			errs = append(errs, err)
+		}
+		handled++
+	}
+
+	cout.Printf("handled <yellow>%d</> new %s command(s)\n", handled, f.Comments.Command)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommentCommand(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]CommentCommand{
+		"/tctest":                             {},
+		"/tctest\r\nplease run the tests":     {},
+		"/tctest TestAccFoo_basic":            {TestRegEx: "TestAccFoo_basic"},
+		"/tctest --service network --all":     {RunAll: true, Services: []string{"network"}},
+		"/tctest --add-tests TestA,TestB":     {AddTests: []string{"TestA", "TestB"}},
+		"  /tctest   cancel  ":                {Cancel: true},
+		"/tctest --service=network,dns --all": {RunAll: true, Services: []string{"network", "dns"}},
+	}
+	for body, want := range cases {
+		got, err := ParseCommentCommand(body, "/tctest")
+		if err != nil {
+			t.Errorf("ParseCommentCommand(%q) unexpected error: %v", body, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("ParseCommentCommand(%q) = %+v, want %+v", body, *got, want)
+		}
+	}
+
+	for _, body := range []string{"looks good", "/tctestx", "please /tctest", ""} {
+		if _, err := ParseCommentCommand(body, "/tctest"); !errors.Is(err, errNotACommand) {
+			t.Errorf("ParseCommentCommand(%q) error = %v, want errNotACommand", body, err)
+		}
+	}
+
+	for _, body := range []string{
+		"/tctest TestA TestB",
+		"/tctest TestA --all",
+		"/tctest --all --add-tests TestA",
+		"/tctest cancel --all",
+		"/tctest --properties X=1",
+		"/tctest --build-type-id Other",
+	} {
+		if _, err := ParseCommentCommand(body, "/tctest"); err == nil || errors.Is(err, errNotACommand) {
+			t.Errorf("ParseCommentCommand(%q) error = %v, want a parse error", body, err)
+		}
+	}
+}
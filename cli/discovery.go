@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// Discovery is what test discovery found for a PR, and the schema of list --json. Fields are only ever added.
+type Discovery struct {
+	PR           int                `json:"pr"`
+	Title        string             `json:"title"`
+	URL          string             `json:"url"`
+	Mode         string             `json:"mode"` // AST, types or api
+	MergeSHA     string             `json:"merge_sha"`
+	ChangedFiles []DiscoveryFile    `json:"changed_files"`
+	TestFiles    []DiscoveryFile    `json:"test_files"`
+	Services     []DiscoveryService `json:"services"`
+
+	serviceTests map[string][]string
+	smokeTests   map[string][]string         // the smoke tests of each service among the discovered tests
+	testChains   map[string][]provider.Chain // test -> how it was discovered
+	explored     []provider.Chain            // every package and resource file tracing reached
+}
+
+type DiscoveryFile struct {
+	Path         string   `json:"path"`
+	Service      string   `json:"service,omitempty"`
+	Type         string   `json:"type"`                    // RESOURCE, HELPER, TEST, UNIT, VENDOR or OTHER
+	DiscoveredBy []string `json:"discovered_by,omitempty"` // test files: CHANGED, DERIVED, TRACED, VENDOR and/or MODULE
+	Skipped      bool     `json:"skipped,omitempty"`       // changed files no tests were derived from
+}
+
+type DiscoveryService struct {
+	Service string            `json:"service"`
+	Tests   []string          `json:"tests"`
+	Sources map[string]string `json:"sources,omitempty"` // test -> where it came from, when the PR description has test directives
+	Pattern string            `json:"pattern"`           // the test pattern a build for the service is sent
+	Smoke   []string          `json:"smoke,omitempty"`   // the tests run by smoke suites rather than discovered
+
+	// Explain is why each test was selected, for the tests asked about with --explain or --explain-all
+	Explain map[string][]provider.Chain `json:"explain,omitempty"`
+}
+
+func newDiscoveryFile(pf provider.File) DiscoveryFile {
+	return DiscoveryFile{
+		Path:         pf.RelPath,
+		Service:      pf.Service,
+		Type:         pf.Type.String(),
+		DiscoveredBy: pf.DiscoveredBy,
+	}
+}
+
+func newDiscoveryFiles(files []provider.File) []DiscoveryFile {
+	out := make([]DiscoveryFile, 0, len(files))
+	for _, pf := range files {
+		out = append(out, newDiscoveryFile(pf))
+	}
+	return out
+}
+
+// ServiceTests returns the tests to run for each service.
+func (d *Discovery) ServiceTests() map[string][]string {
+	return d.serviceTests
+}
+
+// setServiceTests sets the tests for each service, sources being where each test came from when test directives
+// were applied.
+func (d *Discovery) setServiceTests(f *FlagData, serviceTests map[string][]string, sources map[string]map[string]string) {
+	d.serviceTests = serviceTests
+	d.Services = make([]DiscoveryService, 0, len(serviceTests))
+	for _, service := range slices.Sorted(maps.Keys(serviceTests)) {
+		tests := serviceTests[service]
+		if tests == nil {
+			tests = []string{}
+		}
+		ds := DiscoveryService{
+			Service: service,
+			Tests:   tests,
+			Sources: sources[service],
+			Pattern: f.discoveredTestPattern(tests),
+		}
+		for _, t := range tests {
+			if slices.Contains(d.smokeTests[service], t) {
+				ds.Smoke = append(ds.Smoke, t)
+			}
+		}
+		for _, t := range tests {
+			if !f.explains(t) {
+				continue
+			}
+			if ds.Explain == nil {
+				ds.Explain = map[string][]provider.Chain{}
+			}
+			ds.Explain[t] = d.chains(t, sources[service][t])
+		}
+		d.Services = append(d.Services, ds)
+	}
+}
+
+// addTestChains records the chains of the tests extracted from a test file, each being one of the file's chains
+// ending at the test.
+func addTestChains(testChains map[string][]provider.Chain, pf provider.File, tests []string) {
+	chains := pf.Chains
+	if len(chains) == 0 {
+		chains = []provider.Chain{{{Kind: provider.LinkTestFile, Value: pf.RelPath}}}
+	}
+	for _, t := range tests {
+		for _, c := range chains {
+			// split test names repeat, e.g. TestAccFoo_basic and TestAccFoo_complete are both TestAccFoo
+			tc := c.Then(provider.LinkTest, t)
+			if !slices.ContainsFunc(testChains[t], func(e provider.Chain) bool { return e.String() == tc.String() }) {
+				testChains[t] = append(testChains[t], tc)
+			}
+		}
+	}
+}
+
+// chains returns why a test was selected, source being where it came from when test directives were applied.
+func (d *Discovery) chains(test, source string) []provider.Chain {
+	if source == testSourcePrBody {
+		return []provider.Chain{{{Kind: provider.LinkDirective, Value: "tctest directive in the PR description"}}}
+	}
+	return d.testChains[test]
+}
+
+// explains returns whether --explain or --explain-all asks why a discovered test was selected. Discovered tests are
+// split on --splitteston, so --explain TestAccFoo_basic explains TestAccFoo.
+func (f *FlagData) explains(test string) bool {
+	if f.ExplainAll {
+		return true
+	}
+	return slices.ContainsFunc(f.Explain, func(e string) bool { return f.explainMatches(e, test) })
+}
+
+func (f *FlagData) explainMatches(name, test string) bool {
+	if name == test {
+		return true
+	}
+	split := f.DiscoveryConfig.SplitTestsOn
+	return split != "" && strings.HasPrefix(name, strings.TrimSuffix(test, split)+split)
+}
+
+// printExplanations prints why each test asked about with --explain or --explain-all was selected.
+func (f *FlagData) printExplanations(d *Discovery) {
+	if !f.ExplainAll && len(f.Explain) == 0 {
+		return
+	}
+
+	explained := map[string]bool{}
+	for _, ds := range d.Services {
+		for _, t := range ds.Tests {
+			chains, ok := ds.Explain[t]
+			if !ok {
+				continue
+			}
+			explained[t] = true
+			cout.Printf("  why <cyan>%s</> <darkGray>(%s)</>:\n", t, ds.Service)
+			// the chains from dependency bumps are grouped by module, after the others
+			module := ""
+			for _, c := range slices.SortedStableFunc(slices.Values(chains), func(a, b provider.Chain) int {
+				return strings.Compare(chainModule(a), chainModule(b))
+			}) {
+				indent := "    "
+				if m := chainModule(c); m != "" {
+					if m != module {
+						cout.Printf("    module <fg=177>%s</>:\n", m)
+						module = m
+					}
+					indent = "      "
+				}
+				for i, l := range c {
+					arrow := ""
+					if i > 0 {
+						arrow = "  → "
+					}
+					cout.Printf("%s%s%s <darkGray>[%s]</>\n", indent, arrow, l.Value, l.Kind)
+				}
+			}
+			if len(chains) == 0 {
+				cout.Printf("    <darkGray>no provenance recorded</>\n")
+			}
+		}
+	}
+
+	for _, e := range f.Explain {
+		if slices.ContainsFunc(slices.Collect(maps.Keys(explained)), func(t string) bool { return f.explainMatches(e, t) }) {
+			continue
+		}
+		if slices.Contains(f.AddTests, e) {
+			cout.Printf("  why <cyan>%s</>: <darkGray>added by --add-tests</>\n", e)
+			continue
+		}
+		cout.Printf("  why <cyan>%s</>: <yellow>not selected</>\n", e)
+	}
+}
+
+// discoveredTestPattern returns the test pattern for discovered tests: TestAcc with --all, otherwise the tests and
+// --add-tests, or "" when there are none.
+func (f *FlagData) discoveredTestPattern(tests []string) string {
+	if f.RunAllTests {
+		return "TestAcc"
+	}
+
+	all := append(append([]string{}, tests...), f.AddTests...)
+	if len(all) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(all, "|") + ")"
+}
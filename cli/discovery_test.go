@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func TestDiscoverySetServiceTests(t *testing.T) {
+	t.Parallel()
+
+	f := &FlagData{AddTests: []string{"TestAccExtra"}}
+	d := &Discovery{}
+	d.setServiceTests(f, map[string][]string{
+		"postgres": {"TestAccPostgresqlFlexibleServer"},
+		"dns":      {"TestAccDnsARecord", "TestAccDnsZone"},
+		"cosmos":   nil,
+	}, nil)
+
+	want := []struct{ service, pattern string }{
+		{"cosmos", "(TestAccExtra)"},
+		{"dns", "(TestAccDnsARecord|TestAccDnsZone|TestAccExtra)"},
+		{"postgres", "(TestAccPostgresqlFlexibleServer|TestAccExtra)"},
+	}
+	if len(d.Services) != len(want) {
+		t.Fatalf("services = %+v, want %d", d.Services, len(want))
+	}
+	for i, w := range want {
+		if d.Services[i].Service != w.service || d.Services[i].Pattern != w.pattern {
+			t.Errorf("services[%d] = %s %s, want %s %s", i, d.Services[i].Service, d.Services[i].Pattern, w.service, w.pattern)
+		}
+	}
+	if d.Services[0].Tests == nil {
+		t.Error("a service without tests has null tests, want []")
+	}
+
+	f.RunAllTests = true
+	if got := f.discoveredTestPattern(nil); got != "TestAcc" {
+		t.Errorf("pattern with --all = %q, want TestAcc", got)
+	}
+	if got := (&FlagData{}).discoveredTestPattern(nil); got != "" {
+		t.Errorf("pattern without tests = %q, want none", got)
+	}
+}
+
+func TestDiscoveryExplain(t *testing.T) {
+	t.Parallel()
+
+	changed := provider.Chain{{Kind: provider.LinkChangedFile, Value: "internal/services/dns/dns_a_record_resource.go"}}
+	pf := provider.NewFile("internal/services/dns/dns_a_record_resource_test.go")
+	pf.AddChain(changed)
+	pf.AddChain(changed) // duplicates are ignored
+
+	d := &Discovery{testChains: map[string][]provider.Chain{}}
+	addTestChains(d.testChains, pf, []string{"TestAccDnsARecord"})
+
+	f := &FlagData{Explain: []string{"TestAccDnsARecord_basic"}}
+	f.DiscoveryConfig.SplitTestsOn = "_"
+	d.setServiceTests(f, map[string][]string{
+		"dns": {"TestAccDnsARecord", "TestAccDnsARecordSet", "TestAccExtra"},
+	}, map[string]map[string]string{
+		"dns": {"TestAccDnsARecord": testSourceDiscovered, "TestAccDnsARecordSet": testSourceDiscovered, "TestAccExtra": testSourcePrBody},
+	})
+
+	explain := d.Services[0].Explain
+	if len(explain) != 1 {
+		t.Fatalf("explained %v, want only TestAccDnsARecord", explain)
+	}
+	want := "internal/services/dns/dns_a_record_resource.go [changed file] → " +
+		"internal/services/dns/dns_a_record_resource_test.go [test file] → TestAccDnsARecord [test]"
+	if c := explain["TestAccDnsARecord"]; len(c) != 1 || c[0].String() != want {
+		t.Errorf("chains = %v, want [%s]", c, want)
+	}
+
+	f.ExplainAll = true
+	d.setServiceTests(f, d.serviceTests, map[string]map[string]string{"dns": {"TestAccExtra": testSourcePrBody}})
+	if c := d.Services[0].Explain["TestAccExtra"]; len(c) != 1 || c[0][0].Kind != provider.LinkDirective {
+		t.Errorf("chains for a PR description test = %v, want a directive", c)
+	}
+}
@@ -0,0 +1,348 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/gh"
+	"github.com/katbyte/tctest/lib/git"
+	"github.com/katbyte/tctest/lib/tc"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// doctorSettings are the settings doctor shows the value and source of.
+var doctorSettings = []string{
+	"server", "token-tc", "username", "build-type-id", "build-type-id-add-service-suffix", "service-map",
+	"token-gh", "repo", "fileregex", "acctest-file-suffix-regexes", "mode", "local-repo-path", "state-dir",
+}
+
+// doctorSecrets are masked when shown.
+var doctorSecrets = []string{"token-tc", "token-gh"}
+
+// doctorMinRateLimit is the remaining GitHub requests below which doctor warns, roughly a large PR's discovery.
+const doctorMinRateLimit = 100
+
+// doctor prints the result of each check as it runs, with a fix for those that fail.
+type doctor struct {
+	failed, warned int
+}
+
+func (d *doctor) section(title string) {
+	cout.Printf("\n<white>%s</>\n", title)
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	cout.Printf("  <green>OK</>    "+format+"\n", args...)
+}
+
+func (d *doctor) info(format string, args ...any) {
+	cout.Printf("  <darkGray>--</>    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(fix, format string, args ...any) {
+	d.warned++
+	cout.Printf("  <yellow>WARN</>  "+format+"\n", args...)
+	cout.Printf("        <darkGray>fix:</> %s\n", fix)
+}
+
+func (d *doctor) fail(fix, format string, args ...any) {
+	d.failed++
+	cout.Printf("  <red>FAIL</>  "+format+"\n", args...)
+	cout.Printf("        <darkGray>fix:</> %s\n", fix)
+}
+
+// settingSource returns where a setting's value came from, in the order viper resolves them.
+func settingSource(flags *pflag.FlagSet, name string) string {
+	if fl := flags.Lookup(name); fl != nil && fl.Changed {
+		return "--" + name
+	}
+	if env := flagEnvVars[name]; env != "" && os.Getenv(env) != "" {
+		return "$" + env
+	}
+	if activeProfile != nil {
+		if _, ok := activeProfile.Settings[name]; ok {
+			return "profile " + activeProfileName
+		}
+	}
+	if viper.InConfig(name) {
+		return viper.ConfigFileUsed()
+	}
+	if src, ok := detectedDefaults[name]; ok {
+		return src
+	}
+	return "default"
+}
+
+// DoctorCmd checks the configuration, GitHub, TeamCity and git, returning an error if any check failed.
+func DoctorCmd(flags *pflag.FlagSet) error {
+	d := &doctor{}
+
+	if d.checkConfig(flags) {
+		f := GetFlags()
+		r := d.checkGitHub(f)
+		d.checkTeamCity(f, r)
+		d.checkGit(f, r)
+	}
+
+	cout.Println()
+	switch {
+	case d.failed > 0:
+		return fmt.Errorf("%d check(s) failed and %d warned, see the fixes above", d.failed, d.warned)
+	case d.warned > 0:
+		cout.Printf("<yellow>%d warning(s)</>, see the fixes above\n", d.warned)
+	default:
+		cout.Printf("<green>everything looks good</>\n")
+	}
+	return nil
+}
+
+// checkConfig shows where each setting came from, and returns false if the discovery regexes don't compile.
+func (d *doctor) checkConfig(flags *pflag.FlagSet) bool {
+	d.section("Configuration")
+
+	if p := viper.ConfigFileUsed(); p != "" {
+		d.info("config file %s", p)
+	} else {
+		d.info("no .tctest config file in the current or home directory")
+	}
+	if activeProfileName != "" {
+		d.info("profile %s", activeProfileName)
+	}
+
+	for _, name := range doctorSettings {
+		v := viper.GetString(name)
+		if s := viper.GetStringSlice(name); len(s) > 1 {
+			v = strings.Join(s, ",")
+		}
+		if v != "" && slices.Contains(doctorSecrets, name) {
+			v = maskToken(v)
+		}
+		if v == "" {
+			v = "<darkGray>(empty)</>"
+		}
+		cout.Printf("        %-34s %s <darkGray>(%s)</>\n", name, v, settingSource(flags, name))
+	}
+
+	valid := true
+	if _, err := regexp.Compile(viper.GetString("fileregex")); err != nil {
+		d.fail("correct --fileregex", "--fileregex is invalid: %v", err)
+		valid = false
+	}
+	for _, s := range viper.GetStringSlice("acctest-file-suffix-regexes") {
+		if _, err := regexp.Compile(s); err != nil {
+			d.fail("correct --acctest-file-suffix-regexes", "--acctest-file-suffix-regexes entry %q is invalid: %v", s, err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// checkGitHub checks the token, rate limit and repo, returning the repo when it's accessible.
+func (d *doctor) checkGitHub(f *FlagData) *GithubRepo {
+	d.section("GitHub")
+
+	r := gh.NewRepo("", "", f.GH.Token)
+	r.APIURL = f.GH.APIURL
+
+	if f.GH.Token == "" {
+		d.warn("create a token at https://github.com/settings/tokens and export it as GITHUB_TOKEN",
+			"no token, requests are unauthenticated and limited to 60 an hour")
+	} else if info, err := r.TokenInfo(); err != nil {
+		d.fail("create a new token at https://github.com/settings/tokens and export it as GITHUB_TOKEN", "token: %v", err)
+	} else {
+		d.ok("token authenticates as <cyan>%s</>", info.Login)
+		switch {
+		case !info.Classic:
+			d.info("fine-grained token, it needs read access to contents and pull requests, and write to issues for --comment")
+		case slices.Contains(info.Scopes, "repo") || slices.Contains(info.Scopes, "public_repo"):
+			d.ok("token scopes: %s", strings.Join(info.Scopes, ", "))
+		default:
+			d.warn("add the public_repo scope (repo for private repositories) to the token",
+				"token scopes [%s] can't post comments, reactions or labels", strings.Join(info.Scopes, ", "))
+		}
+	}
+
+	if rate, err := r.CoreRateLimit(); err != nil {
+		d.warn("check GitHub is reachable from here", "rate limit: %v", err)
+	} else {
+		resets := time.Until(rate.Reset.Time).Round(time.Minute)
+		if rate.Remaining < doctorMinRateLimit {
+			d.warn(fmt.Sprintf("wait %s for the limit to reset, or use a token with its own limit", resets),
+				"only %d of %d GitHub requests left", rate.Remaining, rate.Limit)
+		} else {
+			d.ok("rate limit: %d of %d requests left, resets in %s", rate.Remaining, rate.Limit, resets)
+		}
+	}
+
+	if f.GH.Repo == "" {
+		d.fail("set --repo or TCTEST_REPO, or run tctest inside a clone of the provider", "no repo set")
+		return nil
+	}
+	if parts := strings.Split(f.GH.Repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		d.fail("set --repo to owner/name, ie hashicorp/terraform-provider-azurerm", "--repo %q isn't owner/name", f.GH.Repo)
+		return nil
+	}
+
+	ghr := f.NewRepo()
+	if _, err := ghr.Get(); err != nil {
+		d.fail("check --repo, and that the token can read the repository", "%v", err)
+		return nil
+	}
+	d.ok("%s is accessible", f.GH.Repo)
+	return &ghr
+}
+
+// checkTeamCity checks the server, credentials and build types, using the repo's services when the build type
+// has a per-service suffix.
+func (d *doctor) checkTeamCity(f *FlagData, ghr *GithubRepo) {
+	d.section("TeamCity")
+
+	if f.TC.ServerURL == "" {
+		d.fail("set --server or TCTEST_SERVER", "no server set")
+		return
+	}
+	server, err := f.NewTCServer()
+	if err != nil {
+		d.fail("create an access token in TeamCity under Profile > Access Tokens and export it as TCTEST_TOKEN_TC", "no credentials")
+		return
+	}
+
+	version, err := server.Version()
+	switch {
+	case errors.Is(err, tc.ErrUnauthorised):
+		d.fail("check --token-tc (or --username and --password), TeamCity access tokens expire", "%s rejected the credentials", f.TC.ServerURL)
+		return
+	case err != nil:
+		d.fail("check --server is the TeamCity URL and reachable from here", "%v", err)
+		return
+	}
+	d.ok("%s is reachable and the credentials work (TeamCity %s)", f.TC.ServerURL, version)
+
+	if f.TC.Build.TypeID == "" {
+		d.fail("set --build-type-id or TCTEST_BUILD_TYPE_ID", "no build type set")
+		return
+	}
+
+	ids, err := server.BuildTypeIDs()
+	if err != nil {
+		d.fail("check the credentials can view the project", "%v", err)
+		return
+	}
+
+	var services []string
+	for s := range f.TC.Build.ServiceMap {
+		services = append(services, s)
+	}
+	if f.TC.Build.AddServiceSuffix {
+		if ghr == nil {
+			d.warn("fix the GitHub checks above", "can't list the repo's services to check the per-service build types")
+		} else if listed, err := ghr.ListServices(f.DiscoveryConfig.ServiceDirs); err != nil {
+			d.warn("check --repo is a provider repository", "listing services: %v", err)
+		} else {
+			services = append(services, listed...)
+		}
+	} else {
+		if !slices.Contains(ids, f.TC.Build.TypeID) {
+			d.fail("check --build-type-id, the ID is shown in the build configuration's settings", "build type %s doesn't exist", f.TC.Build.TypeID)
+		} else {
+			d.ok("build type %s exists", f.TC.Build.TypeID)
+		}
+	}
+	if len(services) == 0 {
+		return
+	}
+
+	slices.Sort(services)
+	services = slices.Compact(services)
+
+	var missing []string
+	checked := 0
+	for _, s := range services {
+		for _, id := range f.serviceBuildTypeIDs(s) {
+			checked++
+			if !slices.Contains(ids, id) {
+				missing = append(missing, id)
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		d.ok("all %d per-service build types exist", checked)
+		return
+	}
+	shown := missing
+	if len(shown) > 10 {
+		shown = append(shown[:10:10], fmt.Sprintf("and %d more", len(missing)-10))
+	}
+	d.warn("create them in TeamCity, or map the services to existing build types with --service-map",
+		"%d of %d per-service build types don't exist: %s", len(missing), checked, strings.Join(shown, ", "))
+}
+
+// checkGit checks git is installed, and that the local repo used for AST discovery is usable.
+func (d *doctor) checkGit(f *FlagData, ghr *GithubRepo) {
+	d.section("git")
+
+	version, err := git.Version()
+	if err != nil {
+		d.fail("install git, AST discovery runs it", "%v", err)
+		return
+	}
+	d.ok("git %s", version)
+
+	if !isLocalMode(f.DiscoveryConfig.Mode) {
+		d.info("--mode %s doesn't use a local clone", f.DiscoveryConfig.Mode)
+		return
+	}
+	if ghr == nil {
+		d.warn("fix the GitHub checks above", "can't check the local clone without a repo")
+		return
+	}
+
+	repoPath := f.DiscoveryConfig.LocalRepoPath
+	if repoPath == "" {
+		cwd, err := os.Getwd()
+		if err != nil || !git.IsRepoForRemote(cwd, ghr.CloneURL()) {
+			d.info("--local-repo-path isn't set and the current directory isn't a clone of %s, discovery falls back to the GitHub API", f.GH.Repo)
+			return
+		}
+		repoPath = cwd
+		d.info("the current directory is a clone of %s, AST discovery uses it", f.GH.Repo)
+	}
+
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		if entries, err := os.ReadDir(repoPath); err != nil || len(entries) == 0 {
+			d.info("%s doesn't exist or is empty, tctest will clone %s into it", repoPath, f.GH.Repo)
+			return
+		}
+		d.fail("point --local-repo-path at a clone of "+f.GH.Repo+", or an empty directory to clone into", "%s isn't a git repository", repoPath)
+		return
+	}
+
+	if !git.IsRepoForRemote(repoPath, ghr.CloneURL()) {
+		d.fail("set its origin remote to "+ghr.CloneURL()+", PR merge refs are fetched from origin", "%s isn't a clone of %s", repoPath, f.GH.Repo)
+	} else {
+		d.ok("%s is a clone of %s", repoPath, f.GH.Repo)
+	}
+
+	if dirty, _, err := git.IsWorkingTreeDirty(repoPath); err != nil {
+		d.fail("check "+repoPath+" is a working git clone", "%v", err)
+	} else if dirty {
+		d.warn("commit or stash the changes, AST discovery checks out each PR and asks to discard them", "%s has uncommitted changes", repoPath)
+	} else {
+		d.ok("working tree is clean")
+	}
+
+	if err := git.CanFetch(repoPath); err != nil {
+		d.fail("check network access and the git credentials for origin", "fetching from origin: %v", err)
+	} else {
+		d.ok("fetching from origin works")
+	}
+}
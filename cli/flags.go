@@ -12,6 +12,7 @@ import (
 	"github.com/katbyte/tctest/lib/provider"
 	"github.com/katbyte/tctest/lib/tc"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -77,14 +78,79 @@ func validateTestRegexFlags(regexIdx int) func(cmd *cobra.Command, args []string
 }
 
 type FlagData struct {
-	GH              FlagsGitHub     `mapstructure:",squash"`
-	TC              FlagsTeamCity   `mapstructure:",squash"`
-	DiscoveryConfig DiscoveryConfig `mapstructure:",squash"`
-	OpenInBrowser   bool            `mapstructure:"open"`
-	RunAllTests     bool            `mapstructure:"all"`
-	Services        []string        `mapstructure:"service"`
-	DryRun          bool            `mapstructure:"dry-run"`
-	AddTests        []string        `mapstructure:"add-tests"`
+	GH                 FlagsGitHub     `mapstructure:",squash"`
+	TC                 FlagsTeamCity   `mapstructure:",squash"`
+	DiscoveryConfig    DiscoveryConfig `mapstructure:",squash"`
+	OpenInBrowser      bool            `mapstructure:"open"`
+	RunAllTests        bool            `mapstructure:"all"`
+	Services           []string        `mapstructure:"service"`
+	DryRun             bool            `mapstructure:"dry-run"`
+	AddTests           []string        `mapstructure:"add-tests"`
+	IgnorePrDirectives bool            `mapstructure:"ignore-pr-directives"`
+	Explain            []string        `mapstructure:"explain"`
+	ExplainAll         bool            `mapstructure:"explain-all"`
+	Graph              string          `mapstructure:"graph"`
+	GraphFormat        string          `mapstructure:"graph-format"`
+	StateDir           string          `mapstructure:"state-dir"`
+	Watch              FlagsWatch      `mapstructure:",squash"`
+	Serve              FlagsServe      `mapstructure:",squash"`
+	Comments           FlagsComments   `mapstructure:",squash"`
+	Trust              FlagsTrust      `mapstructure:",squash"`
+	History            FlagsHistory    `mapstructure:",squash"`
+	Last               bool            `mapstructure:"last"`
+
+	// Triggered collects the builds triggered by this run, Held the PRs held for review and FailedServices the
+	// services whose builds failed to trigger (not set from flags)
+	Triggered      []TriggeredBuild `mapstructure:"-"`
+	Held           []int            `mapstructure:"-"`
+	FailedServices []string         `mapstructure:"-"`
+
+	// retryServices limits watch's retry of a PR to the services that failed to trigger
+	retryServices []string
+
+	// unattended is set by the commands that pick PRs without a person, it holds untrusted fork PRs and sensitive
+	// changes for review
+	unattended bool
+
+	// runID groups the audit log records of the builds triggered together, discoveryMode is how GetPrTests found
+	// the tests
+	runID         string
+	discoveryMode string
+
+	// triggers is the audit log's latest trigger of each build, shared by the copies of the flags
+	triggers *auditTriggers
+}
+
+type FlagsHistory struct {
+	PR    int    `mapstructure:"pr"`
+	Since string `mapstructure:"since"`
+}
+
+type FlagsTrust struct {
+	ForkPolicy      string   `mapstructure:"fork-policy"`
+	Authors         []string `mapstructure:"trusted-authors"`
+	Orgs            []string `mapstructure:"trusted-orgs"`
+	SafeToTestLabel string   `mapstructure:"safe-to-test-label"`
+	AllowSensitive  bool     `mapstructure:"allow-sensitive"`
+	SensitiveLabel  string   `mapstructure:"allow-sensitive-label"`
+}
+
+type FlagsServe struct {
+	Webhooks      bool     `mapstructure:"webhooks"`
+	Listen        string   `mapstructure:"listen"`
+	WebhookSecret string   `mapstructure:"webhook-secret"`
+	TriggerLabels []string `mapstructure:"webhook-trigger-labels"`
+	RetestOnPush  bool     `mapstructure:"webhook-retest-on-push"`
+}
+
+type FlagsComments struct {
+	Command   string   `mapstructure:"comment-command"`
+	Allowlist []string `mapstructure:"comment-allowlist"`
+}
+
+type FlagsWatch struct {
+	Interval time.Duration `mapstructure:"watch-interval"`
+	Once     bool          `mapstructure:"watch-once"`
 }
 
 type DiscoveryConfig struct {
@@ -97,7 +163,26 @@ type DiscoveryConfig struct {
 	LocalRepoPath            string           `mapstructure:"local-repo-path"`
 	LocalTraceDepth          int              `mapstructure:"local-trace-depth"`
 	LocalVendorMode          string           `mapstructure:"local-vendor-mode"`
+	WholeFileSymbols         bool             `mapstructure:"whole-file-symbols"`
+	MaxTracedResources       int              `mapstructure:"max-traced-resources"`
 	Mode                     string           `mapstructure:"mode"`
+
+	// SmokeTests are the smoke-tests of each service in the service map
+	SmokeTests map[string][]string `mapstructure:"-"`
+
+	// SmokeSuites are the tests run for changed files outside the service packages, from the service map
+	SmokeSuites SmokeSuites `mapstructure:"-"`
+
+	// ServiceDirs are where the repo's service packages live, see serviceDirsFor
+	ServiceDirs []string `mapstructure:"-"`
+}
+
+// serviceDirs returns ServiceDirs, or every known layout when it isn't set.
+func (c DiscoveryConfig) serviceDirs() []string {
+	if len(c.ServiceDirs) > 0 {
+		return c.ServiceDirs
+	}
+	return provider.ServiceDirPrefixes
 }
 
 type FlagsGitHub struct {
@@ -132,19 +217,29 @@ type FlagsTeamCity struct {
 }
 
 type FlagsTeamCityBuild struct {
-	TypeID           string   `mapstructure:"build-type-id"`
-	LegacyTypeID     string   `mapstructure:"buildtypeid"`
-	Parameters       string   `mapstructure:"properties"`
-	SkipQueue        bool     `mapstructure:"skip-queue"`
-	Wait             bool     `mapstructure:"wait"`
-	Latest           bool     `mapstructure:"latest"`
-	Comment          bool     `mapstructure:"comment"`
-	ForceOldUI       bool     `mapstructure:"build-link-force-old-ui"`
-	AddServiceSuffix bool     `mapstructure:"build-type-id-add-service-suffix"`
-	QueueTimeout     int      `mapstructure:"queue-timeout"`
-	RunTimeout       int      `mapstructure:"run-timeout"`
-	MaxBuildsPerPR   int      `mapstructure:"max-builds-per-pr"`
-	Tags             []string `mapstructure:"tag"`
+	TypeID           string       `mapstructure:"build-type-id"`
+	LegacyTypeID     string       `mapstructure:"buildtypeid"`
+	Parameters       string       `mapstructure:"properties"`
+	SkipQueue        bool         `mapstructure:"skip-queue"`
+	Wait             bool         `mapstructure:"wait"`
+	Latest           bool         `mapstructure:"latest"`
+	Comment          bool         `mapstructure:"comment"`
+	ForceOldUI       bool         `mapstructure:"build-link-force-old-ui"`
+	AddServiceSuffix bool         `mapstructure:"build-type-id-add-service-suffix"`
+	QueueTimeout     int          `mapstructure:"queue-timeout"`
+	RunTimeout       int          `mapstructure:"run-timeout"`
+	MaxBuildsPerPR   int          `mapstructure:"max-builds-per-pr"`
+	Tags             []string     `mapstructure:"tag"`
+	ServiceMapFile   string       `mapstructure:"service-map"`
+	ServiceMap       ServiceMap   `mapstructure:"-"`
+	ParameterPreset  string       `mapstructure:"build-parameter-preset"`
+	BuildParameters  []string     `mapstructure:"build-parameter"`
+	Matrix           []string     `mapstructure:"matrix"`
+	MatrixCells      []MatrixCell `mapstructure:"-"`
+
+	MaxRunning           int           `mapstructure:"max-running"`
+	MaxRunningPerService int           `mapstructure:"max-running-per-service"`
+	ThrottlePollInterval time.Duration `mapstructure:"max-running-poll-interval"`
 }
 
 func configureFlags(root *cobra.Command) error {
@@ -155,14 +250,74 @@ func configureFlags(root *cobra.Command) error {
 	pflags.BoolP("all", "", false, "run all tests by passing TestAcc (incompatible with an explicit test regex or --add-tests)")
 	pflags.StringSlice("service", []string{}, "target specific services: with --all or test_regex, skips discovery and triggers directly; alone, filters discovered services")
 	pflags.StringSlice("add-tests", []string{}, "additional test names to append to the discovered test regex (comma-separated, incompatible with --all or an explicit test regex)")
+	pflags.Bool("ignore-pr-directives", false, "ignore tctest: test directives in the PR description")
 	pflags.Bool("quiet", false, "minimal machine-readable output (pr@service@build url)")
 
+	// list's own flags, they only apply to a discovery that triggers nothing
+	list, _, err := root.Find([]string{"list"})
+	if err != nil {
+		return fmt.Errorf("finding the list command: %w", err)
+	}
+	lflags := list.Flags()
+	lflags.StringSlice("explain", []string{}, "show why these tests were selected: the chain from the changed file through traced symbols, packages and resource files to the test")
+	lflags.Bool("explain-all", false, "show why every selected test was selected, see --explain")
+	lflags.String("graph", "", "write the graph of changed files, traced packages, resource files and tests to this file ('-' for stdout, with --quiet or --silent)")
+	lflags.String("graph-format", GraphFormatDOT, "the --graph format, 'dot' (Graphviz) or 'mermaid'")
+
 	// Output Flags
 	pflags.Bool("json", false, "output build results as JSON array")
 	pflags.Bool("silent", false, "suppress all output")
 	pflags.Bool("dry-run", false, "show what builds would be triggered without actually triggering them")
 	pflags.BoolP("verbose", "v", false, "show detailed file listings and trace output")
 
+	pflags.String("state-dir", "", "directory for local state such as watch progress and the audit log (default ~/.tctest.d)")
+
+	// the audit log's own flags, on the commands that read it. cancel shares results' --last, so both set the one
+	// viper binds
+	results, _, err := root.Find([]string{"results"})
+	if err != nil {
+		return fmt.Errorf("finding the results command: %w", err)
+	}
+	cancel, _, err := root.Find([]string{"cancel"})
+	if err != nil {
+		return fmt.Errorf("finding the cancel command: %w", err)
+	}
+	rflags := results.Flags()
+	rflags.Bool("last", false, "the builds triggered by the last tctest run, from the audit log")
+	cancel.Flags().AddFlag(rflags.Lookup("last"))
+
+	// History Flags (FlagsHistory)
+	history, _, err := root.Find([]string{"history"})
+	if err != nil {
+		return fmt.Errorf("finding the history command: %w", err)
+	}
+	hflags := history.Flags()
+	hflags.Int("pr", 0, "only show the builds for this PR")
+	hflags.String("since", "", "only show entries newer than this, ie '12h' or '7d'")
+
+	// Watch Flags (FlagsWatch)
+	pflags.Duration("watch-interval", 10*time.Minute, "how often watch checks for PRs with new commits")
+	pflags.Bool("watch-once", false, "run a single watch pass and exit, for running watch from cron")
+
+	// Serve Flags (FlagsServe)
+	pflags.Bool("webhooks", false, "serve: receive GitHub pull_request and issue_comment webhook events")
+	pflags.String("listen", ":8080", "serve: the address to listen on")
+	pflags.String("webhook-secret", "", "serve: the GitHub webhook secret used to verify signatures (consider exporting it to TCTEST_WEBHOOK_SECRET instead)")
+	pflags.StringSlice("webhook-trigger-labels", []string{"acc-test"}, "serve: adding one of these labels to a PR triggers a discovered test run")
+	pflags.Bool("webhook-retest-on-push", true, "serve: new commits on a PR carrying a trigger label trigger a discovered test run")
+
+	// Comment Command Flags (FlagsComments)
+	pflags.String("comment-command", "/tctest", "the PR comment command run by 'comments' and 'serve --webhooks' (empty disables it for serve)")
+	pflags.StringSlice("comment-allowlist", []string{}, "GitHub users allowed to run comment commands without write permission on the repo, held like prs for untrusted forks and sensitive changes")
+
+	// Fork Trust Flags (FlagsTrust)
+	pflags.String("fork-policy", ForkPolicyReview, "prs, watch and serve: 'review' holds PRs from forks unless the author is trusted or the PR is labelled safe to test, 'trust-all' tests every PR")
+	pflags.StringSlice("trusted-authors", []string{}, "GitHub users whose fork PRs are tested without review")
+	pflags.StringSlice("trusted-orgs", []string{}, "GitHub orgs whose members' fork PRs are tested without review")
+	pflags.String("safe-to-test-label", "safe-to-test", "the label a maintainer adds to a reviewed fork PR to allow testing it until its next push (empty disables it)")
+	pflags.Bool("allow-sensitive", false, "prs, watch and serve: trigger builds for PRs changing CI configuration, scripts, go.mod replace directives, TestMain or sweepers")
+	pflags.String("allow-sensitive-label", "", "a label that allows prs, watch and serve to trigger builds for a PR with sensitive changes")
+
 	// Discovery Configuration Flags (DiscoveryConfig)
 	pflags.String("fileregex", `^internal/services?/[^/]+/[a-z0-9_][^/]*$`, "the regex to filter files by")
 	pflags.String("splitteston", "_", "the character to split tests on and use the value on the left")
@@ -181,8 +336,10 @@ func configureFlags(root *cobra.Command) error {
 	// Local Discovery Flags (DiscoveryConfig)
 	pflags.String("local-repo-path", "", "path to a local git clone for AST-based test detection (enables import tracing from helper files, and changes default mode to AST)")
 	pflags.Int("local-trace-depth", 10, "how many levels of import tracing to perform for helper file changes (0 to disable)")
-	pflags.String("local-vendor-mode", "basic", "mode for vendor AST detection: 'basic' (package-based import tracing) or 'none' (disabled)")
-	pflags.String("mode", "AST", "mode for local test detection: 'AST' (default, uses local repo; falls back to 'api' if current working directory is not the provider repo and --local-repo-path is not set) or 'api'. Note: 'SSA' (super slow analyse) to be added in the future")
+	pflags.Bool("whole-file-symbols", false, "trace every symbol declared in a changed helper file and run every test in a changed test file, rather than only the declarations and tests the PR changed")
+	pflags.String("local-vendor-mode", "basic", "mode for vendor AST detection: 'basic' (package-based import tracing), 'deep' (also through the vendored packages importing the changed ones, up to --local-trace-depth) or 'none' (disabled)")
+	pflags.Int("max-traced-resources", 50, "when a change to a package shared by the services (e.g. internal/tf) traces to more resource files than this, run each service's smoke-tests from the service map instead (0 for no limit)")
+	pflags.String("mode", "AST", "mode for local test detection: 'AST' (default, uses local repo; falls back to 'api' if current working directory is not the provider repo and --local-repo-path is not set), 'types' (like AST but type-checks the service packages with go/types to follow the real uses of changed helpers, slower) or 'api'")
 
 	// GitHub Flags (FlagsGitHub)
 	pflags.String("token-gh", "", "github oauth token (consider exporting token to GITHUB_TOKEN instead)")
@@ -194,7 +351,7 @@ func configureFlags(root *cobra.Command) error {
 			return fmt.Errorf("error hiding '%s' flag: %w", f, err)
 		}
 	}
-	pflags.StringP("repo", "r", "", "repository the pr resides in, such as terraform-providers/terraform-provider-azurerm")
+	pflags.StringP("repo", "r", "", "repository the pr resides in, such as hashicorp/terraform-provider-azurerm (default the upstream, then origin, remote of the git clone in the current directory)")
 
 	// GitHub PR Filter Flags (FlagsGitHubPrFilter)
 	pflags.StringSliceP("f-authors", "a", []string{}, "only test PR by these authors. ie 'katbyte,author2,author3'")
@@ -216,7 +373,7 @@ func configureFlags(root *cobra.Command) error {
 	pflags.StringP("buildtypeid", "b", "", "[DEPRECATED] use --build-type-id instead")
 	pflags.String("build-type-id", "", "the TeamCity BuildTypeId to trigger")
 	pflags.Bool("build-type-id-add-service-suffix", false, "append _SERVICE to the build type ID for per-service build configurations (previously appended automatically by --buildtypeid)")
-	pflags.StringP("properties", "p", "", "the TeamCity build parameters to use in 'KEY1=VALUE1;KEY2=VALUE2' format")
+	pflags.StringP("properties", "p", "", "the TeamCity build parameters to use in 'KEY1=VALUE1;KEY2=VALUE2' format, values may use templates such as {{.PR}} and {{.Service}}")
 	pflags.BoolP("skip-queue", "q", false, "Put the build to the queue top")
 	pflags.BoolP("wait", "w", false, "Wait for the build to complete before tctest exits")
 	pflags.BoolP("latest", "", false, "gets the latest build in TeamCity")
@@ -226,6 +383,15 @@ func configureFlags(root *cobra.Command) error {
 	pflags.Bool("build-link-force-old-ui", false, "Append &fromSakuraUI=true to build URLs to force the classic TeamCity UI")
 	pflags.StringSliceP("tag", "", []string{}, "TeamCity build tags to add to the triggered build, ie 'tag1,tag2'")
 	pflags.Int("max-builds-per-pr", 5, "maximum number of service builds to trigger per PR (0 = no limit, errors if exceeded)")
+	pflags.String("service-map", "", "path to a YAML/JSON file mapping services to build type IDs, properties, tags and timeouts")
+	pflags.String("profile", "", "the named profile to use from the profiles file, default the one matching the git remotes of the current directory")
+	pflags.String("profiles-file", "", "path to the YAML profiles file (default .tctest.yaml in the current, then home, directory)")
+	pflags.String("build-parameter-preset", BuildParameterPresetAuto, "the branch and test pattern parameters to send: 'auto' (the azurerm or aws preset for those repos, otherwise legacy), 'legacy' (all of teamcity.build.branch, BRANCH_NAME, TEST_PATTERN and TEST_PREFIX), 'azurerm' or 'aws'")
+	pflags.Int("max-running", 0, "hold further triggers until fewer than this many tctest builds are running or queued in TeamCity (0 = no limit)")
+	pflags.Int("max-running-per-service", 0, "hold further triggers until fewer than this many tctest builds for the service are running or queued (0 = no limit)")
+	pflags.Duration("max-running-poll-interval", 30*time.Second, "how often to check TeamCity for a free slot with --max-running/--max-running-per-service")
+	pflags.StringArray("matrix", []string{}, "run every build once per combination of values, ie 'ARM_TEST_LOCATION=westeurope,eastus2' (repeatable, each build counts towards --max-builds-per-pr)")
+	pflags.StringArray("build-parameter", []string{}, "a 'NAME=TEMPLATE' branch/test pattern parameter to send instead of the preset, ie 'env.TESTARGS=-run={{.TestPattern}}' (repeatable)")
 
 	// binding map for viper/pflag -> env
 	m := map[string]string{ //nolint:gosec // G101: these are env var names, not credentials
@@ -249,6 +415,11 @@ func configureFlags(root *cobra.Command) error {
 		"all":                              "",
 		"service":                          "",
 		"add-tests":                        "",
+		"ignore-pr-directives":             "TCTEST_IGNORE_PR_DIRECTIVES",
+		"explain":                          "",
+		"explain-all":                      "",
+		"graph":                            "",
+		"graph-format":                     "",
 		"quiet":                            "TCTEST_OUTPUT_QUIET",
 		"json":                             "TCTEST_OUTPUT_JSON",
 		"silent":                           "TCTEST_OUTPUT_SILENT",
@@ -258,6 +429,8 @@ func configureFlags(root *cobra.Command) error {
 		"local-repo-path":                  "TCTEST_LOCAL_REPO_PATH",
 		"local-trace-depth":                "",
 		"local-vendor-mode":                "TCTEST_LOCAL_VENDOR_MODE",
+		"whole-file-symbols":               "TCTEST_WHOLE_FILE_SYMBOLS",
+		"max-traced-resources":             "TCTEST_MAX_TRACED_RESOURCES",
 		"mode":                             "TCTEST_MODE",
 		"queue-timeout":                    "",
 		"run-timeout":                      "",
@@ -277,10 +450,45 @@ func configureFlags(root *cobra.Command) error {
 		"tag":                              "TCTEST_BUILD_TAGS",
 		"max-builds-per-pr":                "",
 		"collapse-files-after":             "",
+		"service-map":                      "TCTEST_SERVICE_MAP",
+		"profile":                          "TCTEST_PROFILE",
+		"profiles-file":                    "TCTEST_PROFILES_FILE",
+		"build-parameter-preset":           "TCTEST_BUILD_PARAMETER_PRESET",
+		"build-parameter":                  "",
+		"matrix":                           "",
+		"max-running":                      "TCTEST_MAX_RUNNING",
+		"max-running-per-service":          "TCTEST_MAX_RUNNING_PER_SERVICE",
+		"max-running-poll-interval":        "",
+		"state-dir":                        "TCTEST_STATE_DIR",
+		"last":                             "",
+		"pr":                               "",
+		"since":                            "",
+		"watch-interval":                   "TCTEST_WATCH_INTERVAL",
+		"watch-once":                       "",
+		"webhooks":                         "",
+		"listen":                           "TCTEST_LISTEN",
+		"webhook-secret":                   "TCTEST_WEBHOOK_SECRET",
+		"webhook-trigger-labels":           "TCTEST_WEBHOOK_TRIGGER_LABELS",
+		"webhook-retest-on-push":           "",
+		"comment-command":                  "TCTEST_COMMENT_COMMAND",
+		"comment-allowlist":                "TCTEST_COMMENT_ALLOWLIST",
+		"fork-policy":                      "TCTEST_FORK_POLICY",
+		"trusted-authors":                  "TCTEST_TRUSTED_AUTHORS",
+		"trusted-orgs":                     "TCTEST_TRUSTED_ORGS",
+		"safe-to-test-label":               "TCTEST_SAFE_TO_TEST_LABEL",
+		"allow-sensitive":                  "",
+		"allow-sensitive-label":            "TCTEST_ALLOW_SENSITIVE_LABEL",
 	}
 
+	flagEnvVars = m
 	for name, env := range m {
-		if err := viper.BindPFlag(name, pflags.Lookup(name)); err != nil {
+		var flag *pflag.Flag
+		for _, fs := range []*pflag.FlagSet{pflags, lflags, rflags, hflags} {
+			if flag = fs.Lookup(name); flag != nil {
+				break
+			}
+		}
+		if err := viper.BindPFlag(name, flag); err != nil {
 			return fmt.Errorf("error binding '%s' flag: %w", name, err)
 		}
 
@@ -308,6 +516,9 @@ func configureFlags(root *cobra.Command) error {
 	return nil
 }
 
+// flagEnvVars maps flag names to the environment variable each is bound to, if any.
+var flagEnvVars map[string]string
+
 // GetFlags returns the fully populated FlagData.
 // We must unmarshal from Viper instead of using globally bound pflags variables
 // because pflags only parses command-line arguments. Viper merges environment variables
@@ -330,6 +541,31 @@ func GetFlags() *FlagData {
 
 	// Manually compile Regex fields since Viper doesn't know how to unmarshal strings into *regexp.Regexp natively
 	f.DiscoveryConfig.FileRegEx = regexp.MustCompile(viper.GetString("fileregex"))
+	f.DiscoveryConfig.ServiceDirs = serviceDirsFor(f.GH.Repo)
+
+	// the file has already been loaded and validated in PersistentPreRunE
+	if f.TC.Build.ServiceMapFile != "" {
+		f.TC.Build.ServiceMap = loadedServiceMap
+		f.DiscoveryConfig.SmokeSuites = loadedSmokeSuites
+	} else if activeProfile != nil {
+		f.TC.Build.ServiceMap = activeProfile.Services
+		f.DiscoveryConfig.SmokeSuites = activeProfile.Smoke
+	}
+
+	f.DiscoveryConfig.SmokeTests = map[string][]string{}
+	for service, sc := range f.TC.Build.ServiceMap {
+		if len(sc.SmokeTests) > 0 {
+			f.DiscoveryConfig.SmokeTests[service] = sc.SmokeTests
+		}
+	}
+
+	// --matrix has already been validated in PersistentPreRunE
+	cells, err := ParseMatrix(f.TC.Build.Matrix)
+	if err != nil {
+		clog.Log.Fatalf("failed to parse --matrix: %v", err)
+	}
+	f.TC.Build.MatrixCells = cells
+	f.triggers = &auditTriggers{}
 
 	suffixStrs := viper.GetStringSlice("acctest-file-suffix-regexes")
 	f.DiscoveryConfig.AccTestFileSuffixRegexes = make([]*regexp.Regexp, 0, len(suffixStrs))
@@ -348,6 +584,10 @@ func (cfg DiscoveryConfig) AccTestFileSuffixRegexStrings() string {
 	return strings.Join(s, ", ")
 }
 
-func (f *FlagData) NewTCServer() tc.Server {
-	return tc.NewServer(f.TC.ServerURL, f.TC.Token, f.TC.User, f.TC.Pass)
+func (f *FlagData) NewTCServer() (tc.Server, error) {
+	server, err := tc.NewServer(f.TC.ServerURL, f.TC.Token, f.TC.User, f.TC.Pass)
+	if err != nil {
+		return server, fmt.Errorf("%w: set --token-tc (TCTEST_TOKEN_TC) or --username and --password (TCTEST_USER, TCTEST_PASS)", err)
+	}
+	return server, nil
 }
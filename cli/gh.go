@@ -6,9 +6,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/go-github/v89/github"
 	"github.com/katbyte/tctest/lib/clog"
 	"github.com/katbyte/tctest/lib/gh"
-	"github.com/katbyte/tctest/lib/provider"
 )
 
 // GithubRepo wraps the common gh lib shared with my other tools, splitting common GH code from this CLI tool's tooling code.
@@ -42,12 +42,12 @@ func maskToken(token string) string {
 	return token[:4] + "****"
 }
 
-// ListServices lists all service directory names under the provider's service
-// directory (internal/services/ or internal/service/).
-func (ghr GithubRepo) ListServices() ([]string, error) {
+// ListServices lists all service directory names under the first of the provider's service
+// directories (such as internal/services/ or internal/service/) that exists.
+func (ghr GithubRepo) ListServices(serviceDirs []string) ([]string, error) {
 	client, ctx := ghr.NewClient()
 
-	for _, prefix := range provider.ServiceDirPrefixes {
+	for _, prefix := range serviceDirs {
 		clog.Log.Debugf("listing services for %s/%s at %s...", ghr.Owner, ghr.Name, prefix)
 		_, dirContents, resp, err := client.Repositories.GetContents(ctx, ghr.Owner, ghr.Name, prefix, nil)
 		if err != nil {
@@ -69,5 +69,18 @@ func (ghr GithubRepo) ListServices() ([]string, error) {
 		return services, nil
 	}
 
-	return nil, fmt.Errorf("no service directory found for %s/%s (tried %s)", ghr.Owner, ghr.Name, strings.Join(provider.ServiceDirPrefixes, ", "))
+	return nil, fmt.Errorf("no service directory found for %s/%s (tried %s)", ghr.Owner, ghr.Name, strings.Join(serviceDirs, ", "))
+}
+
+// ListPullRequestFiles lists all the files changed in a PR.
+func (ghr GithubRepo) ListPullRequestFiles(pri int) ([]*github.CommitFile, error) {
+	var all []*github.CommitFile
+	err := ghr.ListAllPullRequestFiles(pri, func(files []*github.CommitFile, _ *github.Response) error {
+		all = append(all, files...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all files for %s/%s/pull/%d: %w", ghr.Owner, ghr.Name, pri, err)
+	}
+	return all, nil
 }
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// list --graph formats
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatMermaid = "mermaid"
+)
+
+var graphFormats = []string{GraphFormatDOT, GraphFormatMermaid}
+
+func validateGraphFormat(format string) error {
+	if !slices.Contains(graphFormats, format) {
+		return fmt.Errorf("unknown --graph-format %q, expected one of: %s", format, strings.Join(graphFormats, ", "))
+	}
+	return nil
+}
+
+// validateGraphOutput rejects --graph - unless --quiet or --silent turn the discovery output off, as both would
+// go to stdout.
+func validateGraphOutput(graph string, level int) error {
+	if graph == "-" && level != cout.VerbosityQuiet && level != cout.VerbositySilent {
+		return errors.New("--graph - needs --quiet or --silent, the discovery output also goes to stdout")
+	}
+	return nil
+}
+
+// graphKinds orders the node kinds left to right, and styles them
+var graphKinds = []struct {
+	kind, dot, mermaid string
+}{
+	{provider.LinkChangedFile, `shape=box, style=filled, fillcolor="#fff3b0"`, "fill:#fff3b0"},
+	{provider.LinkVendorPackage, `shape=component, style=filled, fillcolor="#e1bee7"`, "fill:#e1bee7"},
+	{provider.LinkPackage, `shape=folder`, "fill:#eeeeee"},
+	{provider.LinkResourceFile, `shape=box, style=filled, fillcolor="#b2dfdb"`, "fill:#b2dfdb"},
+	{provider.LinkTestFile, `shape=box, style=filled, fillcolor="#c8e6c9"`, "fill:#c8e6c9"},
+	{provider.LinkTest, `shape=ellipse`, "fill:#ffffff"},
+	{provider.LinkDirective, `shape=note`, "fill:#ffffff"},
+	{provider.LinkSmokeSuite, `shape=box, style="filled,dashed", fillcolor="#ffe0b2"`, "fill:#ffe0b2,stroke-dasharray:4"},
+	{provider.LinkModule, `shape=component, style=filled, fillcolor="#bbdefb"`, "fill:#bbdefb"},
+}
+
+func graphKindOrder(kind string) int {
+	return slices.IndexFunc(graphKinds, func(k struct{ kind, dot, mermaid string }) bool { return k.kind == kind })
+}
+
+type graphNode struct {
+	Kind  string
+	Value string
+	Depth int // packages: how many packages the trace went through to reach it
+}
+
+// Label is the node's text, packages include the depth they were traced at.
+func (n graphNode) Label() string {
+	if n.Kind == provider.LinkPackage {
+		return fmt.Sprintf("%s\n(depth %d)", n.Value, n.Depth)
+	}
+	return n.Value
+}
+
+// DiscoveryGraph is the graph explored by test discovery, built from the provenance chains: links become nodes
+// and symbol links label the edge they sit on.
+type DiscoveryGraph struct {
+	Nodes []graphNode
+	Edges map[[2]int][]string // from, to -> symbols
+
+	index map[string]int
+}
+
+// Graph returns the graph of the discovery: every changed file, the packages and resource files tracing reached and
+// the chains of the selected tests.
+func (d *Discovery) Graph() *DiscoveryGraph {
+	g := &DiscoveryGraph{Edges: map[[2]int][]string{}, index: map[string]int{}}
+	for _, f := range d.ChangedFiles {
+		g.node(provider.LinkChangedFile, f.Path, 0)
+	}
+	for _, c := range d.explored {
+		g.addChain(c)
+	}
+	for _, service := range d.Services {
+		for _, t := range service.Tests {
+			for _, c := range d.testChains[t] {
+				g.addChain(c)
+			}
+		}
+	}
+	g.sort()
+	return g
+}
+
+func (g *DiscoveryGraph) node(kind, value string, depth int) int {
+	key := kind + "\x00" + value
+	if i, ok := g.index[key]; ok {
+		g.Nodes[i].Depth = min(g.Nodes[i].Depth, depth)
+		return i
+	}
+	g.Nodes = append(g.Nodes, graphNode{Kind: kind, Value: value, Depth: depth})
+	g.index[key] = len(g.Nodes) - 1
+	return len(g.Nodes) - 1
+}
+
+func (g *DiscoveryGraph) addChain(c provider.Chain) {
+	var prev []int
+	symbols := ""
+	depth := 0
+	for _, l := range c {
+		switch l.Kind {
+		case provider.LinkSymbol:
+			symbols = l.Value
+			continue
+		case provider.LinkPackage:
+			depth++
+		}
+
+		// the helpers of one package start a single chain
+		values := []string{l.Value}
+		if l.Kind == provider.LinkChangedFile {
+			values = strings.Split(l.Value, ", ")
+		}
+
+		var cur []int
+		for _, v := range values {
+			to := g.node(l.Kind, v, depth)
+			cur = append(cur, to)
+			for _, from := range prev {
+				e := [2]int{from, to}
+				if _, ok := g.Edges[e]; !ok || (symbols != "" && !slices.Contains(g.Edges[e], symbols)) {
+					g.Edges[e] = append(g.Edges[e], symbols)
+				}
+			}
+		}
+		prev, symbols = cur, ""
+	}
+}
+
+// sort orders the nodes by kind then value so the output is deterministic
+func (g *DiscoveryGraph) sort() {
+	order := make([]int, len(g.Nodes))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		na, nb := g.Nodes[a], g.Nodes[b]
+		return cmp.Or(cmp.Compare(graphKindOrder(na.Kind), graphKindOrder(nb.Kind)), cmp.Compare(na.Value, nb.Value))
+	})
+
+	remap := make([]int, len(g.Nodes))
+	nodes := make([]graphNode, len(g.Nodes))
+	for to, from := range order {
+		remap[from] = to
+		nodes[to] = g.Nodes[from]
+	}
+	edges := make(map[[2]int][]string, len(g.Edges))
+	for e, symbols := range g.Edges {
+		edges[[2]int{remap[e[0]], remap[e[1]]}] = symbols
+	}
+
+	g.Nodes, g.Edges = nodes, edges
+	for i, n := range g.Nodes {
+		g.index[n.Kind+"\x00"+n.Value] = i
+	}
+}
+
+func (g *DiscoveryGraph) sortedEdges() [][2]int {
+	edges := make([][2]int, 0, len(g.Edges))
+	for e := range g.Edges {
+		edges = append(edges, e)
+	}
+	slices.SortFunc(edges, func(a, b [2]int) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	return edges
+}
+
+func (g *DiscoveryGraph) edgeLabel(e [2]int) string {
+	return strings.Join(slices.DeleteFunc(slices.Clone(g.Edges[e]), func(s string) bool { return s == "" }), "; ")
+}
+
+// DOT renders the graph for Graphviz.
+func (g *DiscoveryGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph tctest {\n  rankdir=LR;\n  node [fontname=\"Helvetica\", fontsize=10];\n  edge [fontname=\"Helvetica\", fontsize=9];\n")
+	for i, n := range g.Nodes {
+		fmt.Fprintf(&b, "  n%d [label=%s, %s];\n", i, strconv.Quote(n.Label()), graphKinds[graphKindOrder(n.Kind)].dot)
+	}
+	for _, e := range g.sortedEdges() {
+		if label := g.edgeLabel(e); label != "" {
+			fmt.Fprintf(&b, "  n%d -> n%d [label=%s];\n", e[0], e[1], strconv.Quote(label))
+		} else {
+			fmt.Fprintf(&b, "  n%d -> n%d;\n", e[0], e[1])
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a mermaid flowchart.
+func (g *DiscoveryGraph) Mermaid() string {
+	escape := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, n := range g.Nodes {
+		open, closing := `["`, `"]`
+		if n.Kind == provider.LinkTest {
+			open, closing = `(["`, `"])`
+		}
+		fmt.Fprintf(&b, "  n%d%s%s%s:::k%d\n", i, open, escape(n.Label()), closing, graphKindOrder(n.Kind))
+	}
+	for _, e := range g.sortedEdges() {
+		if label := g.edgeLabel(e); label != "" {
+			fmt.Fprintf(&b, "  n%d -->|%s| n%d\n", e[0], escape(label), e[1])
+		} else {
+			fmt.Fprintf(&b, "  n%d --> n%d\n", e[0], e[1])
+		}
+	}
+	for i, k := range graphKinds {
+		fmt.Fprintf(&b, "  classDef k%d %s\n", i, k.mermaid)
+	}
+	return b.String()
+}
+
+// WriteGraph writes the discovery graph to --graph in --graph-format, '-' being stdout.
+func (f *FlagData) WriteGraph(d *Discovery) error {
+	g := d.Graph()
+	out := g.DOT()
+	if f.GraphFormat == GraphFormatMermaid {
+		out = g.Mermaid()
+	}
+
+	if f.Graph == "-" {
+		_, err := os.Stdout.WriteString(out)
+		return err
+	}
+	if err := os.WriteFile(f.Graph, []byte(out), 0o600); err != nil {
+		return fmt.Errorf("writing graph: %w", err)
+	}
+	cout.Printf("  graph written to <cyan>%s</> <darkGray>(%d nodes, %d edges)</>\n", f.Graph, len(g.Nodes), len(g.Edges))
+	return nil
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func TestDiscoveryGraph(t *testing.T) {
+	t.Parallel()
+
+	helpers := provider.Chain{{Kind: provider.LinkChangedFile, Value: "internal/services/dns/parse/a.go, internal/services/dns/parse/b.go"}}
+	validate := helpers.Then(provider.LinkSymbol, "ParseID").Then(provider.LinkPackage, "internal/services/dns/validate")
+	resource := validate.Then(provider.LinkSymbol, "ID").Then(provider.LinkResourceFile, "internal/services/dns/dns_a_record_resource.go")
+	d := &Discovery{
+		ChangedFiles: []DiscoveryFile{{Path: "internal/services/dns/registration.go"}},
+		Services:     []DiscoveryService{{Service: "dns", Tests: []string{"TestAccDnsARecord"}}},
+		explored:     []provider.Chain{helpers, validate, resource},
+		testChains: map[string][]provider.Chain{
+			"TestAccDnsARecord": {resource.Then(provider.LinkTestFile, "internal/services/dns/dns_a_record_resource_test.go").Then(provider.LinkTest, "TestAccDnsARecord")},
+			"TestAccNotRun":     {{{Kind: provider.LinkTest, Value: "TestAccNotRun"}}},
+		},
+	}
+
+	g := d.Graph()
+	if len(g.Nodes) != 7 || len(g.Edges) != 5 {
+		t.Fatalf("graph has %d nodes and %d edges, want 7 and 5: %+v", len(g.Nodes), len(g.Edges), g.Nodes)
+	}
+
+	dot := g.DOT()
+	for _, want := range []string{
+		`n0 [label="internal/services/dns/parse/a.go", shape=box`,
+		`n2 [label="internal/services/dns/registration.go"`,
+		`n3 [label="internal/services/dns/validate\n(depth 1)", shape=folder];`,
+		`n0 -> n3 [label="ParseID"];`,
+		`n1 -> n3 [label="ParseID"];`,
+		`n3 -> n4 [label="ID"];`,
+		`n4 -> n5;`,
+		`n5 -> n6;`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT missing %q:\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "TestAccNotRun") {
+		t.Errorf("DOT includes a test that wasn't selected:\n%s", dot)
+	}
+
+	mermaid := g.Mermaid()
+	for _, want := range []string{
+		"flowchart LR\n",
+		`n3["internal/services/dns/validate<br/>(depth 1)"]:::k2`,
+		`n6(["TestAccDnsARecord"]):::k5`,
+		"n0 -->|ParseID| n3",
+		"n5 --> n6",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("mermaid missing %q:\n%s", want, mermaid)
+		}
+	}
+
+	if err := validateGraphFormat("svg"); err == nil {
+		t.Error("validateGraphFormat(svg) = nil, want an error")
+	}
+}
+
+func TestValidateGraphOutput(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		graph string
+		level int
+		ok    bool
+	}{
+		{name: "no graph", level: cout.VerbosityNormal, ok: true},
+		{name: "file", graph: "pr.dot", level: cout.VerbosityVerbose, ok: true},
+		{name: "stdout with the discovery output", graph: "-", level: cout.VerbosityNormal},
+		{name: "stdout with the JSON output", graph: "-", level: cout.VerbosityJSON},
+		{name: "stdout quiet", graph: "-", level: cout.VerbosityQuiet, ok: true},
+		{name: "stdout silent", graph: "-", level: cout.VerbositySilent, ok: true},
+	}
+
+	for _, tt := range cases {
+		if err := validateGraphOutput(tt.graph, tt.level); (err == nil) != tt.ok {
+			t.Errorf("%s: validateGraphOutput = %v, want ok %t", tt.name, err, tt.ok)
+		}
+	}
+}
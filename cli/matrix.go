@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+// matrixTagPrefix prefixes the tag each matrix build is given, so `results pr` can group builds by cell.
+const matrixTagPrefix = "matrix:"
+
+// MatrixCell is one combination of --matrix values, in the order the keys were given.
+type MatrixCell []tc.Property
+
+// String returns the cell as KEY=v1,KEY2=a, which is used for output, the JSON matrix field and the build tag.
+func (c MatrixCell) String() string {
+	parts := make([]string, 0, len(c))
+	for _, p := range c {
+		parts = append(parts, p.Name+"="+p.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Properties returns the cell in the KEY1=VALUE1;KEY2=VALUE2 --properties format.
+func (c MatrixCell) Properties() string {
+	parts := make([]string, 0, len(c))
+	for _, p := range c {
+		parts = append(parts, p.Name+"="+p.Value)
+	}
+	return strings.Join(parts, ";")
+}
+
+// Tag returns the TeamCity tag identifying the cell's builds.
+func (c MatrixCell) Tag() string {
+	return matrixTagPrefix + c.String()
+}
+
+// ParseMatrix expands --matrix KEY=v1,v2 entries into the cartesian product of their values. With no entries
+// it returns a single empty cell, so callers can always loop over the result.
+func ParseMatrix(entries []string) ([]MatrixCell, error) {
+	cells := []MatrixCell{nil}
+	seen := map[string]bool{}
+
+	for _, e := range entries {
+		key, list, ok := strings.Cut(e, "=")
+		if !ok || key == "" || strings.ContainsAny(key, ";,") {
+			return nil, fmt.Errorf("unable to parse --matrix '%s': expected KEY=v1,v2", e)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("--matrix key %s is given more than once", key)
+		}
+		seen[key] = true
+
+		values := strings.Split(list, ",")
+		for _, v := range values {
+			if v == "" || strings.Contains(v, ";") {
+				return nil, fmt.Errorf("--matrix %s has an empty or invalid value in '%s'", key, list)
+			}
+		}
+
+		next := make([]MatrixCell, 0, len(cells)*len(values))
+		for _, c := range cells {
+			for _, v := range values {
+				cell := append(append(MatrixCell{}, c...), tc.Property{Name: key, Value: v})
+				next = append(next, cell)
+			}
+		}
+		cells = next
+	}
+
+	return cells, nil
+}
+
+// matrixCells returns the --matrix cells to trigger, a single empty cell when --matrix isn't used.
+func (f *FlagData) matrixCells() []MatrixCell {
+	if len(f.TC.Build.MatrixCells) == 0 {
+		return []MatrixCell{nil}
+	}
+	return f.TC.Build.MatrixCells
+}
+
+// applyMatrixCell adds the cell's properties and tag to the build spec.
+func applyMatrixCell(spec *BuildSpec, cell MatrixCell) {
+	if len(cell) == 0 {
+		return
+	}
+
+	spec.Matrix = cell
+	if spec.Properties != "" {
+		spec.Properties += ";"
+	}
+	spec.Properties += cell.Properties()
+	spec.Tags = append(spec.Tags, cell.Tag())
+}
+
+// matrixCellFromTags returns the matrix cell a build was tagged with, or "" if it isn't a matrix build.
+func matrixCellFromTags(tags []string) string {
+	for _, t := range tags {
+		if cell, ok := strings.CutPrefix(t, matrixTagPrefix); ok {
+			return cell
+		}
+	}
+	return ""
+}
+
+// latestBuildPerCell keeps the newest build of each --matrix cell, the first of each in TeamCity's newest first
+// order, so --latest shows every cell rather than only the cell triggered last.
+func latestBuildPerCell(builds []tc.Build) []tc.Build {
+	seen := map[string]bool{}
+	latest := make([]tc.Build, 0, len(builds))
+	for _, b := range builds {
+		cell := matrixCellFromTags(b.Tags)
+		if !seen[cell] {
+			seen[cell] = true
+			latest = append(latest, b)
+		}
+	}
+	return latest
+}
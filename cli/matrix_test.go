@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/tc"
+)
+
+func TestParseMatrix(t *testing.T) {
+	t.Parallel()
+
+	cells, err := ParseMatrix([]string{"REGION=westeurope,eastus2", "FLAG=on,off"})
+	if err != nil {
+		t.Fatalf("ParseMatrix unexpected error: %v", err)
+	}
+
+	want := []string{
+		"REGION=westeurope,FLAG=on",
+		"REGION=westeurope,FLAG=off",
+		"REGION=eastus2,FLAG=on",
+		"REGION=eastus2,FLAG=off",
+	}
+	if len(cells) != len(want) {
+		t.Fatalf("ParseMatrix returned %d cells, want %d: %v", len(cells), len(want), cells)
+	}
+	for i, c := range cells {
+		if c.String() != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, c.String(), want[i])
+		}
+	}
+	if got := cells[1].Properties(); got != "REGION=westeurope;FLAG=off" {
+		t.Errorf("Properties() = %q", got)
+	}
+	if got := matrixCellFromTags([]string{"other", cells[0].Tag()}); got != want[0] {
+		t.Errorf("matrixCellFromTags = %q, want %q", got, want[0])
+	}
+
+	if cells, err := ParseMatrix(nil); err != nil || len(cells) != 1 || cells[0] != nil {
+		t.Errorf("ParseMatrix(nil) = %v, %v, want a single empty cell", cells, err)
+	}
+
+	for _, bad := range []string{"NOVALUES", "=a,b", "A=a,,b", "A=a;B=b"} {
+		if _, err := ParseMatrix([]string{bad}); err == nil {
+			t.Errorf("ParseMatrix(%q) expected an error", bad)
+		}
+	}
+	if _, err := ParseMatrix([]string{"A=1", "A=2"}); err == nil {
+		t.Error("ParseMatrix with a repeated key expected an error")
+	}
+}
+
+func TestLatestBuildPerCell(t *testing.T) {
+	t.Parallel()
+
+	// newest first, as TeamCity lists them
+	builds := []tc.Build{
+		{ID: 6, Tags: []string{"matrix:REGION=eastus2"}},
+		{ID: 5, Tags: []string{"other", "matrix:REGION=westeurope"}},
+		{ID: 4, Tags: []string{"matrix:REGION=eastus2"}},
+		{ID: 3},
+		{ID: 2, Tags: []string{"matrix:REGION=westeurope"}},
+		{ID: 1},
+	}
+
+	var got []int
+	for _, b := range latestBuildPerCell(builds) {
+		got = append(got, b.ID)
+	}
+	if want := []int{6, 5, 3}; !slices.Equal(got, want) {
+		t.Errorf("latestBuildPerCell = %v, want %v", got, want)
+	}
+}
@@ -1,14 +1,17 @@
 package cli
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -29,8 +32,31 @@ type AstDiscoveryContext struct {
 	// File tracking
 	TestFiles map[string]*provider.File
 
+	// provenance: the chains leading to changed resource file prefixes (keyed by dir/prefix) and the chains of
+	// every test found when parsing the test files
+	prefixChains map[string][]provider.Chain
+	TestChains   map[string][]provider.Chain
+
+	// importIndex is the reverse import index of each service directory, "" for every service, see importersOf
+	importIndex map[string]map[string][]string
+
+	// testImportIndex is the reverse import index of every service's test files, see testImportersOf
+	testImportIndex map[string][]string
+
+	// vendorIndex is the reverse import index of vendor/, see vendorImportersOf
+	vendorIndex map[string][]string
+
+	// SmokeTests are the smoke-tests run for each service in place of the tests traced from a shared package when
+	// they reach more than MaxTracedResources resource files
+	SmokeTests map[string][]string
+
+	// Explored holds the chains of every package and resource file tracing reached, whether or not tests were
+	// found for it, for list --graph
+	Explored []provider.Chain
+
 	// Accumulators for PrintDiscoveredFiles
 	ChangedFileLines []string
+	ChangedFiles     []provider.File
 }
 
 func NewAstDiscoveryContext(repoPath, modulePath string, cfg DiscoveryConfig) *AstDiscoveryContext {
@@ -41,20 +67,30 @@ func NewAstDiscoveryContext(repoPath, modulePath string, cfg DiscoveryConfig) *A
 
 		TestFiles: make(map[string]*provider.File),
 
+		prefixChains: make(map[string][]provider.Chain),
+		TestChains:   make(map[string][]provider.Chain),
+		importIndex:  make(map[string]map[string][]string),
+		SmokeTests:   make(map[string][]string),
+
 		ChangedFileLines: make([]string, 0),
 	}
 }
 
 // PrTestsFromAst performs test discovery using a local git clone of the repository.
-// When cfg.Mode is AST, this is called instead of PrTestsFromAPI (the HTTP-based path).
+// When cfg.Mode is AST or types, this is called instead of PrTestsFromAPI (the HTTP-based path).
 //
 // It fetches the PR merge ref, checks out the code, and uses Go AST to discover
 // affected tests — including tracing imports from helper/validation files back to
-// resource files to find their tests.
-func (ghr GithubRepo) PrTestsFromAst(pri int, cfg DiscoveryConfig) (map[string][]string, error) {
+// resource files to find their tests, with go/types in types mode (see TraceHelperFilesTypes).
+func (ghr GithubRepo) PrTestsFromAst(pr *github.PullRequest, files []*github.CommitFile, cfg DiscoveryConfig) (*Discovery, map[string][]string, error) {
+	pri := pr.GetNumber()
+	if pr.GetState() == gh.PRStateClosed {
+		return nil, nil, errors.New("cannot start build for a closed pr")
+	}
+
 	repoPath, err := filepath.Abs(cfg.LocalRepoPath)
 	if err != nil {
-		return nil, fmt.Errorf("resolving repo path: %w", err)
+		return nil, nil, fmt.Errorf("resolving repo path: %w", err)
 	}
 
 	// check for uncommitted changes and prompt user
@@ -68,25 +104,25 @@ func (ghr GithubRepo) PrTestsFromAst(pri int, cfg DiscoveryConfig) (map[string][
 
 		var answer string
 		if _, err := fmt.Scanln(&answer); err != nil {
-			return nil, fmt.Errorf("reading input: %w", err)
+			return nil, nil, fmt.Errorf("reading input: %w", err)
 		}
 
 		if strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
 			force = true
 		} else {
-			return nil, fmt.Errorf("repo at %s has uncommitted changes, aborting", repoPath)
+			return nil, nil, fmt.Errorf("repo at %s has uncommitted changes, aborting", repoPath)
 		}
 	}
 
 	// ensure repo path is a clean git clone (cloning if needed)
 	if err := git.EnsurePathIsRepo(repoPath, ghr.CloneURL(), force); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// capture current ref so we can restore it when done
 	originalRef, err := git.GetCurrentRef(repoPath)
 	if err != nil {
-		return nil, fmt.Errorf("getting current ref: %w", err)
+		return nil, nil, fmt.Errorf("getting current ref: %w", err)
 	}
 	defer func() {
 		cout.Printf("  restoring repo to <darkGray>%s</>\n", originalRef)
@@ -99,25 +135,14 @@ func (ghr GithubRepo) PrTestsFromAst(pri int, cfg DiscoveryConfig) (map[string][
 	cout.Printf("  fetching PR <cyan>#%d</> merge ref...\n", pri)
 	sha, err := ghr.CheckoutPR(repoPath, pri)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	cout.Printf("  checked out PR <cyan>#%d</> at merge commit <darkGray>%s</>\n", pri, sha)
 
-	// check PR state via GitHub API
-	client, ctx := ghr.NewClient()
-	clog.Log.Debugf("fetching data for PR %s/%s/#%d...", ghr.Owner, ghr.Name, pri)
-	pr, _, err := client.PullRequests.Get(ctx, ghr.Owner, ghr.Name, pri)
-	if err != nil {
-		return nil, err
-	}
-	if pr.GetState() == gh.PRStateClosed {
-		return nil, errors.New("cannot start build for a closed pr")
-	}
-
 	// get module path from go.mod for import tracing
 	modulePath, err := provider.GetModulePath(repoPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read module path: %w", err)
+		return nil, nil, fmt.Errorf("failed to read module path: %w", err)
 	}
 	clog.Log.Debugf("  module path: %s", modulePath)
 
@@ -128,15 +153,20 @@ func (ghr GithubRepo) PrTestsFromAst(pri int, cfg DiscoveryConfig) (map[string][
 	cout.Verbosef("  acctest file suffix patterns: <darkGray>%s</>\n", cfg.AccTestFileSuffixRegexStrings())
 
 	// fetch and categorise
-	resourcePrefixesByPackage, helperFiles, vendorFiles, err := dc.CollectChangedFiles(ghr, pri)
+	resourcePrefixesByPackage, helperFiles, vendorFiles, bumps, err := dc.CollectChangedFiles(files)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// trace files
 	dc.DiscoverSiblingTests(resourcePrefixesByPackage)
-	dc.TraceHelperFiles(helperFiles)
+	if strings.EqualFold(cfg.Mode, ModeTypes) {
+		dc.TraceHelperFilesTypes(helperFiles)
+	} else {
+		dc.TraceHelperFiles(helperFiles)
+	}
 	dc.TraceVendorFiles(vendorFiles)
+	dc.TraceModuleBumps(bumps)
 
 	// summarise results
 	dc.PrintDiscoveredFiles()
@@ -144,11 +174,38 @@ func (ghr GithubRepo) PrTestsFromAst(pri int, cfg DiscoveryConfig) (map[string][
 	// parse tests
 	tests, err := dc.ParseTestsConcurrently()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	changedPaths := make([]string, 0, len(dc.ChangedFiles))
+	for _, pf := range dc.ChangedFiles {
+		changedPaths = append(changedPaths, pf.RelPath)
+	}
+	smokeTests, smokeChains := cfg.SmokeSuites.Tests(changedPaths)
+	mergeSmokeTests(dc.SmokeTests, smokeTests)
+	for t, chains := range smokeChains {
+		dc.TestChains[t] = append(dc.TestChains[t], chains...)
 	}
+	mergeSmokeTests(tests, dc.SmokeTests)
 
 	clog.Log.Debugf("  FOUND %d services", len(tests))
-	return tests, nil
+
+	testFiles := make([]DiscoveryFile, 0, len(dc.TestFiles))
+	for _, pf := range dc.SortedTestFiles() {
+		testFiles = append(testFiles, newDiscoveryFile(*pf))
+	}
+	d := &Discovery{
+		PR:           pri,
+		Title:        pr.GetTitle(),
+		URL:          pr.GetHTMLURL(),
+		MergeSHA:     pr.GetMergeCommitSHA(),
+		ChangedFiles: newDiscoveryFiles(dc.ChangedFiles),
+		TestFiles:    testFiles,
+		smokeTests:   dc.SmokeTests,
+		testChains:   dc.TestChains,
+		explored:     dc.Explored,
+	}
+	return d, tests, nil
 }
 
 // --- Local test file discovery ---
@@ -205,16 +262,18 @@ func (dc *AstDiscoveryContext) findLocalTestFiles(relativeDir string, resourcePr
 // --- Import tracing ---
 
 // traceImportsToResourceFiles performs BFS import tracing from helper files to find
-// affected resource files within the same service boundary.
+// affected resource files within the same service boundary, or across every service for
+// a package shared by them (e.g., internal/tf/validation).
 //
 // For each helper file (e.g., internal/services/network/parse/helper.go), it:
 //  1. Determines the helper's Go package import path
-//  2. Walks the parent service directory
+//  2. Looks up the files of the parent service directory importing it, see importersOf
 //  3. Full-parses each .go file and checks for SelectorExpr usage of specific exported symbols
 //  4. If a file uses a changed symbol AND matches the fileregex, it's an affected resource
 //  5. If it uses a changed symbol but doesn't match fileregex, it's queued for the next depth level
 //
-// Returns map[dir][]string (same format as resourcePrefixesByPackage in GetPullRequestTestFiles).
+// Returns map[dir][]string (same format as resourcePrefixesByPackage in GetPullRequestTestFiles) and the chains
+// that reached each resource file: the changed helpers, the symbols used and any intermediate packages.
 // recordQueuedSymbols adds the exported symbols of an intermediate helper file to the
 // symbol set of its (next-level) package. Without this, packages beyond depth 0 have no
 // symbol info and the BFS degrades to "any importer matches", pulling in far too many tests.
@@ -232,40 +291,35 @@ func recordQueuedSymbols(pkgSymbols map[string]map[string]bool, nextLevel map[st
 	}
 }
 
-func (dc *AstDiscoveryContext) traceImportsToResourceFiles(helperFiles []provider.File, pkgSymbols map[string]map[string]bool) map[string][]string {
+func (dc *AstDiscoveryContext) traceImportsToResourceFiles(helperFiles []provider.File, pkgSymbols map[string]map[string]bool) (map[string][]string, map[string][]provider.Chain) {
 	result := map[string][]string{}
+	resourceChains := map[string][]provider.Chain{}
 
 	// collect unique packages of helper files
-	currentLevel := map[string]string{} // package import path -> service directory
+	currentLevel := map[string]string{} // package import path -> service directory, "" for every service
 	visited := map[string]bool{}
+	pkgChains := map[string]provider.Chain{} // package import path -> how the trace reached it
+	pkgHelpers := map[string][]string{}
+	for _, pf := range helperFiles {
+		pkgPath := dc.ModulePath + "/" + filepath.ToSlash(filepath.Dir(pf.RelPath))
+		pkgHelpers[pkgPath] = append(pkgHelpers[pkgPath], pf.RelPath)
+	}
 
 	for _, pf := range helperFiles {
 		f := pf.RelPath
 		dir := filepath.ToSlash(filepath.Dir(f))
 		pkgPath := dc.ModulePath + "/" + dir
 
-		// extract service directory from file path
-		// e.g., "internal/services/network/parse/helper.go" -> "internal/services/network"
-		serviceDir := ""
-		for _, prefix := range provider.ServiceDirPrefixes {
-			pfx := prefix + "/"
-			idx := strings.Index(f, pfx)
-			if idx < 0 {
-				continue
-			}
-			rest := f[idx+len(pfx):]
-			parts := strings.SplitN(rest, "/", 2)
-			if len(parts) >= 1 && parts[0] != "" {
-				serviceDir = f[:idx+len(pfx)] + parts[0]
-			}
-		}
-		if serviceDir == "" {
+		// a package shared by the services, e.g. internal/tf, is traced across all of them
+		serviceDir := serviceDirOf(f)
+		if serviceDir == "" && !pf.InSharedPackage() {
 			clog.Log.Debugf("    skipping %s: not in a service directory", f)
 			continue
 		}
 		if !visited[pkgPath] {
 			currentLevel[pkgPath] = serviceDir
 			visited[pkgPath] = true
+			pkgChains[pkgPath] = provider.Chain{{Kind: provider.LinkChangedFile, Value: strings.Join(pkgHelpers[pkgPath], ", ")}}
 			clog.Log.Debugf("    tracing package: %s (service dir: %s)", pkgPath, serviceDir)
 		}
 	}
@@ -275,96 +329,51 @@ func (dc *AstDiscoveryContext) traceImportsToResourceFiles(helperFiles []provide
 		nextLevel := map[string]string{}
 
 		for pkgPath, serviceDir := range currentLevel {
-			localServiceDir := filepath.Join(dc.RepoPath, serviceDir)
 			symbols := pkgSymbols[pkgPath] // may be nil if no exported symbols tracked
 
-			err := filepath.WalkDir(localServiceDir, func(path string, d os.DirEntry, walkErr error) error {
-				if walkErr != nil {
-					//nolint:nilerr // WalkDir: skip files with errors, continue walking
-					return nil
-				}
-				if d.IsDir() {
-					return nil // keep walking into subdirs
-				}
-				if !strings.HasSuffix(d.Name(), ".go") || strings.HasSuffix(d.Name(), "_test.go") {
-					return nil
-				}
-
-				// get path relative to repo root
-				relPath, relErr := filepath.Rel(dc.RepoPath, path)
-				if relErr != nil {
-					//nolint:nilerr // filepath.Rel failure is non-fatal, skip this file
-					return nil
-				}
-				relPath = filepath.ToSlash(relPath)
-
+			importers := dc.importersOf(serviceDir, pkgPath)
+			if len(importers) == 0 && serviceDir == "" {
+				dc.traceTestImporters(pkgPath, symbols, pkgChains[pkgPath], result, resourceChains)
+			}
+			for _, relPath := range importers {
 				// full parse to check both imports and symbol usage
 				fset := token.NewFileSet()
-				parsed, parseErr := parser.ParseFile(fset, path, nil, 0)
+				parsed, parseErr := parser.ParseFile(fset, filepath.Join(dc.RepoPath, relPath), nil, 0)
 				if parseErr != nil {
-					//nolint:nilerr // parse failure is non-fatal, skip this file
-					return nil
+					continue // parse failure is non-fatal, skip this file
 				}
 
-				// find the import alias for the target package
-				importAlias := ""
-				for _, imp := range parsed.Imports {
-					importPath := strings.Trim(imp.Path.Value, `"`)
-					if importPath != pkgPath {
-						continue
-					}
-					if imp.Name != nil {
-						importAlias = imp.Name.Name // explicit alias
-					} else {
-						// default alias is last path segment
-						parts := strings.Split(importPath, "/")
-						importAlias = parts[len(parts)-1]
-					}
-					break
-				}
-				if importAlias == "" {
-					return nil // doesn't import the target package
+				alias := importAlias(parsed, pkgPath)
+				if alias == "" {
+					continue // doesn't import the target package
 				}
 
+				// intermediate helpers are traced within their own service
+				helperServiceDir := cmp.Or(serviceDirOf(relPath), serviceDir)
+
 				// if we have no symbol info, fall back to package-level matching
 				if len(symbols) == 0 {
 					if dc.Config.FileRegEx.MatchString(relPath) {
 						dir := filepath.ToSlash(filepath.Dir(relPath))
 						result[dir] = append(result[dir], relPath)
+						resourceChains[relPath] = append(resourceChains[relPath], pkgChains[pkgPath].Then(provider.LinkResourceFile, relPath))
 						clog.Log.Debugf("    traced: %s imports %s (depth %d, package-level)", relPath, pkgPath, depth+1)
 					} else {
 						helperPkg := dc.ModulePath + "/" + filepath.ToSlash(filepath.Dir(relPath))
 						if !visited[helperPkg] {
-							nextLevel[helperPkg] = serviceDir
+							nextLevel[helperPkg] = helperServiceDir
 							visited[helperPkg] = true
+							pkgChains[helperPkg] = pkgChains[pkgPath].Then(provider.LinkPackage, filepath.ToSlash(filepath.Dir(relPath)))
 						}
 						recordQueuedSymbols(pkgSymbols, nextLevel, helperPkg, parsed)
 					}
-					return nil
+					continue
 				}
 
-				// walk the AST looking for SelectorExpr: alias.Symbol
-				usesSymbol := false
-				var usedSymbols []string
-				ast.Inspect(parsed, func(n ast.Node) bool {
-					sel, ok := n.(*ast.SelectorExpr)
-					if !ok {
-						return true
-					}
-					ident, ok := sel.X.(*ast.Ident)
-					if !ok || ident.Name != importAlias {
-						return true
-					}
-					if symbols[sel.Sel.Name] {
-						usesSymbol = true
-						usedSymbols = append(usedSymbols, sel.Sel.Name)
-					}
-					return true
-				})
-
-				if !usesSymbol {
+				usedSymbols := selectorsOf(parsed, alias, symbols)
+				if len(usedSymbols) == 0 {
 					clog.Log.Debugf("    skipped: %s imports %s but doesn't use changed symbols", relPath, pkgPath)
-					return nil
+					continue
 				}
 
 				// this file uses a changed symbol
@@ -372,29 +381,153 @@ func (dc *AstDiscoveryContext) traceImportsToResourceFiles(helperFiles []provide
 					// it's a resource file — add to results
 					dir := filepath.ToSlash(filepath.Dir(relPath))
 					result[dir] = append(result[dir], relPath)
+					resourceChains[relPath] = append(resourceChains[relPath], pkgChains[pkgPath].
+						Then(provider.LinkSymbol, joinSymbols(usedSymbols)).
+						Then(provider.LinkResourceFile, relPath))
 					clog.Log.Debugf("    traced: %s uses %v from %s (depth %d)", relPath, usedSymbols, pkgPath, depth+1)
 				} else {
 					// it's another helper — queue for next depth
 					helperPkg := dc.ModulePath + "/" + filepath.ToSlash(filepath.Dir(relPath))
 					if !visited[helperPkg] {
-						nextLevel[helperPkg] = serviceDir
+						nextLevel[helperPkg] = helperServiceDir
 						visited[helperPkg] = true
+						pkgChains[helperPkg] = pkgChains[pkgPath].
+							Then(provider.LinkSymbol, joinSymbols(usedSymbols)).
+							Then(provider.LinkPackage, filepath.ToSlash(filepath.Dir(relPath)))
 						clog.Log.Debugf("    intermediate: %s uses %v from %s, queuing for depth %d", relPath, usedSymbols, pkgPath, depth+2)
 					}
 					recordQueuedSymbols(pkgSymbols, nextLevel, helperPkg, parsed)
 				}
+			}
+		}
+
+		currentLevel = nextLevel
+	}
+
+	for _, pkgPath := range slices.Sorted(maps.Keys(pkgChains)) {
+		dc.Explored = append(dc.Explored, pkgChains[pkgPath])
+	}
+	for _, relPath := range slices.Sorted(maps.Keys(resourceChains)) {
+		dc.Explored = append(dc.Explored, resourceChains[relPath]...)
+	}
+
+	return result, resourceChains
+}
+
+// traceTestImporters adds the test files importing a shared package only tests import, such as internal/acceptance,
+// and using its changed symbols, to result and resourceChains as if they were resource files.
+func (dc *AstDiscoveryContext) traceTestImporters(pkgPath string, symbols map[string]bool, chain provider.Chain, result map[string][]string, resourceChains map[string][]provider.Chain) {
+	for _, relPath := range dc.testImportersOf(pkgPath) {
+		parsed, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dc.RepoPath, relPath), nil, 0)
+		if err != nil {
+			continue // parse failure is non-fatal, skip this file
+		}
+		alias := importAlias(parsed, pkgPath)
+		if alias == "" {
+			continue
+		}
 
+		tc := chain
+		if len(symbols) > 0 {
+			usedSymbols := selectorsOf(parsed, alias, symbols)
+			if len(usedSymbols) == 0 {
+				clog.Log.Debugf("    skipped: %s imports %s but doesn't use changed symbols", relPath, pkgPath)
+				continue
+			}
+			tc = tc.Then(provider.LinkSymbol, joinSymbols(usedSymbols))
+		}
+		dir := filepath.ToSlash(filepath.Dir(relPath))
+		result[dir] = append(result[dir], relPath)
+		resourceChains[relPath] = append(resourceChains[relPath], tc.Then(provider.LinkTestFile, relPath))
+		clog.Log.Debugf("    traced: test file %s imports %s", relPath, pkgPath)
+	}
+}
+
+// importersOf returns the non-test go files of a service directory, or of every service when it's "", that import a
+// package. The reverse import index of each is built once, see buildImportIndex.
+func (dc *AstDiscoveryContext) importersOf(serviceDir, pkgPath string) []string {
+	if index, ok := dc.importIndex[serviceDir]; ok {
+		return index[pkgPath]
+	}
+
+	roots := []string{serviceDir}
+	if serviceDir == "" {
+		roots = dc.Config.serviceDirs()
+	}
+	index := dc.buildImportIndex(roots, false)
+	clog.Log.Debugf("    indexed the imports of %s: %d packages imported", cmp.Or(serviceDir, strings.Join(roots, ", ")), len(index))
+
+	dc.importIndex[serviceDir] = index
+	return index[pkgPath]
+}
+
+// testImportersOf returns the test files of every service that import a package, for a shared package only tests
+// import such as internal/acceptance. The reverse import index is built once, see buildImportIndex.
+func (dc *AstDiscoveryContext) testImportersOf(pkgPath string) []string {
+	if dc.testImportIndex == nil {
+		dc.testImportIndex = dc.buildImportIndex(dc.Config.serviceDirs(), true)
+		clog.Log.Debugf("    indexed the imports of the services' test files: %d packages imported", len(dc.testImportIndex))
+	}
+	return dc.testImportIndex[pkgPath]
+}
+
+// buildImportIndex returns the reverse import index of the non-test go files under the roots, or of the test files
+// with tests, relative to the repo: each imported package's path to the files importing it. It reads the files'
+// imports only.
+func (dc *AstDiscoveryContext) buildImportIndex(roots []string, tests bool) map[string][]string {
+	index := map[string][]string{}
+	for _, root := range roots {
+		err := filepath.WalkDir(filepath.Join(dc.RepoPath, root), func(path string, d os.DirEntry, walkErr error) error {
+			if walkErr != nil || d.IsDir() {
+				return nil //nolint:nilerr // WalkDir: skip files with errors, continue walking
+			}
+			if !strings.HasSuffix(d.Name(), ".go") || strings.HasSuffix(d.Name(), "_test.go") != tests {
 				return nil
-			})
-			if err != nil {
-				clog.Log.Debugf("    error walking %s: %v", localServiceDir, err)
 			}
+
+			relPath, relErr := filepath.Rel(dc.RepoPath, path)
+			if relErr != nil {
+				return nil //nolint:nilerr // filepath.Rel failure is non-fatal, skip this file
+			}
+			parsed, parseErr := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+			if parseErr != nil {
+				return nil //nolint:nilerr // parse failure is non-fatal, skip this file
+			}
+			for _, imp := range parsed.Imports {
+				importPath := strings.Trim(imp.Path.Value, `"`)
+				index[importPath] = append(index[importPath], filepath.ToSlash(relPath))
+			}
+			return nil
+		})
+		if err != nil {
+			clog.Log.Debugf("    error walking %s: %v", root, err)
 		}
+	}
+	return index
+}
 
-		currentLevel = nextLevel
+// serviceDirOf returns the service directory a file is in,
+// e.g. "internal/services/network/parse/helper.go" -> "internal/services/network", or "" outside of one.
+func serviceDirOf(f string) string {
+	serviceDir := ""
+	for _, prefix := range provider.ServiceDirPrefixes {
+		pfx := prefix + "/"
+		idx := strings.Index(f, pfx)
+		if idx < 0 {
+			continue
+		}
+		rest := f[idx+len(pfx):]
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) >= 1 && parts[0] != "" {
+			serviceDir = f[:idx+len(pfx)] + parts[0]
+		}
 	}
+	return serviceDir
+}
 
-	return result
+// joinSymbols returns the unique symbols, sorted and comma separated, for a chain link.
+func joinSymbols(symbols []string) string {
+	return strings.Join(slices.Compact(slices.Sorted(slices.Values(symbols))), ", ")
 }
 
 func (dc *AstDiscoveryContext) SortedTestFiles() []*provider.File {
@@ -408,65 +541,90 @@ func (dc *AstDiscoveryContext) SortedTestFiles() []*provider.File {
 	return files
 }
 
-func (dc *AstDiscoveryContext) AddTestFile(pf provider.File, source string) {
+// AddTestFile records a discovered test file and the chains that led to it.
+func (dc *AstDiscoveryContext) AddTestFile(pf provider.File, source string, chains ...provider.Chain) {
 	existing, ok := dc.TestFiles[pf.RelPath]
 	if !ok {
 		existing = &pf
 		dc.TestFiles[pf.RelPath] = existing
 	}
 	existing.AddDiscovery(source)
+	for _, c := range chains {
+		existing.AddChain(c)
+	}
 }
 
-func (dc *AstDiscoveryContext) CollectChangedFiles(ghr GithubRepo, pri int) (resourcePrefixesByPackage map[string][]string, helperFiles, vendorFiles []provider.File, err error) {
+func (dc *AstDiscoveryContext) CollectChangedFiles(files []*github.CommitFile) (resourcePrefixesByPackage map[string][]string, helperFiles, vendorFiles []provider.File, bumps []provider.ModuleBump, err error) {
 	resourcePrefixesByPackage = map[string][]string{}
 
-	err = ghr.ListAllPullRequestFiles(pri, func(files []*github.CommitFile, _ *github.Response) error {
-		for _, f := range files {
-			if f.Filename == nil {
-				continue
-			}
-			pf := provider.NewFileWithPath(f.GetFilename(), dc.RepoPath)
-			clog.Log.Debugf("    %v (%s)", pf.RelPath, f.GetStatus())
-
-			if !strings.HasSuffix(pf.RelPath, ".go") {
-				clog.Log.Debugf("    skipping non go file: %s", pf.RelPath)
-				continue
-			}
-			if f.GetStatus() == "removed" {
-				clog.Log.Debugf("    skipping removed file: %s", pf.RelPath)
-				continue
-			}
-
-			switch pf.Type {
-			case provider.FileTypeOther:
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>%s</>\n", pf.ColouredFileName(), pf.TypeLabel()))
-
-			case provider.FileTypeTest:
+	for _, f := range files {
+		if f.Filename == nil {
+			continue
+		}
+		pf := provider.NewFileWithPath(f.GetFilename(), dc.RepoPath)
+		clog.Log.Debugf("    %v (%s)", pf.RelPath, f.GetStatus())
 
-				dc.AddTestFile(pf, "CHANGED")
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[TEST]</>\n", pf.ColouredFileName()))
+		if provider.IsModuleFile(pf.RelPath) {
+			bumps = append(bumps, dc.moduleBumps(pf.RelPath, f)...)
+			dc.ChangedFiles = append(dc.ChangedFiles, pf)
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[MODULES]</>\n", pf.ColouredFileName()))
+			continue
+		}
 
-			case provider.FileTypeUnitTest:
-				clog.Log.Debugf("    %s: no TestAcc functions, skipping", pf.RelPath)
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[UNIT]</>\n", pf.ColouredFileName()))
+		// files outside the service packages run the smoke suites they match, shared packages are traced as well
+		if dc.Config.SmokeSuites.Matches(pf.RelPath) && (!pf.InSharedPackage() || f.GetStatus() == "removed") {
+			dc.ChangedFiles = append(dc.ChangedFiles, pf)
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[SMOKE]</>\n", pf.ColouredFileName()))
+			continue
+		}
 
-			case provider.FileTypeResource:
-				resourcePrefixesByPackage[path.Dir(pf.RelPath)] = append(resourcePrefixesByPackage[path.Dir(pf.RelPath)], pf.ResourcePrefix())
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[RESOURCE]</>\n", pf.ColouredFileName()))
+		if !strings.HasSuffix(pf.RelPath, ".go") {
+			clog.Log.Debugf("    skipping non go file: %s", pf.RelPath)
+			continue
+		}
+		if f.GetStatus() == "removed" {
+			clog.Log.Debugf("    skipping removed file: %s", pf.RelPath)
+			continue
+		}
 
-			case provider.FileTypeHelper:
+		dc.ChangedFiles = append(dc.ChangedFiles, pf)
+		switch pf.Type {
+		case provider.FileTypeOther:
+			if pf.InSharedPackage() {
+				dc.setChangedLines(&pf, f)
 				helperFiles = append(helperFiles, pf)
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[HELPER]</>\n", pf.ColouredFileName()))
-
-			case provider.FileTypeVendor:
-				vendorFiles = append(vendorFiles, pf)
-				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[VENDOR]</>\n", pf.ColouredFileName()))
+				dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[SHARED]</>\n", pf.ColouredFileName()))
+				continue
 			}
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>%s</>\n", pf.ColouredFileName(), pf.TypeLabel()))
+
+		case provider.FileTypeTest:
+			dc.setChangedLines(&pf, f)
+			dc.AddTestFile(pf, "CHANGED", provider.Chain{{Kind: provider.LinkChangedFile, Value: pf.RelPath}})
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[TEST]</>\n", pf.ColouredFileName()))
+
+		case provider.FileTypeUnitTest:
+			clog.Log.Debugf("    %s: no TestAcc functions, skipping", pf.RelPath)
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[UNIT]</>\n", pf.ColouredFileName()))
+
+		case provider.FileTypeResource:
+			resourcePrefixesByPackage[path.Dir(pf.RelPath)] = append(resourcePrefixesByPackage[path.Dir(pf.RelPath)], pf.ResourcePrefix())
+			key := path.Join(path.Dir(pf.RelPath), pf.ResourcePrefix())
+			dc.prefixChains[key] = append(dc.prefixChains[key], provider.Chain{{Kind: provider.LinkChangedFile, Value: pf.RelPath}})
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[RESOURCE]</>\n", pf.ColouredFileName()))
+
+		case provider.FileTypeHelper:
+			dc.setChangedLines(&pf, f)
+			helperFiles = append(helperFiles, pf)
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[HELPER]</>\n", pf.ColouredFileName()))
+
+		case provider.FileTypeVendor:
+			if dc.Config.LocalVendorMode == VendorModeDeep {
+				dc.setChangedLines(&pf, f)
+			}
+			vendorFiles = append(vendorFiles, pf)
+			dc.ChangedFileLines = append(dc.ChangedFileLines, fmt.Sprintf("    %s <darkGray>[VENDOR]</>\n", pf.ColouredFileName()))
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to get PR files: %w", err)
 	}
 
 	cout.Printf("  changed files: <yellow>%d</>\n", len(dc.ChangedFileLines))
@@ -482,18 +640,75 @@ func (dc *AstDiscoveryContext) CollectChangedFiles(ghr GithubRepo, pri int) (res
 		cout.Printf("    <yellow>%d</> <fg=208>exceeds display limit of</> <yellow>%d</><darkGray>, use -v or --collapse-files-after 0 to see all</>\n", len(dc.ChangedFileLines), dc.Config.CollapseFilesAfter)
 	}
 
-	return resourcePrefixesByPackage, helperFiles, vendorFiles, nil
+	return resourcePrefixesByPackage, helperFiles, vendorFiles, mergeModuleBumps(bumps), nil
 }
 
-func (dc *AstDiscoveryContext) DiscoverSiblingTests(resourcePrefixesByPackage map[string][]string) {
-	for dir, prefixes := range resourcePrefixesByPackage {
-		discovered, err := dc.findLocalTestFiles(dir, prefixes)
+// setChangedLines records the lines the PR changed in a helper or test file, so only the declarations it changed are
+// traced and only the tests it changed are run, see patchChangedLines. When GitHub omits the patch of a large diff
+// they come from a git diff of the merge commit against its base.
+func (dc *AstDiscoveryContext) setChangedLines(pf *provider.File, f *github.CommitFile) {
+	if !diffAware(f, dc.Config) {
+		return
+	}
+
+	patch := f.GetPatch()
+	if patch == "" {
+		diff, err := git.DiffFromFirstParent(dc.RepoPath, pf.RelPath)
 		if err != nil {
-			clog.Log.Debugf("  failed to find test files in %s: %v", dir, err)
-			continue
+			clog.Log.Debugf("    no diff for %s, using the whole file: %v", pf.RelPath, err)
+			return
 		}
-		for _, pf := range discovered {
-			dc.AddTestFile(pf, "DERIVED")
+		patch = diff
+	}
+	pf.ChangedLines = changedLines(pf.RelPath, patch)
+}
+
+// changedSymbols returns the symbols of a changed helper file to trace: every symbol it declares when the changed
+// lines aren't known or are all outside a declaration (imports, or code such as init blocks that can change what
+// any of them do), otherwise the declarations the PR changed and those in the package referring to them.
+func changedSymbols(pf provider.File, exportedOnly bool) []string {
+	if pf.ChangedLines == nil {
+		return pf.Symbols(exportedOnly)
+	}
+
+	changed := pf.ChangedSymbols(false)
+	if len(changed) == 0 {
+		clog.Log.Debugf("    %s changed no declarations, tracing all its symbols", pf.RelPath)
+		return pf.Symbols(exportedOnly)
+	}
+	symbols := provider.PackageReferrers(filepath.Dir(pf.Path), changed)
+	if exportedOnly {
+		symbols = slices.DeleteFunc(symbols, func(s string) bool { return !token.IsExported(s) })
+	}
+	clog.Log.Debugf("    %s changed %v, tracing %v", pf.RelPath, changed, symbols)
+	return symbols
+}
+
+// noSymbolsReason is shown for a helper file with no symbols to trace
+func noSymbolsReason(pf provider.File, exportedOnly bool) string {
+	switch {
+	case pf.ChangedLines != nil && exportedOnly:
+		return "no changed exported symbols"
+	case pf.ChangedLines != nil:
+		return "no changed symbols"
+	case exportedOnly:
+		return "no exported symbols"
+	}
+	return "no symbols found"
+}
+
+func (dc *AstDiscoveryContext) DiscoverSiblingTests(resourcePrefixesByPackage map[string][]string) {
+	for dir, prefixes := range resourcePrefixesByPackage {
+		// one prefix at a time so each test file is attributed to the changed file(s) it was derived from
+		for _, prefix := range slices.Compact(slices.Sorted(slices.Values(prefixes))) {
+			discovered, err := dc.findLocalTestFiles(dir, []string{prefix})
+			if err != nil {
+				clog.Log.Debugf("  failed to find test files in %s: %v", dir, err)
+				break
+			}
+			for _, pf := range discovered {
+				dc.AddTestFile(pf, "DERIVED", dc.prefixChains[path.Join(dir, prefix)]...)
+			}
 		}
 	}
 }
@@ -537,15 +752,17 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 
 	for dir, helpers := range samePkgHelpers {
 		symbols := map[string]bool{}
+		helperSymbols := map[string][]string{}
 		for _, pf := range helpers {
-			for _, s := range pf.Symbols(false) {
+			helperSymbols[pf.RelPath] = changedSymbols(pf, false)
+			for _, s := range helperSymbols[pf.RelPath] {
 				symbols[s] = true
 			}
 			clog.Log.Debugf("    same-pkg helper %s symbols: %v", pf.RelPath, symbols)
 		}
 		if len(symbols) == 0 {
 			for _, pf := range helpers {
-				cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>no symbols found</>\n", pf.Dir, pf.Name)
+				cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>%s</>\n", pf.Dir, pf.Name, noSymbolsReason(pf, false))
 			}
 			continue
 		}
@@ -587,6 +804,20 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 				tracedFile := provider.NewFileWithPath(relPath, dc.RepoPath)
 				clog.Log.Debugf("    same-pkg traced: %s uses %v", relPath, usedSymbols)
 
+				// attribute the used symbols to the helper(s) declaring them
+				var chains []provider.Chain
+				for _, h := range helpers {
+					hs := helperSymbols[h.RelPath]
+					used := slices.DeleteFunc(slices.Clone(usedSymbols), func(s string) bool { return !slices.Contains(hs, s) })
+					if len(used) == 0 {
+						continue
+					}
+					chains = append(chains, provider.Chain{{Kind: provider.LinkChangedFile, Value: h.RelPath}}.
+						Then(provider.LinkSymbol, joinSymbols(used)).
+						Then(provider.LinkResourceFile, relPath))
+				}
+				dc.Explored = append(dc.Explored, chains...)
+
 				resourcePrefixes := []string{tracedFile.ResourcePrefix()}
 				for _, f := range helpers {
 					allHelperTraced[f.RelPath] = append(allHelperTraced[f.RelPath], tracedFile)
@@ -598,7 +829,7 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 					continue
 				}
 				for _, pf := range discovered {
-					dc.AddTestFile(pf, "TRACED")
+					dc.AddTestFile(pf, "TRACED", chains...)
 				}
 			}
 		}
@@ -628,15 +859,22 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 
 	if len(crossPkgHelpers) > 0 {
 		pkgSymbols := map[string]map[string]bool{}
+		var traceable []provider.File
 		for _, pf := range crossPkgHelpers {
 			dir := filepath.ToSlash(filepath.Dir(pf.RelPath))
 			pkgPath := dc.ModulePath + "/" + dir
 
-			symbols := pf.Symbols(true)
+			symbols := changedSymbols(pf, true)
 			if len(symbols) == 0 {
-				cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>no exported symbols</>\n", pf.Dir, pf.Name)
+				cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>%s</>\n", pf.Dir, pf.Name, noSymbolsReason(pf, true))
+				// without its diff a helper is still traced by package, but one whose changes no exported symbol
+				// reaches can't affect its importers
+				if pf.ChangedLines == nil {
+					traceable = append(traceable, pf)
+				}
 				continue
 			}
+			traceable = append(traceable, pf)
 			if pkgSymbols[pkgPath] == nil {
 				pkgSymbols[pkgPath] = map[string]bool{}
 			}
@@ -646,21 +884,25 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 			clog.Log.Debugf("    %s exports: %v", pf.RelPath, symbols)
 		}
 
-		tracedDirs := dc.traceImportsToResourceFiles(crossPkgHelpers, pkgSymbols)
+		tracedDirs, resourceChains := dc.traceImportsToResourceFiles(traceable, pkgSymbols)
+		tracedDirs = dc.capSharedTrace(tracedDirs, resourceChains)
 
 		for dir, files := range tracedDirs {
-			var prefixes []string
-			for _, f := range files {
+			// one resource file at a time so each test file is attributed to the chains that reached it
+			for _, f := range slices.Compact(slices.Sorted(slices.Values(files))) {
 				tpf := provider.NewFileWithPath(f, dc.RepoPath)
-				prefixes = append(prefixes, tpf.ResourcePrefix())
-			}
-			discovered, err := dc.findLocalTestFiles(dir, prefixes)
-			if err != nil {
-				clog.Log.Debugf("  failed to find test files in %s: %v", dir, err)
-				continue
-			}
-			for _, pf := range discovered {
-				dc.AddTestFile(pf, "TRACED")
+				if tpf.Type == provider.FileTypeTest || tpf.Type == provider.FileTypeUnitTest {
+					dc.AddTestFile(tpf, "TRACED", resourceChains[f]...)
+					continue
+				}
+				discovered, err := dc.findLocalTestFiles(dir, []string{tpf.ResourcePrefix()})
+				if err != nil {
+					clog.Log.Debugf("  failed to find test files in %s: %v", dir, err)
+					break
+				}
+				for _, pf := range discovered {
+					dc.AddTestFile(pf, "TRACED", resourceChains[f]...)
+				}
 			}
 		}
 
@@ -694,20 +936,97 @@ func (dc *AstDiscoveryContext) TraceHelperFiles(helperFiles []provider.File) {
 	}
 }
 
+// capSharedTrace runs the smoke-tests of the services instead of the tests of the resource files traced from packages
+// shared by the services when there are more than --max-traced-resources of them, so a change to e.g. internal/tf
+// doesn't run every test. A service without smoke-tests keeps its traced tests. It removes the replaced chains from
+// resourceChains and returns the traced directories left.
+func (dc *AstDiscoveryContext) capSharedTrace(tracedDirs map[string][]string, resourceChains map[string][]provider.Chain) map[string][]string {
+	// each chain starts at the changed helpers of one package
+	shared := func(c provider.Chain) bool {
+		helper := provider.NewFile(strings.Split(c[0].Value, ", ")[0])
+		return helper.InSharedPackage()
+	}
+
+	var sharedTraced []string
+	for relPath, chains := range resourceChains {
+		if slices.ContainsFunc(chains, shared) {
+			sharedTraced = append(sharedTraced, relPath)
+		}
+	}
+	if dc.Config.MaxTracedResources <= 0 || len(sharedTraced) <= dc.Config.MaxTracedResources {
+		return tracedDirs
+	}
+
+	// the changed shared packages that reached each service
+	serviceSources := map[string][]string{}
+	for _, relPath := range sharedTraced {
+		service := provider.NewFile(relPath).Service
+		for _, c := range resourceChains[relPath] {
+			if shared(c) && !slices.Contains(serviceSources[service], c[0].Value) {
+				serviceSources[service] = append(serviceSources[service], c[0].Value)
+			}
+		}
+	}
+
+	var without []string
+	smoked := 0
+	for _, service := range slices.Sorted(maps.Keys(serviceSources)) {
+		tests := dc.Config.SmokeTests[service]
+		if len(tests) == 0 {
+			without = append(without, service)
+			continue
+		}
+		smoked++
+		dc.SmokeTests[service] = tests
+		for _, source := range slices.Sorted(slices.Values(serviceSources[service])) {
+			chain := provider.Chain{{Kind: provider.LinkChangedFile, Value: source}}.Then(provider.LinkSmokeSuite, service)
+			dc.Explored = append(dc.Explored, chain)
+			for _, t := range tests {
+				dc.TestChains[t] = append(dc.TestChains[t], chain.Then(provider.LinkTest, t))
+			}
+		}
+	}
+
+	for _, relPath := range sharedTraced {
+		if slices.Contains(without, provider.NewFile(relPath).Service) {
+			continue
+		}
+		resourceChains[relPath] = slices.DeleteFunc(resourceChains[relPath], shared)
+		if len(resourceChains[relPath]) == 0 {
+			delete(resourceChains, relPath)
+		}
+	}
+
+	cout.Printf("  <yellow>%d</> resource file(s) traced from shared packages exceeds --max-traced-resources <yellow>%d</>, running the smoke-tests of <cyan>%d</> service(s)\n",
+		len(sharedTraced), dc.Config.MaxTracedResources, smoked)
+	if len(without) > 0 {
+		cout.Printf("  <yellow>WARNING:</> no smoke-tests in the service map for %s, running their traced tests\n", strings.Join(without, ", "))
+	}
+
+	remaining := map[string][]string{}
+	for relPath := range resourceChains {
+		dir := filepath.ToSlash(filepath.Dir(relPath))
+		remaining[dir] = append(remaining[dir], relPath)
+	}
+	return remaining
+}
+
 func (dc *AstDiscoveryContext) TraceVendorFiles(vendorFiles []provider.File) {
-	if len(vendorFiles) == 0 || dc.Config.LocalTraceDepth == 0 || dc.Config.LocalVendorMode != "basic" {
+	if len(vendorFiles) == 0 || dc.Config.LocalTraceDepth == 0 || dc.Config.LocalVendorMode == VendorModeNone {
 		return
 	}
 
 	vendorPkgs := map[string]bool{}
 	vendorFileToPkg := map[string]string{}
+	pkgToVendorFiles := map[string][]string{}
 	pkgToResources := map[string][]provider.File{}
 
 	for _, pf := range vendorFiles {
 		f := pf.RelPath
-		pkgImportPath := filepath.ToSlash(filepath.Dir(strings.TrimPrefix(f, "vendor/")))
+		pkgImportPath := vendorPackage(f)
 		vendorPkgs[pkgImportPath] = true
 		vendorFileToPkg[f] = pkgImportPath
+		pkgToVendorFiles[pkgImportPath] = append(pkgToVendorFiles[pkgImportPath], f)
 		clog.Log.Debugf("    vendor package: %s", pkgImportPath)
 	}
 
@@ -716,8 +1035,20 @@ func (dc *AstDiscoveryContext) TraceVendorFiles(vendorFiles []provider.File) {
 			pf.Dir, pf.Name, vendorFileToPkg[pf.RelPath])
 	}
 
+	// the chain reaching each vendored package, deep mode adds the packages importing the changed ones
+	pkgChains := map[string]provider.Chain{}
+	for pkg, files := range pkgToVendorFiles {
+		pkgChains[pkg] = provider.Chain{{Kind: provider.LinkChangedFile, Value: strings.Join(files, ", ")}}.Then(provider.LinkVendorPackage, pkg)
+	}
+	if dc.Config.LocalVendorMode == VendorModeDeep {
+		dc.traceVendorImporters(vendorFiles, pkgChains)
+		for pkg := range pkgChains {
+			vendorPkgs[pkg] = true
+		}
+	}
+
 	foundServiceDir := false
-	for _, prefix := range provider.ServiceDirPrefixes {
+	for _, prefix := range dc.Config.serviceDirs() {
 		servicesDir := filepath.Join(dc.RepoPath, prefix)
 		if _, err := os.Stat(servicesDir); err != nil {
 			continue
@@ -769,8 +1100,10 @@ func (dc *AstDiscoveryContext) TraceVendorFiles(vendorFiles []provider.File) {
 				if findErr != nil {
 					return nil //nolint:nilerr // intentional: skip dirs where test discovery fails, keep tracing
 				}
+				chain := pkgChains[impPath].Then(provider.LinkResourceFile, relPath)
+				dc.Explored = append(dc.Explored, chain)
 				for _, pf := range discovered {
-					dc.AddTestFile(pf, "VENDOR")
+					dc.AddTestFile(pf, "VENDOR", chain)
 				}
 				break
 			}
@@ -780,7 +1113,7 @@ func (dc *AstDiscoveryContext) TraceVendorFiles(vendorFiles []provider.File) {
 	}
 
 	if !foundServiceDir {
-		cout.Printf("  <yellow>WARNING:</> no service directory found (tried %s)\n", strings.Join(provider.ServiceDirPrefixes, ", "))
+		cout.Printf("  <yellow>WARNING:</> no service directory found (tried %s)\n", strings.Join(dc.Config.serviceDirs(), ", "))
 	}
 
 	vendorTracedCount := 0
@@ -815,7 +1148,7 @@ func (dc *AstDiscoveryContext) PrintDiscoveredFiles() {
 			switch s {
 			case "CHANGED":
 				hasChanged = true
-			case "VENDOR":
+			case "VENDOR", "MODULE":
 				hasVendor = true
 			case "TRACED":
 				hasTraced = true
@@ -874,6 +1207,7 @@ func (dc *AstDiscoveryContext) ParseTestsConcurrently() (map[string][]string, er
 				}
 				serviceTestMap[pfile.Service][t] = true
 			}
+			addTestChains(dc.TestChains, *pfile, tests)
 			mu.Unlock()
 		}(pf)
 	}
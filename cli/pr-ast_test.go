@@ -0,0 +1,322 @@
+package cli
+
+import (
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func writeRepoFiles(t *testing.T, repo string, files map[string]string) {
+	t.Helper()
+
+	for relPath, content := range files {
+		p := filepath.Join(repo, relPath)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestDiscoveryContext(repo string) *AstDiscoveryContext {
+	return NewAstDiscoveryContext(repo, "example.com/provider", DiscoveryConfig{
+		FileRegEx:                regexp.MustCompile(`^internal/services?/[^/]+/[a-z0-9_][^/]*$`),
+		AccTestFileSuffixRegexes: []*regexp.Regexp{regexp.MustCompile(`^_resource.*_test$`)},
+		SplitTestsOn:             "_",
+		LocalTraceDepth:          10,
+		Concurrency:              1,
+	})
+}
+
+func TestTraceHelperFilesChains(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"internal/services/dns/parse/id.go": `package parse
+
+func ParseID() {}
+`,
+		"internal/services/dns/validate/id.go": `package validate
+
+import "example.com/provider/internal/services/dns/parse"
+
+func ID() { parse.ParseID() }
+`,
+		"internal/services/dns/dns_a_record_resource.go": `package dns
+
+import "example.com/provider/internal/services/dns/validate"
+
+var _ = validate.ID
+`,
+		"internal/services/dns/dns_a_record_resource_test.go": `package dns_test
+
+import "testing"
+
+func TestAccDnsARecord_basic(t *testing.T) {}
+`,
+	})
+
+	dc := newTestDiscoveryContext(repo)
+	dc.TraceHelperFiles([]provider.File{provider.NewFileWithPath("internal/services/dns/parse/id.go", repo)})
+
+	pf, ok := dc.TestFiles["internal/services/dns/dns_a_record_resource_test.go"]
+	if !ok {
+		t.Fatalf("test files = %v, want the dns_a_record resource test traced", dc.TestFiles)
+	}
+	want := "internal/services/dns/parse/id.go [changed file] → ParseID [symbol] → internal/services/dns/validate [package] → " +
+		"ID [symbol] → internal/services/dns/dns_a_record_resource.go [resource file] → " +
+		"internal/services/dns/dns_a_record_resource_test.go [test file]"
+	if len(pf.Chains) != 1 || pf.Chains[0].String() != want {
+		t.Fatalf("chains = %v, want [%s]", pf.Chains, want)
+	}
+
+	if _, err := dc.ParseTestsConcurrently(); err != nil {
+		t.Fatal(err)
+	}
+	chains := dc.TestChains["TestAccDnsARecord"]
+	if len(chains) != 1 || chains[0].String() != want+" → TestAccDnsARecord [test]" {
+		t.Errorf("test chains = %v, want the file chain ending at TestAccDnsARecord", chains)
+	}
+}
+
+func TestTraceHelperFilesChangedSymbols(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"internal/services/dns/parse/id.go": `package parse
+
+func ARecordID() string { return "" }
+
+func CnameRecordID() string { return normalise() }
+
+func normalise() string { return "" }
+`,
+		"internal/services/dns/dns_a_record_resource.go": `package dns
+
+import "example.com/provider/internal/services/dns/parse"
+
+var _ = parse.ARecordID
+`,
+		"internal/services/dns/dns_a_record_resource_test.go":     "package dns_test\n",
+		"internal/services/dns/dns_cname_record_resource_test.go": "package dns_test\n",
+		"internal/services/dns/dns_cname_record_resource.go":      "package dns\n\nimport \"example.com/provider/internal/services/dns/parse\"\n\nvar _ = parse.CnameRecordID\n",
+	})
+
+	helper := provider.NewFileWithPath("internal/services/dns/parse/id.go", repo)
+	tests := map[string]struct {
+		lines []provider.LineRange
+		want  []string
+	}{
+		"whole file": {nil, []string{"internal/services/dns/dns_a_record_resource_test.go", "internal/services/dns/dns_cname_record_resource_test.go"}},
+		// the unexported function is only reached through CnameRecordID
+		"unexported change": {[]provider.LineRange{{Start: 7, End: 7}}, []string{"internal/services/dns/dns_cname_record_resource_test.go"}},
+		"exported change":   {[]provider.LineRange{{Start: 3, End: 3}}, []string{"internal/services/dns/dns_a_record_resource_test.go"}},
+		// changes outside any declaration can affect all of them
+		"import only": {[]provider.LineRange{{Start: 1, End: 1}}, []string{"internal/services/dns/dns_a_record_resource_test.go", "internal/services/dns/dns_cname_record_resource_test.go"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			pf := helper
+			pf.ChangedLines = tt.lines
+			dc.TraceHelperFiles([]provider.File{pf})
+
+			var got []string
+			for _, f := range dc.SortedTestFiles() {
+				got = append(got, f.RelPath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("traced test files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportersOf(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"internal/tf/validation/name.go":                        "package validation\n",
+		"internal/services/dns/dns_zone_resource.go":            "package dns\n\nimport \"example.com/provider/internal/tf/validation\"\n",
+		"internal/services/dns/dns_zone_resource_test.go":       "package dns\n\nimport \"example.com/provider/internal/tf/validation\"\n",
+		"internal/services/dns/parse/zone.go":                   "package parse\n\nimport \"example.com/provider/internal/tf/validation\"\n",
+		"internal/services/cosmos/cosmosdb_account_resource.go": "package cosmos\n\nimport v \"example.com/provider/internal/tf/validation\"\n",
+		"internal/services/cosmos/cosmosdb_account_notes.txt":   "import \"example.com/provider/internal/tf/validation\"\n",
+	})
+
+	const pkg = "example.com/provider/internal/tf/validation"
+	tests := map[string]struct {
+		serviceDir string
+		want       []string
+	}{
+		"every service": {"", []string{
+			"internal/services/cosmos/cosmosdb_account_resource.go",
+			"internal/services/dns/dns_zone_resource.go",
+			"internal/services/dns/parse/zone.go",
+		}},
+		"one service":  {"internal/services/dns", []string{"internal/services/dns/dns_zone_resource.go", "internal/services/dns/parse/zone.go"}},
+		"no importers": {"internal/services/postgres", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			if got := slices.Sorted(slices.Values(dc.importersOf(tt.serviceDir, pkg))); !slices.Equal(got, tt.want) {
+				t.Errorf("importersOf(%q) = %v, want %v", tt.serviceDir, got, tt.want)
+			}
+			if _, ok := dc.importIndex[tt.serviceDir]; !ok {
+				t.Errorf("importersOf(%q) didn't keep its index", tt.serviceDir)
+			}
+		})
+	}
+}
+
+func TestCapSharedTrace(t *testing.T) {
+	t.Parallel()
+
+	shared := provider.Chain{{Kind: provider.LinkChangedFile, Value: "internal/tf/validation/name.go"}}
+	local := provider.Chain{{Kind: provider.LinkChangedFile, Value: "internal/services/dns/parse/zone.go"}}
+	resourceChains := func() map[string][]provider.Chain {
+		return map[string][]provider.Chain{
+			"internal/services/dns/dns_zone_resource.go":            {shared.Then(provider.LinkResourceFile, "internal/services/dns/dns_zone_resource.go")},
+			"internal/services/dns/dns_a_record_resource.go":        {shared, local},
+			"internal/services/cosmos/cosmosdb_account_resource.go": {shared},
+		}
+	}
+	tracedDirs := func(chains map[string][]provider.Chain) map[string][]string {
+		dirs := map[string][]string{}
+		for relPath := range chains {
+			dir := path.Dir(relPath)
+			dirs[dir] = append(dirs[dir], relPath)
+		}
+		return dirs
+	}
+
+	tests := map[string]struct {
+		max   int
+		smoke map[string][]string
+		want  []string
+		tests map[string][]string
+	}{
+		"under the limit": {
+			max:   3,
+			smoke: map[string][]string{"dns": {"TestAccDnsZone_basic"}},
+			want: []string{
+				"internal/services/cosmos/cosmosdb_account_resource.go",
+				"internal/services/dns/dns_a_record_resource.go",
+				"internal/services/dns/dns_zone_resource.go",
+			},
+			tests: map[string][]string{},
+		},
+		"no limit": {
+			max: 0,
+			want: []string{
+				"internal/services/cosmos/cosmosdb_account_resource.go",
+				"internal/services/dns/dns_a_record_resource.go",
+				"internal/services/dns/dns_zone_resource.go",
+			},
+			tests: map[string][]string{},
+		},
+		// the record keeps the chain of its own service's helper, cosmos has no smoke-tests so keeps its traced tests
+		"over the limit": {
+			max:   2,
+			smoke: map[string][]string{"dns": {"TestAccDnsZone_basic"}},
+			want: []string{
+				"internal/services/cosmos/cosmosdb_account_resource.go",
+				"internal/services/dns/dns_a_record_resource.go",
+			},
+			tests: map[string][]string{"dns": {"TestAccDnsZone_basic"}},
+		},
+		"every service with smoke-tests": {
+			max:   2,
+			smoke: map[string][]string{"dns": {"TestAccDnsZone_basic"}, "cosmos": {"TestAccCosmosDBAccount_basic"}},
+			want:  []string{"internal/services/dns/dns_a_record_resource.go"},
+			tests: map[string][]string{"cosmos": {"TestAccCosmosDBAccount_basic"}, "dns": {"TestAccDnsZone_basic"}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(t.TempDir())
+			dc.Config.MaxTracedResources = tt.max
+			dc.Config.SmokeTests = tt.smoke
+			chains := resourceChains()
+			remaining := dc.capSharedTrace(tracedDirs(chains), chains)
+
+			var got []string
+			for _, files := range remaining {
+				got = append(got, files...)
+			}
+			if slices.Sort(got); !slices.Equal(got, tt.want) {
+				t.Errorf("remaining resource files = %v, want %v", got, tt.want)
+			}
+			if !maps.EqualFunc(dc.SmokeTests, tt.tests, slices.Equal) {
+				t.Errorf("smoke-tests = %v, want %v", dc.SmokeTests, tt.tests)
+			}
+			if tt.max == 2 && slices.ContainsFunc(chains["internal/services/dns/dns_a_record_resource.go"], func(c provider.Chain) bool { return c[0].Value == shared[0].Value }) {
+				t.Errorf("the record kept its shared chain: %v", chains["internal/services/dns/dns_a_record_resource.go"])
+			}
+		})
+	}
+}
+
+func TestTraceHelperFilesTestImporters(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"internal/acceptance/data.go": `package acceptance
+
+func BuildTestData() {}
+
+func PreCheck() {}
+`,
+		"internal/services/dns/dns_zone_resource.go":                 "package dns\n",
+		"internal/services/dns/dns_zone_resource_test.go":            "package dns_test\n\nimport \"example.com/provider/internal/acceptance\"\n\nvar _ = acceptance.BuildTestData\n",
+		"internal/services/dns/dns_a_record_resource.go":             "package dns\n",
+		"internal/services/dns/dns_a_record_resource_test.go":        "package dns_test\n\nimport \"example.com/provider/internal/acceptance\"\n\nvar _ = acceptance.PreCheck\n",
+		"internal/services/cosmos/cosmosdb_account_resource_test.go": "package cosmos_test\n",
+	})
+
+	helper := provider.NewFileWithPath("internal/acceptance/data.go", repo)
+	tests := map[string]struct {
+		lines []provider.LineRange
+		want  []string
+	}{
+		"whole file":     {nil, []string{"internal/services/dns/dns_a_record_resource_test.go", "internal/services/dns/dns_zone_resource_test.go"}},
+		"changed symbol": {[]provider.LineRange{{Start: 3, End: 3}}, []string{"internal/services/dns/dns_zone_resource_test.go"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			pf := helper
+			pf.ChangedLines = tt.lines
+			dc.TraceHelperFiles([]provider.File{pf})
+
+			var got []string
+			for _, f := range dc.SortedTestFiles() {
+				got = append(got, f.RelPath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("traced test files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PrDirectives are test selection directives from a PR description, either as lines:
+//
+//	tctest: TestAccFoo_|TestAccBar_      run only these tests instead of the discovered ones
+//	tctest-add: network:TestAccExtra_    run these in addition to the discovered tests
+//	tctest-skip: TestAccSlow             don't run these discovered tests
+//	tctest-services: network,dns         only build these services
+//
+// or as a fenced ```tctest block holding test names (the override) and add:, skip: and services: lines.
+// Tests may be qualified with a service (service:TestName), unqualified tests apply to every service.
+type PrDirectives struct {
+	Override []string
+	Add      []string
+	Skip     []string
+	Services []string
+}
+
+// test sources shown in the discovery output
+const (
+	testSourceDiscovered = "discovered"
+	testSourcePrBody     = "pr body"
+)
+
+var (
+	directiveLineRe  = regexp.MustCompile(`(?i)^\s*tctest(-add|-skip|-services)?\s*:\s*(.*)$`)
+	directiveBlockRe = regexp.MustCompile("(?ms)^\\s*```tctest\\s*$(.*?)^\\s*```")
+	fencedBlockRe    = regexp.MustCompile("(?ms)^\\s*```.*?^\\s*```")
+	directiveSplitRe = regexp.MustCompile(`[\s,|]+`)
+
+	// directive tests end up in the -run regex, so only plain acceptance test names are allowed
+	directiveTestRe    = regexp.MustCompile(`^TestAcc\w*$`)
+	directiveServiceRe = regexp.MustCompile(`^[\w-]+$`)
+)
+
+// ParsePrDirectives parses the tctest directives out of a PR body, it returns nil if there are none and an error
+// if a test or service name isn't valid.
+func ParsePrDirectives(body string) (*PrDirectives, error) {
+	var d PrDirectives
+	found := false
+	var errs []error
+
+	add := func(kind, value string) {
+		found = true
+		values := splitDirectiveValues(value)
+		kind = strings.ToLower(kind)
+		for _, v := range values {
+			if err := validateDirectiveValue(kind, v); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		switch kind {
+		case "", "tests":
+			d.Override = append(d.Override, values...)
+		case "add":
+			d.Add = append(d.Add, values...)
+		case "skip":
+			d.Skip = append(d.Skip, values...)
+		case "services":
+			d.Services = append(d.Services, values...)
+		}
+	}
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	for _, m := range directiveBlockRe.FindAllStringSubmatch(body, -1) {
+		for line := range strings.SplitSeq(m[1], "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			kind, value, ok := strings.Cut(line, ":")
+			if !ok || !slices.Contains([]string{"tests", "add", "skip", "services"}, strings.ToLower(strings.TrimSpace(kind))) {
+				kind, value = "", line // service qualified test names contain ':' too
+			}
+			add(strings.TrimSpace(kind), value)
+		}
+	}
+	// directive lines in other code blocks (such as example config) are not directives
+	body = fencedBlockRe.ReplaceAllString(body, "")
+
+	for line := range strings.SplitSeq(body, "\n") {
+		if m := directiveLineRe.FindStringSubmatch(line); m != nil {
+			add(strings.TrimPrefix(m[1], "-"), m[2])
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid PR description directives: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// validateDirectiveValue checks a directive value is a service name or an optionally service qualified test name.
+func validateDirectiveValue(kind, v string) error {
+	if kind == "services" {
+		if !directiveServiceRe.MatchString(v) {
+			return fmt.Errorf("%q is not a valid service name", v)
+		}
+		return nil
+	}
+
+	test := v
+	if service, t, ok := strings.Cut(v, ":"); ok {
+		if !directiveServiceRe.MatchString(service) {
+			return fmt.Errorf("%q is not a valid service name", service)
+		}
+		test = t
+	}
+	if !directiveTestRe.MatchString(test) {
+		return fmt.Errorf("%q is not an acceptance test name (TestAcc...)", test)
+	}
+	return nil
+}
+
+func splitDirectiveValues(s string) []string {
+	return slices.DeleteFunc(directiveSplitRe.Split(s, -1), func(v string) bool { return v == "" })
+}
+
+// Apply overrides, extends and prunes the discovered tests, returning the tests per service and where each came
+// from. split is the test name split character, so skipping TestAccSlow also skips TestAccSlow_.
+func (d PrDirectives) Apply(discovered map[string][]string, split string) (map[string][]string, map[string]map[string]string) {
+	sources := map[string]map[string]string{}
+	tests := map[string][]string{}
+	set := func(service, test, source string) {
+		if sources[service] == nil {
+			sources[service] = map[string]string{}
+		}
+		if _, ok := sources[service][test]; !ok {
+			tests[service] = append(tests[service], test)
+		}
+		sources[service][test] = source
+	}
+
+	// the services in scope: tctest-services when given, otherwise the discovered ones
+	services := d.Services
+	if len(services) == 0 {
+		services = slices.Sorted(maps.Keys(discovered))
+	}
+
+	addAll := func(entries []string) {
+		for _, e := range entries {
+			service, test, ok := strings.Cut(e, ":")
+			if ok {
+				set(service, test, testSourcePrBody)
+				continue
+			}
+
+			targets := services
+			if len(targets) == 0 {
+				targets = []string{""} // nothing discovered, run on the build type without a service
+			}
+			for _, s := range targets {
+				set(s, e, testSourcePrBody)
+			}
+		}
+	}
+
+	if len(d.Override) > 0 {
+		addAll(d.Override)
+	} else {
+		for _, s := range services {
+			for _, t := range discovered[s] {
+				set(s, t, testSourceDiscovered)
+			}
+		}
+	}
+	addAll(d.Add)
+
+	trim := func(t string) string { return strings.TrimSuffix(t, split) }
+	for s := range tests {
+		tests[s] = slices.DeleteFunc(tests[s], func(t string) bool {
+			skip := slices.ContainsFunc(d.Skip, func(e string) bool {
+				service, test, ok := strings.Cut(e, ":")
+				if !ok {
+					return trim(e) == trim(t)
+				}
+				return service == s && trim(test) == trim(t)
+			})
+			if skip {
+				delete(sources[s], t)
+			}
+			return skip
+		})
+		if len(tests[s]) == 0 {
+			delete(tests, s)
+		}
+	}
+
+	// an explicit service list also prunes qualified tests for other services
+	if len(d.Services) > 0 {
+		for s := range tests {
+			if !slices.Contains(d.Services, s) {
+				delete(tests, s)
+			}
+		}
+	}
+
+	for s := range tests {
+		slices.Sort(tests[s])
+	}
+
+	return tests, sources
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePrDirectives(t *testing.T) {
+	t.Parallel()
+
+	if d, err := ParsePrDirectives("fixes a bug in the network resource\n\nno tests needed"); d != nil || err != nil {
+		t.Errorf("ParsePrDirectives without directives = %+v, %v, want nil", d, err)
+	}
+
+	body := "Adds `zone`.\r\n\r\ntctest: TestAccFoo_|TestAccBar_\r\nTCTEST-SKIP: TestAccSlow\r\ntctest-services: network, dns\r\n" +
+		"```tctest\nTestAccBlock_\nadd: dns:TestAccDnsExtra_\n# comment\n```\n" +
+		"```hcl\ntctest: not-a-directive-in-another-block\n```\n"
+
+	got, err := ParsePrDirectives(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &PrDirectives{
+		Override: []string{"TestAccBlock_", "TestAccFoo_", "TestAccBar_"},
+		Add:      []string{"dns:TestAccDnsExtra_"},
+		Skip:     []string{"TestAccSlow"},
+		Services: []string{"network", "dns"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePrDirectives = %+v, want %+v", got, want)
+	}
+
+	invalid := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{name: "regex in an override", body: "tctest: TestAccFoo_.*|.*", err: `"TestAccFoo_.*" is not an acceptance test name`},
+		{name: "not an acceptance test", body: "tctest-add: TestFoo", err: `"TestFoo" is not an acceptance test name`},
+		{name: "regex in a qualified test", body: "```tctest\nskip: dns:(TestAccA|TestAccB)\n```", err: `"(TestAccA" is not an acceptance test name`},
+		{name: "invalid qualifying service", body: "tctest: dns/zone:TestAccDns_", err: `"dns/zone" is not a valid service name`},
+		{name: "invalid service", body: "tctest-services: network,$(id)", err: `"$(id)" is not a valid service name`},
+	}
+	for _, tt := range invalid {
+		d, err := ParsePrDirectives(tt.body)
+		if err == nil || !strings.Contains(err.Error(), tt.err) || d != nil {
+			t.Errorf("%s: ParsePrDirectives = %+v, %v, want error %q", tt.name, d, err, tt.err)
+		}
+	}
+}
+
+func TestPrDirectivesApply(t *testing.T) {
+	t.Parallel()
+
+	discovered := map[string][]string{
+		"network": {"TestAccSlow_", "TestAccVirtualNetwork_"},
+		"dns":     {"TestAccDnsZone_"},
+		"compute": {"TestAccVM_"},
+	}
+
+	cases := []struct {
+		name string
+		d    PrDirectives
+		want map[string][]string
+	}{
+		{
+			name: "override replaces discovered tests in every discovered service",
+			d:    PrDirectives{Override: []string{"TestAccFoo_"}},
+			want: map[string][]string{"network": {"TestAccFoo_"}, "dns": {"TestAccFoo_"}, "compute": {"TestAccFoo_"}},
+		},
+		{
+			name: "add extends, qualified tests only go to their service",
+			d:    PrDirectives{Add: []string{"dns:TestAccExtra_"}},
+			want: map[string][]string{"network": {"TestAccSlow_", "TestAccVirtualNetwork_"}, "dns": {"TestAccDnsZone_", "TestAccExtra_"}, "compute": {"TestAccVM_"}},
+		},
+		{
+			name: "skip prunes with or without the split character, empty services are dropped",
+			d:    PrDirectives{Skip: []string{"TestAccSlow", "compute:TestAccVM_"}},
+			want: map[string][]string{"network": {"TestAccVirtualNetwork_"}, "dns": {"TestAccDnsZone_"}},
+		},
+		{
+			name: "services limits the services built",
+			d:    PrDirectives{Services: []string{"dns", "mssql"}, Add: []string{"TestAccExtra_"}},
+			want: map[string][]string{"dns": {"TestAccDnsZone_", "TestAccExtra_"}, "mssql": {"TestAccExtra_"}},
+		},
+	}
+
+	for _, tt := range cases {
+		got, sources := tt.d.Apply(discovered, "_")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Apply = %v, want %v", tt.name, got, tt.want)
+		}
+		for s, tests := range got {
+			for _, test := range tests {
+				if sources[s][test] == "" {
+					t.Errorf("%s: no source for %s/%s", tt.name, s, test)
+				}
+			}
+		}
+	}
+
+	_, sources := PrDirectives{Add: []string{"TestAccExtra_"}}.Apply(discovered, "_")
+	if sources["dns"]["TestAccDnsZone_"] != testSourceDiscovered || sources["dns"]["TestAccExtra_"] != testSourcePrBody {
+		t.Errorf("unexpected sources %v", sources["dns"])
+	}
+}
@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/git"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// moduleBumps returns the modules a PR's change to go.mod, go.sum or vendor/modules.txt bumps. When GitHub omits the
+// patch of a large diff it comes from a git diff of the merge commit against its base.
+func (dc *AstDiscoveryContext) moduleBumps(relPath string, f *github.CommitFile) []provider.ModuleBump {
+	patch := f.GetPatch()
+	if patch == "" {
+		diff, err := git.DiffFromFirstParent(dc.RepoPath, relPath)
+		if err != nil {
+			clog.Log.Debugf("    no diff for %s, skipping its module versions: %v", relPath, err)
+			return nil
+		}
+		patch = diff
+	}
+	return provider.ParseModuleBumps(relPath, patch)
+}
+
+// mergeModuleBumps merges the bumps found in several module files, keeping the first versions seen of each module.
+// go.sum also lists versions only read for the module graph, so its bumps just confirm those of go.mod or
+// vendor/modules.txt.
+func mergeModuleBumps(bumps []provider.ModuleBump) []provider.ModuleBump {
+	byPath := map[string]*provider.ModuleBump{}
+	for _, b := range bumps {
+		if slices.Contains(b.Files, "go.sum") {
+			continue
+		}
+		existing, ok := byPath[b.Path]
+		if !ok {
+			byPath[b.Path] = &b
+			continue
+		}
+		if existing.From == "" {
+			existing.From = b.From
+		}
+		existing.Files = slices.Compact(slices.Sorted(slices.Values(append(existing.Files, b.Files...))))
+	}
+	for _, b := range bumps {
+		if existing, ok := byPath[b.Path]; ok && slices.Contains(b.Files, "go.sum") {
+			existing.Files = slices.Compact(slices.Sorted(slices.Values(append(existing.Files, b.Files...))))
+		}
+	}
+
+	merged := make([]provider.ModuleBump, 0, len(byPath))
+	for _, p := range slices.Sorted(maps.Keys(byPath)) {
+		merged = append(merged, *byPath[p])
+	}
+	return merged
+}
+
+// TraceModuleBumps selects the tests of the resource files importing a package of a bumped module, directly or
+// through a package of their service that does, so a dependency bump is tested without its vendored files being in
+// the diff, or in a repo without vendoring.
+func (dc *AstDiscoveryContext) TraceModuleBumps(bumps []provider.ModuleBump) {
+	if len(bumps) == 0 || dc.Config.LocalTraceDepth == 0 {
+		return
+	}
+
+	// an import belongs to the innermost module containing it, e.g. go-azure-sdk/resource-manager not go-azure-sdk
+	modules, err := provider.RequiredModules(dc.RepoPath)
+	if err != nil {
+		clog.Log.Debugf("    %v", err)
+	}
+	modules = append(modules, dc.ModulePath)
+	for _, b := range bumps {
+		modules = append(modules, b.Path)
+	}
+	moduleOf := func(pkgPath string) string {
+		module := ""
+		for _, m := range modules {
+			if (pkgPath == m || strings.HasPrefix(pkgPath, m+"/")) && len(m) > len(module) {
+				module = m
+			}
+		}
+		return module
+	}
+
+	dc.importersOf("", "") // builds the index of every service's imports
+	index := dc.importIndex[""]
+
+	moduleResources := map[string][]string{}
+	for _, b := range bumps {
+		chain := provider.Chain{{Kind: provider.LinkChangedFile, Value: strings.Join(b.Files, ", ")}}.Then(provider.LinkModule, b.String())
+
+		traced := map[string][]provider.Chain{}
+		for _, pkgPath := range slices.Sorted(maps.Keys(index)) {
+			if moduleOf(pkgPath) != b.Path {
+				continue
+			}
+			pkgChain := chain.Then(provider.LinkPackage, pkgPath)
+			dc.Explored = append(dc.Explored, pkgChain)
+
+			for _, relPath := range index[pkgPath] {
+				if dc.Config.FileRegEx.MatchString(relPath) {
+					traced[relPath] = append(traced[relPath], pkgChain.Then(provider.LinkResourceFile, relPath))
+					continue
+				}
+
+				// a service package importing the module, e.g. its client, reaches the resources importing it
+				serviceDir := serviceDirOf(relPath)
+				if serviceDir == "" {
+					continue
+				}
+				helperDir := path.Dir(relPath)
+				helperChain := pkgChain.Then(provider.LinkPackage, helperDir)
+				for _, r := range dc.importersOf(serviceDir, dc.ModulePath+"/"+helperDir) {
+					if dc.Config.FileRegEx.MatchString(r) {
+						traced[r] = append(traced[r], helperChain.Then(provider.LinkResourceFile, r))
+					}
+				}
+			}
+		}
+
+		for _, relPath := range slices.Sorted(maps.Keys(traced)) {
+			moduleResources[b.Path] = append(moduleResources[b.Path], relPath)
+			dc.Explored = append(dc.Explored, traced[relPath]...)
+
+			tpf := provider.NewFileWithPath(relPath, dc.RepoPath)
+			discovered, err := dc.findLocalTestFiles(path.Dir(relPath), []string{tpf.ResourcePrefix()})
+			if err != nil {
+				clog.Log.Debugf("  failed to find test files in %s: %v", path.Dir(relPath), err)
+				continue
+			}
+			for _, pf := range discovered {
+				dc.AddTestFile(pf, "MODULE", traced[relPath]...)
+			}
+		}
+	}
+
+	resourceCount := 0
+	for _, resources := range moduleResources {
+		resourceCount += len(resources)
+	}
+	if cout.Level >= cout.VerbosityVerbose {
+		cout.Printf("  tracing imports of <yellow>%d</> bumped module(s)...\n", len(bumps))
+	} else {
+		cout.Printf("  tracing imports of <yellow>%d</> bumped module(s)... <cyan>%d</> resource file(s)\n", len(bumps), resourceCount)
+	}
+	for _, b := range bumps {
+		if len(moduleResources[b.Path]) == 0 {
+			cout.Verbosef("    <fg=177>%s</> → <darkGray>no resource files import it</>\n", b)
+			continue
+		}
+		cout.Verbosef("    <fg=177>%s</> →\n", b)
+		for _, relPath := range moduleResources[b.Path] {
+			tpf := provider.NewFileWithPath(relPath, dc.RepoPath)
+			cout.Verbosef("      %s\n", tpf.ColouredFileName())
+		}
+	}
+}
+
+// chainModule returns the bumped module a chain starts from, or "" when it doesn't.
+func chainModule(c provider.Chain) string {
+	for _, l := range c {
+		if l.Kind == provider.LinkModule {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func TestMergeModuleBumps(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		bumps []provider.ModuleBump
+		want  []provider.ModuleBump
+	}{
+		"go.mod and vendor/modules.txt": {
+			bumps: []provider.ModuleBump{
+				{Path: "example.com/sdk", To: "v1.2.0", Files: []string{"vendor/modules.txt"}},
+				{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}},
+			},
+			want: []provider.ModuleBump{{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod", "vendor/modules.txt"}}},
+		},
+		"confirmed by go.sum": {
+			bumps: []provider.ModuleBump{
+				{Path: "example.com/sdk", From: "v1.1.0", To: "v1.2.0", Files: []string{"go.sum"}},
+				{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}},
+			},
+			want: []provider.ModuleBump{{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod", "go.sum"}}},
+		},
+		"go.sum only": {
+			bumps: []provider.ModuleBump{
+				{Path: "example.com/graph", To: "v0.3.0", Files: []string{"go.sum"}},
+				{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}},
+			},
+			want: []provider.ModuleBump{{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}}},
+		},
+		"none": {
+			bumps: nil,
+			want:  []provider.ModuleBump{},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := mergeModuleBumps(tt.bumps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeModuleBumps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceModuleBumps(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"go.mod": `module example.com/provider
+
+require (
+	example.com/sdk v1.0.0
+	example.com/sdk/resource-manager v0.1.0
+)
+`,
+		"internal/services/dns/client/client.go":                     "package client\n\nimport \"example.com/sdk/client\"\n",
+		"internal/services/dns/dns_a_record_resource.go":             "package dns\n\nimport \"example.com/provider/internal/services/dns/client\"\n",
+		"internal/services/dns/dns_zone_resource.go":                 "package dns\n\nimport \"example.com/sdk/resource-manager/dns/zones\"\n",
+		"internal/services/cosmos/cosmosdb_account_resource.go":      "package cosmos\n",
+		"internal/services/dns/dns_a_record_resource_test.go":        "package dns_test\n",
+		"internal/services/dns/dns_zone_resource_test.go":            "package dns_test\n",
+		"internal/services/cosmos/cosmosdb_account_resource_test.go": "package cosmos_test\n",
+	})
+
+	tests := map[string]struct {
+		bump  provider.ModuleBump
+		depth int
+		want  []string
+		chain string
+	}{
+		// resource-manager is its own module, its importers aren't the sdk's
+		"through a service package": {
+			bump:  provider.ModuleBump{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}},
+			depth: 10,
+			want:  []string{"internal/services/dns/dns_a_record_resource_test.go"},
+			chain: "go.mod [changed file] → example.com/sdk v1.0.0 → v1.2.0 [module] → example.com/sdk/client [package] → " +
+				"internal/services/dns/client [package] → internal/services/dns/dns_a_record_resource.go [resource file]",
+		},
+		"innermost module": {
+			bump:  provider.ModuleBump{Path: "example.com/sdk/resource-manager", From: "v0.1.0", To: "v0.2.0", Files: []string{"go.mod"}},
+			depth: 10,
+			want:  []string{"internal/services/dns/dns_zone_resource_test.go"},
+		},
+		"not imported": {
+			bump:  provider.ModuleBump{Path: "example.com/other", To: "v0.1.0", Files: []string{"go.mod"}},
+			depth: 10,
+		},
+		"tracing off": {
+			bump: provider.ModuleBump{Path: "example.com/sdk", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.mod"}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			dc.Config.LocalTraceDepth = tt.depth
+			dc.TraceModuleBumps([]provider.ModuleBump{tt.bump})
+
+			var got []string
+			for _, f := range dc.SortedTestFiles() {
+				got = append(got, f.RelPath)
+				if !slices.Equal(f.DiscoveredBy, []string{"MODULE"}) {
+					t.Errorf("%s discovered by %v, want MODULE", f.RelPath, f.DiscoveredBy)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("traced test files = %v, want %v", got, tt.want)
+			}
+			if tt.chain != "" && !slices.ContainsFunc(dc.Explored, func(c provider.Chain) bool { return c.String() == tt.chain }) {
+				t.Errorf("chains = %v, want %s", dc.Explored, tt.chain)
+			}
+		})
+	}
+}
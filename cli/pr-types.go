@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"go/types"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// TraceHelperFilesTypes is TraceHelperFiles for --mode types: rather than matching identifiers by name it type-checks
+// the service packages of the changed helpers with go/types and follows the real uses of the objects they changed,
+// methods called through embedded fields included, declaration by declaration to the resource files using them.
+// Helpers in a package shared by the services are traced by name with TraceHelperFiles, as type-checking every
+// service isn't feasible, so --max-traced-resources caps them as in ast mode.
+func (dc *AstDiscoveryContext) TraceHelperFilesTypes(helperFiles []provider.File) {
+	if len(helperFiles) == 0 || dc.Config.LocalTraceDepth == 0 {
+		return
+	}
+
+	var shared []provider.File
+	helperFiles = slices.DeleteFunc(slices.Clone(helperFiles), func(pf provider.File) bool {
+		if serviceDirOf(pf.RelPath) == "" && pf.InSharedPackage() {
+			shared = append(shared, pf)
+			return true
+		}
+		return false
+	})
+	dc.TraceHelperFiles(shared)
+	if len(helperFiles) == 0 {
+		return
+	}
+
+	serviceDirs := map[string]bool{}
+	for _, pf := range helperFiles {
+		serviceDir := serviceDirOf(pf.RelPath)
+		if serviceDir == "" {
+			clog.Log.Debugf("    skipping %s: not in a service directory", pf.RelPath)
+			continue
+		}
+		serviceDirs[serviceDir] = true
+	}
+
+	tc := provider.NewTypeChecker(dc.RepoPath, dc.ModulePath, func(pkgPath string) bool {
+		for dir := range serviceDirs {
+			if pkgPath == dc.ModulePath+"/"+dir || strings.HasPrefix(pkgPath, dc.ModulePath+"/"+dir+"/") {
+				return true
+			}
+		}
+		return false
+	})
+
+	var decls []provider.TypedDecl
+	for _, dir := range slices.Sorted(maps.Keys(serviceDirs)) {
+		for _, pkgDir := range goPackageDirs(filepath.Join(dc.RepoPath, dir)) {
+			rel, err := filepath.Rel(dc.RepoPath, pkgDir)
+			if err != nil {
+				continue
+			}
+			p, err := tc.Load(dc.ModulePath + "/" + filepath.ToSlash(rel))
+			if err != nil {
+				clog.Log.Debugf("    type-checking %s: %v", rel, err)
+				continue
+			}
+			decls = append(decls, tc.Decls(p)...)
+		}
+	}
+
+	// the chain that reached each affected object, starting at the helpers
+	chains := map[types.Object]provider.Chain{}
+	for _, pf := range helperFiles {
+		if serviceDirOf(pf.RelPath) == "" {
+			continue
+		}
+		objects := tc.ChangedObjects(pf)
+		if len(objects) == 0 {
+			cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>%s</>\n", pf.Dir, pf.Name, noSymbolsReason(pf, false))
+			continue
+		}
+		for _, obj := range objects {
+			if _, ok := chains[obj]; !ok {
+				chains[obj] = provider.Chain{{Kind: provider.LinkChangedFile, Value: pf.RelPath}}
+			}
+		}
+		clog.Log.Debugf("    %s changed %v", pf.RelPath, objectNames(objects))
+	}
+
+	resourceChains, pkgChains := dc.traceTypedUses(decls, chains)
+
+	for _, relPath := range slices.Sorted(maps.Keys(resourceChains)) {
+		tpf := provider.NewFileWithPath(relPath, dc.RepoPath)
+		discovered, err := dc.findLocalTestFiles(path.Dir(relPath), []string{tpf.ResourcePrefix()})
+		if err != nil {
+			clog.Log.Debugf("  failed to find test files in %s: %v", path.Dir(relPath), err)
+			continue
+		}
+		for _, pf := range discovered {
+			dc.AddTestFile(pf, "TRACED", resourceChains[relPath]...)
+		}
+	}
+
+	for _, dir := range slices.Sorted(maps.Keys(pkgChains)) {
+		dc.Explored = append(dc.Explored, pkgChains[dir])
+	}
+	for _, relPath := range slices.Sorted(maps.Keys(resourceChains)) {
+		dc.Explored = append(dc.Explored, resourceChains[relPath]...)
+	}
+
+	if cout.Level >= cout.VerbosityVerbose {
+		cout.Printf("  tracing symbols from <yellow>%d</> helper file(s) with go/types...\n", len(helperFiles))
+	} else {
+		cout.Printf("  tracing symbols from <yellow>%d</> helper file(s) with go/types... <cyan>%d</> resource file(s)\n", len(helperFiles), len(resourceChains))
+	}
+
+	for _, pf := range helperFiles {
+		var traced []string
+		for _, relPath := range slices.Sorted(maps.Keys(resourceChains)) {
+			if slices.ContainsFunc(resourceChains[relPath], func(c provider.Chain) bool { return c[0].Value == pf.RelPath }) {
+				traced = append(traced, relPath)
+			}
+		}
+		if len(traced) == 0 {
+			cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> → <darkGray>no resource files traced</>\n", pf.Dir, pf.Name)
+			continue
+		}
+		cout.Verbosef("    <darkGray>%s</><white;op=bold>%s</> →\n", pf.Dir, pf.Name)
+		for _, t := range traced {
+			tpf := provider.NewFileWithPath(t, dc.RepoPath)
+			cout.Verbosef("      %s\n", tpf.ColouredFileName())
+		}
+	}
+}
+
+// traceTypedUses follows the affected objects breadth first: each round a declaration using an object affected in an
+// earlier round affects the objects it declares, until no new declaration is reached. Objects declared in the same
+// package as the object they use share its chain, crossing into another package adds a package link and counts
+// towards the trace depth. It returns the chains reaching each resource file and each package crossed into.
+func (dc *AstDiscoveryContext) traceTypedUses(decls []provider.TypedDecl, chains map[types.Object]provider.Chain) (map[string][]provider.Chain, map[string]provider.Chain) {
+	resourceChains := map[string][]provider.Chain{}
+	pkgChains := map[string]provider.Chain{}
+	done := make([]bool, len(decls))
+
+	for {
+		next := map[types.Object]provider.Chain{}
+		for i, d := range decls {
+			if done[i] {
+				continue
+			}
+
+			// the used objects grouped by the chain that reached them
+			var groups []provider.Chain
+			var groupUses [][]types.Object
+			for _, obj := range d.Uses {
+				c, ok := chains[obj]
+				if !ok {
+					continue
+				}
+				g := slices.IndexFunc(groups, func(e provider.Chain) bool { return e.String() == c.String() })
+				if g < 0 {
+					groups = append(groups, c)
+					groupUses = append(groupUses, nil)
+					g = len(groups) - 1
+				}
+				groupUses[g] = append(groupUses[g], obj)
+			}
+			if len(groups) == 0 {
+				continue
+			}
+			done[i] = true
+
+			if dc.Config.FileRegEx.MatchString(d.File) {
+				for g, c := range groups {
+					rc := c.Then(provider.LinkSymbol, joinSymbols(objectNames(groupUses[g]))).Then(provider.LinkResourceFile, d.File)
+					if !slices.ContainsFunc(resourceChains[d.File], func(e provider.Chain) bool { return e.String() == rc.String() }) {
+						resourceChains[d.File] = append(resourceChains[d.File], rc)
+					}
+				}
+				clog.Log.Debugf("    traced: %s uses %v", d.File, objectNames(d.Uses))
+			}
+
+			derived := groups[0]
+			if used := groupUses[0][0]; used.Pkg() != d.Package.Types {
+				if packageLinks(derived) >= dc.Config.LocalTraceDepth-1 {
+					continue
+				}
+				derived = derived.Then(provider.LinkSymbol, joinSymbols(objectNames(groupUses[0]))).Then(provider.LinkPackage, d.Package.Dir)
+				if _, ok := pkgChains[d.Package.Dir]; !ok {
+					pkgChains[d.Package.Dir] = derived
+				}
+			}
+			for _, obj := range d.Defines {
+				if _, ok := chains[obj]; !ok {
+					next[obj] = derived
+				}
+			}
+		}
+
+		if len(next) == 0 {
+			return resourceChains, pkgChains
+		}
+		maps.Copy(chains, next)
+	}
+}
+
+// goPackageDirs returns the directories under root holding go files, skipping testdata
+func goPackageDirs(root string) []string {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil //nolint:nilerr // skip unreadable entries and keep walking
+		}
+		if d.Name() == "testdata" {
+			return filepath.SkipDir
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil //nolint:nilerr // skip unreadable directories
+		}
+		if slices.ContainsFunc(entries, func(e os.DirEntry) bool { return !e.IsDir() && strings.HasSuffix(e.Name(), ".go") }) {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	return dirs
+}
+
+func packageLinks(c provider.Chain) int {
+	n := 0
+	for _, l := range c {
+		if l.Kind == provider.LinkPackage {
+			n++
+		}
+	}
+	return n
+}
+
+func objectNames(objects []types.Object) []string {
+	names := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		names = append(names, provider.ObjectName(obj))
+	}
+	return names
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func TestTraceHelperFilesTypes(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"internal/services/dns/parse/id.go": `package parse
+
+import "fmt"
+
+type Base struct{ Name string }
+
+func (b Base) ID() string { return fmt.Sprintf("/zones/%s", b.Name) }
+
+func ZoneID(name string) string { return Base{Name: name}.ID() }
+`,
+		"internal/services/dns/validate/id.go": `package validate
+
+import "example.com/provider/internal/services/dns/parse"
+
+func ZoneID(v string) bool { return parse.ZoneID(v) != "" }
+`,
+		"internal/services/dns/client.go": `package dns
+
+import "example.com/provider/internal/services/dns/parse"
+
+type recordID struct{ parse.Base }
+
+func client() string { return "" }
+`,
+		"internal/services/dns/dns_a_record_resource.go": `package dns
+
+func aRecordID() string {
+	id := recordID{}
+	return id.ID()
+}
+`,
+		"internal/services/dns/dns_zone_resource.go": `package dns
+
+import "example.com/provider/internal/services/dns/validate"
+
+var _ = validate.ZoneID
+`,
+		"internal/services/dns/dns_cname_record_resource.go": `package dns
+
+func cnameClient() string {
+	client := "local"
+	return client
+}
+`,
+		"internal/services/dns/dns_a_record_resource_test.go":     "package dns_test\n",
+		"internal/services/dns/dns_zone_resource_test.go":         "package dns_test\n",
+		"internal/services/dns/dns_cname_record_resource_test.go": "package dns_test\n",
+	})
+
+	tests := map[string]struct {
+		helper string
+		lines  []provider.LineRange
+		want   []string
+		chain  string
+	}{
+		// only reached through the promoted method of the embedded field
+		"method on an embedded field": {
+			helper: "internal/services/dns/parse/id.go",
+			lines:  []provider.LineRange{{Start: 7, End: 7}},
+			want:   []string{"internal/services/dns/dns_a_record_resource_test.go", "internal/services/dns/dns_zone_resource_test.go"},
+			chain: "internal/services/dns/parse/id.go [changed file] → ZoneID [symbol] → internal/services/dns/validate [package] → " +
+				"ZoneID [symbol] → internal/services/dns/dns_zone_resource.go [resource file] → internal/services/dns/dns_zone_resource_test.go [test file]",
+		},
+		"function through another package": {
+			helper: "internal/services/dns/parse/id.go",
+			lines:  []provider.LineRange{{Start: 9, End: 9}},
+			want:   []string{"internal/services/dns/dns_zone_resource_test.go"},
+		},
+		// the cname resource's local variable shares the changed function's name but isn't a use of it
+		"local variable of the same name": {
+			helper: "internal/services/dns/client.go",
+			lines:  []provider.LineRange{{Start: 7, End: 7}},
+			want:   nil,
+		},
+		"import only": {
+			helper: "internal/services/dns/parse/id.go",
+			lines:  []provider.LineRange{{Start: 3, End: 3}},
+			want:   nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			pf := provider.NewFileWithPath(tt.helper, repo)
+			pf.ChangedLines = tt.lines
+			dc.TraceHelperFilesTypes([]provider.File{pf})
+
+			var got []string
+			for _, f := range dc.SortedTestFiles() {
+				got = append(got, f.RelPath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("traced test files = %v, want %v", got, tt.want)
+			}
+			if tt.chain == "" {
+				return
+			}
+			chains := dc.TestFiles["internal/services/dns/dns_zone_resource_test.go"].Chains
+			if !slices.ContainsFunc(chains, func(c provider.Chain) bool { return c.String() == tt.chain }) {
+				t.Errorf("chains = %v, want %s", chains, tt.chain)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// --local-vendor-mode values
+const (
+	VendorModeBasic = "basic"
+	VendorModeDeep  = "deep"
+	VendorModeNone  = "none"
+)
+
+var vendorModes = []string{VendorModeBasic, VendorModeDeep, VendorModeNone}
+
+func validateVendorMode(mode string) error {
+	if !slices.Contains(vendorModes, mode) {
+		return fmt.Errorf("unknown --local-vendor-mode %q, expected one of: %s", mode, strings.Join(vendorModes, ", "))
+	}
+	return nil
+}
+
+// vendorPackage returns the import path of a vendored file's package,
+// e.g. "vendor/github.com/hashicorp/go-azure-sdk/sdk/client/client.go" -> "github.com/hashicorp/go-azure-sdk/sdk/client".
+func vendorPackage(relPath string) string {
+	return filepath.ToSlash(filepath.Dir(strings.TrimPrefix(relPath, "vendor/")))
+}
+
+// traceVendorImporters follows the vendored packages importing the changed ones for --local-vendor-mode deep, breadth
+// first and up to --local-trace-depth - 1 levels so the service packages importing the last of them stay within the
+// depth. A package is reached through a file using one of the exported symbols changed in the package it imports,
+// the exported symbols of that file are then the ones followed into its own importers. It adds the chain reaching
+// each package to pkgChains.
+func (dc *AstDiscoveryContext) traceVendorImporters(vendorFiles []provider.File, pkgChains map[string]provider.Chain) {
+	// package -> the symbols to follow, nil for every symbol of a package changed without a known diff
+	current := map[string]map[string]bool{}
+	for _, pf := range vendorFiles {
+		pkg := vendorPackage(pf.RelPath)
+		symbols := changedSymbols(pf, true)
+		if len(symbols) == 0 {
+			if pf.ChangedLines == nil {
+				current[pkg] = nil
+			}
+			continue
+		}
+		if s, ok := current[pkg]; ok && s == nil {
+			continue
+		}
+		if current[pkg] == nil {
+			current[pkg] = map[string]bool{}
+		}
+		for _, s := range symbols {
+			current[pkg][s] = true
+		}
+	}
+
+	visited := map[string]bool{}
+	for pkg := range current {
+		visited[pkg] = true
+	}
+
+	var reached []string
+	for depth := 1; depth < dc.Config.LocalTraceDepth && len(current) > 0; depth++ {
+		next := map[string]map[string]bool{}
+		for _, pkg := range slices.Sorted(maps.Keys(current)) {
+			symbols := current[pkg]
+			for _, relPath := range dc.vendorImportersOf(pkg) {
+				importer := vendorPackage(relPath)
+				if _, queued := next[importer]; visited[importer] && !queued {
+					continue
+				}
+
+				parsed, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dc.RepoPath, relPath), nil, 0)
+				if err != nil {
+					continue
+				}
+				var used []string
+				if symbols != nil {
+					used = selectorsOf(parsed, importAlias(parsed, pkg), symbols)
+					if len(used) == 0 {
+						clog.Log.Debugf("    skipped: %s imports %s but doesn't use changed symbols", relPath, pkg)
+						continue
+					}
+				}
+
+				if _, queued := next[importer]; !queued {
+					chain := pkgChains[pkg]
+					if len(used) > 0 {
+						chain = chain.Then(provider.LinkSymbol, joinSymbols(used))
+					}
+					pkgChains[importer] = chain.Then(provider.LinkVendorPackage, importer)
+					next[importer] = map[string]bool{}
+					visited[importer] = true
+					reached = append(reached, importer)
+					clog.Log.Debugf("    vendor importer: %s uses %v from %s (depth %d)", relPath, used, pkg, depth)
+				}
+				for _, s := range provider.SymbolsFromAST(parsed, true) {
+					next[importer][s] = true
+				}
+			}
+		}
+		current = next
+	}
+
+	if cout.Level >= cout.VerbosityVerbose {
+		cout.Printf("  tracing the vendor/ import graph...\n")
+	} else {
+		cout.Printf("  tracing the vendor/ import graph... <cyan>%d</> vendored package(s) importing the changed ones\n", len(reached))
+	}
+	for _, pkg := range slices.Sorted(slices.Values(reached)) {
+		cout.Verbosef("    <fg=177>%s</>\n", pkg)
+	}
+}
+
+// vendorImportersOf returns the non-test go files under vendor/ that import a package. The reverse import index is
+// built once, see buildImportIndex.
+func (dc *AstDiscoveryContext) vendorImportersOf(pkgPath string) []string {
+	if dc.vendorIndex == nil {
+		dc.vendorIndex = dc.buildImportIndex([]string{"vendor"}, false)
+		clog.Log.Debugf("    indexed the imports of vendor: %d packages imported", len(dc.vendorIndex))
+	}
+	return dc.vendorIndex[pkgPath]
+}
+
+// importAlias returns the name a file refers to an imported package by, or "" when it doesn't import it.
+func importAlias(parsed *ast.File, pkgPath string) string {
+	for _, imp := range parsed.Imports {
+		if strings.Trim(imp.Path.Value, `"`) != pkgPath {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name // explicit alias
+		}
+		// default alias is last path segment
+		parts := strings.Split(pkgPath, "/")
+		return parts[len(parts)-1]
+	}
+	return ""
+}
+
+// selectorsOf returns the symbols a file uses through a package alias, alias.Symbol, of those given.
+func selectorsOf(parsed *ast.File, alias string, symbols map[string]bool) []string {
+	if alias == "" {
+		return nil
+	}
+	var used []string
+	ast.Inspect(parsed, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok || ident.Name != alias {
+			return true
+		}
+		if symbols[sel.Sel.Name] {
+			used = append(used, sel.Sel.Name)
+		}
+		return true
+	})
+	return used
+}
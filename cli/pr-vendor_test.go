@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+func TestTraceVendorImporters(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"vendor/example.com/sdk/client/client.go": `package client
+
+type Client struct{}
+
+func New() *Client { return &Client{} }
+`,
+		"vendor/example.com/sdk/client/poller.go": `package client
+
+type Poller struct{}
+`,
+		"vendor/example.com/sdk/base/base.go": `package base
+
+import "example.com/sdk/client"
+
+type BaseClient struct{ *client.Client }
+`,
+		"vendor/example.com/sdk/zones/client.go": `package zones
+
+import "example.com/sdk/base"
+
+type ZonesClient struct{ base.BaseClient }
+`,
+		"vendor/example.com/sdk/records/client.go": `package records
+
+import sdk "example.com/sdk/client"
+
+var _ sdk.Poller
+`,
+	})
+
+	tests := map[string]struct {
+		changed string
+		depth   int
+		want    []string
+		chain   string
+	}{
+		"transitive importers using the changed symbols": {
+			changed: "vendor/example.com/sdk/client/client.go",
+			depth:   10,
+			want:    []string{"example.com/sdk/base", "example.com/sdk/client", "example.com/sdk/zones"},
+			chain: "vendor/example.com/sdk/client/client.go [changed file] → example.com/sdk/client [vendor package] → Client [symbol] → " +
+				"example.com/sdk/base [vendor package] → BaseClient [symbol] → example.com/sdk/zones [vendor package]",
+		},
+		"limited by the trace depth": {
+			changed: "vendor/example.com/sdk/client/client.go",
+			depth:   2,
+			want:    []string{"example.com/sdk/base", "example.com/sdk/client"},
+		},
+		"through an import alias": {
+			changed: "vendor/example.com/sdk/client/poller.go",
+			depth:   10,
+			want:    []string{"example.com/sdk/client", "example.com/sdk/records"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dc := newTestDiscoveryContext(repo)
+			dc.Config.LocalTraceDepth = tt.depth
+			pf := provider.NewFileWithPath(tt.changed, repo)
+			pkgChains := map[string]provider.Chain{
+				vendorPackage(tt.changed): provider.Chain{{Kind: provider.LinkChangedFile, Value: tt.changed}}.Then(provider.LinkVendorPackage, vendorPackage(tt.changed)),
+			}
+			dc.traceVendorImporters([]provider.File{pf}, pkgChains)
+
+			if got := slices.Sorted(maps.Keys(pkgChains)); !slices.Equal(got, tt.want) {
+				t.Fatalf("reached packages = %v, want %v", got, tt.want)
+			}
+			if tt.chain != "" && pkgChains["example.com/sdk/zones"].String() != tt.chain {
+				t.Errorf("chain = %s, want %s", pkgChains["example.com/sdk/zones"], tt.chain)
+			}
+		})
+	}
+}
+
+func TestBuildImportIndex(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"vendor/example.com/sdk/base/base.go":           "package base\n\nimport \"example.com/sdk/client\"\n",
+		"vendor/example.com/sdk/base/base_test.go":      "package base\n\nimport \"example.com/sdk/client\"\n",
+		"vendor/example.com/sdk/zones/client.go":        "package zones\n\nimport (\n\tsdk \"example.com/sdk/client\"\n\t\"example.com/sdk/base\"\n)\n",
+		"vendor/example.com/sdk/broken/broken.go":       "package broken\n\nimport \"example.com/sdk/client\n",
+		"internal/services/dns/dns_zone_resource.go":    "package dns\n\nimport \"example.com/sdk/zones\"\n",
+		"internal/services/dns/dns_zone_resource.go.md": "import \"example.com/sdk/client\"\n",
+	})
+
+	dc := newTestDiscoveryContext(repo)
+	index := dc.buildImportIndex([]string{"vendor", "internal/services", "missing"}, false)
+	want := map[string][]string{
+		"example.com/sdk/client": {"vendor/example.com/sdk/base/base.go", "vendor/example.com/sdk/zones/client.go"},
+		"example.com/sdk/base":   {"vendor/example.com/sdk/zones/client.go"},
+		"example.com/sdk/zones":  {"internal/services/dns/dns_zone_resource.go"},
+	}
+	if !maps.EqualFunc(index, want, slices.Equal) {
+		t.Errorf("buildImportIndex() = %v, want %v", index, want)
+	}
+
+	if got := dc.vendorImportersOf("example.com/sdk/client"); !slices.Equal(got, want["example.com/sdk/client"]) {
+		t.Errorf("vendorImportersOf() = %v, want %v", got, want["example.com/sdk/client"])
+	}
+}
@@ -21,17 +21,61 @@ import (
 	"github.com/pkg/browser"
 )
 
-// GetPrTests discovers the tests that need to be run for a PR. It first checks if the PR title contains
-// a test override. If not, it delegates to GithubRepo.PrTestsFromAPI to discover tests based on changed files.
-func (f *FlagData) GetPrTests(number int, title string) (map[string][]string, error) {
+// discovery modes: AST and types trace through a local clone, types type-checking it with go/types, api only uses
+// the GitHub API
+const (
+	ModeAST   = "AST"
+	ModeTypes = "types"
+	ModeAPI   = "api"
+)
+
+var discoveryModes = []string{ModeAST, ModeTypes, ModeAPI}
+
+func validateMode(mode string) error {
+	if !slices.ContainsFunc(discoveryModes, func(m string) bool { return strings.EqualFold(m, mode) }) {
+		return fmt.Errorf("unknown --mode %q, expected one of: %s", mode, strings.Join(discoveryModes, ", "))
+	}
+	return nil
+}
+
+// isLocalMode returns whether a mode discovers tests from a local clone
+func isLocalMode(mode string) bool {
+	return strings.EqualFold(mode, ModeAST) || strings.EqualFold(mode, ModeTypes)
+}
+
+// GetPrTests discovers the tests that need to be run for a PR, see DiscoverPrTests.
+func (f *FlagData) GetPrTests(number int, title string, pr *github.PullRequest, files []*github.CommitFile) (map[string][]string, error) {
+	d, err := f.DiscoverPrTests(number, title, pr, files)
+	if err != nil {
+		return nil, err
+	}
+	return d.ServiceTests(), nil
+}
+
+// DiscoverPrTests discovers the tests that need to be run for a PR from its changed files, using the AST or API
+// mode, then applies any test directives in the PR description (see PrDirectives) to override, extend or prune them.
+// pr and files are the PR and its changed files when the caller already has them, otherwise they are fetched.
+func (f *FlagData) DiscoverPrTests(number int, title string, pr *github.PullRequest, files []*github.CommitFile) (*Discovery, error) {
 	ghr := f.NewRepo()
 
+	var err error
+	if pr == nil {
+		if pr, err = ghr.GetPullRequest(number); err != nil {
+			return nil, err
+		}
+	}
+	if files == nil {
+		if files, err = ghr.ListPullRequestFiles(number); err != nil {
+			return nil, err
+		}
+	}
+
 	prURL := ghr.PrURL(number)
+	var d *Discovery
 	var serviceTests map[string][]string
-	var err error
 
 	mode := f.DiscoveryConfig.Mode
-	if strings.EqualFold(mode, "AST") {
+	if isLocalMode(mode) {
 		repoPath := f.DiscoveryConfig.LocalRepoPath
 		cwdWarning := ""
 		if repoPath == "" {
@@ -44,15 +88,21 @@ func (f *FlagData) GetPrTests(number int, title string) (map[string][]string, er
 
 		if repoPath != "" {
 			f.DiscoveryConfig.LocalRepoPath = repoPath
-			cout.Printf("Discovering tests for pr <cyan>#%d</> %s <darkGray>%s</> <yellow>[mode=AST]</>%s\n", number, title, prURL, cwdWarning)
-			serviceTests, err = ghr.PrTestsFromAst(number, f.DiscoveryConfig)
+			f.discoveryMode = ModeAST
+			if strings.EqualFold(mode, ModeTypes) {
+				f.discoveryMode = ModeTypes
+			}
+			cout.Printf("Discovering tests for pr <cyan>#%d</> %s <darkGray>%s</> <yellow>[mode=%s]</>%s\n", number, title, prURL, f.discoveryMode, cwdWarning)
+			d, serviceTests, err = ghr.PrTestsFromAst(pr, files, f.DiscoveryConfig)
 		} else {
 			cout.Printf("Discovering tests for pr <cyan>#%d</> %s <darkGray>%s</> <yellow>[mode=api (fallback)]</>\n", number, title, prURL)
-			serviceTests, err = ghr.PrTestsFromAPI(number, f.DiscoveryConfig)
+			d, serviceTests, err = ghr.PrTestsFromAPI(pr, files, f.DiscoveryConfig)
+			f.discoveryMode = ModeAPI
 		}
 	} else {
 		cout.Printf("Discovering tests for pr <cyan>#%d</> %s <darkGray>%s</> <yellow>[mode=api]</>\n", number, title, prURL)
-		serviceTests, err = ghr.PrTestsFromAPI(number, f.DiscoveryConfig)
+		d, serviceTests, err = ghr.PrTestsFromAPI(pr, files, f.DiscoveryConfig)
+		f.discoveryMode = ModeAPI
 	}
 
 	if f.OpenInBrowser {
@@ -65,6 +115,18 @@ func (f *FlagData) GetPrTests(number int, title string) (map[string][]string, er
 		return nil, fmt.Errorf("pr list failed: %w", err)
 	}
 
+	var sources map[string]map[string]string
+	if !f.IgnorePrDirectives {
+		directives, err := ParsePrDirectives(pr.GetBody())
+		if err != nil {
+			return nil, err
+		}
+		if directives != nil {
+			cout.Printf("  applying test directives from the PR description\n")
+			serviceTests, sources = directives.Apply(serviceTests, f.DiscoveryConfig.SplitTestsOn)
+		}
+	}
+
 	maxLen := 0
 	for service := range serviceTests {
 		if len(service) > maxLen {
@@ -73,40 +135,49 @@ func (f *FlagData) GetPrTests(number int, title string) (map[string][]string, er
 	}
 
 	for service, tests := range serviceTests {
-		cout.Printf("  <yellow>%-*s</>: %s\n", maxLen, service, strings.Join(tests, ", "))
+		labelled := make([]string, 0, len(tests))
+		for _, t := range tests {
+			label := t
+			if sources != nil {
+				// directives mix tests from several sources, so show where each came from
+				label += fmt.Sprintf(" <darkGray>(%s)</>", sources[service][t])
+			}
+			if slices.Contains(d.smokeTests[service], t) {
+				label += " <darkGray>[SMOKE]</>"
+			}
+			labelled = append(labelled, label)
+		}
+		cout.Printf("  <yellow>%-*s</>: %s\n", maxLen, service, strings.Join(labelled, ", "))
 	}
 
-	return serviceTests, nil
+	d.Mode = f.discoveryMode
+	d.setServiceTests(f, serviceTests, sources)
+	f.printExplanations(d)
+	return d, nil
 }
 
-// PrTestsFromAPI fetches the list of files changed in a PR and determines which tests should be run.
-// It uses GetPullRequestTestFiles to get the files, groups them into packages, and returns a map of package names to a list of test names.
-func (ghr GithubRepo) PrTestsFromAPI(pri int, cfg DiscoveryConfig) (map[string][]string, error) {
-	client, ctx := ghr.NewClient()
+// PrTestsFromAPI determines which tests should be run for the files changed in a PR.
+// It uses GetPullRequestTestFiles to find the test files, groups them into packages, and returns the discovery with
+// a map of package names to a list of test names.
+func (ghr GithubRepo) PrTestsFromAPI(pr *github.PullRequest, files []*github.CommitFile, cfg DiscoveryConfig) (*Discovery, map[string][]string, error) {
+	_, ctx := ghr.NewClient()
 	httpClient := chttp.NewHTTPClient("HTTP")
-
-	clog.Log.Debugf("fetching data for PR %s/%s/#%d...", ghr.Owner, ghr.Name, pri)
-	pr, _, err := client.PullRequests.Get(ctx, ghr.Owner, ghr.Name, pri)
-	if err != nil {
-		return nil, gh.WrapGitHubError(err, fmt.Sprintf("fetching PR %s/%s/#%d", ghr.Owner, ghr.Name, pri))
-	}
+	pri := pr.GetNumber()
 
 	clog.Log.Debugf("  checking pr state: %v", pr.GetState())
-	if pr.GetState() == gh.PRStateClosed {
-		return nil, errors.New("cannot start build for a closed pr")
-	}
-	if pr.MergeCommitSHA == nil {
-		return nil, errors.New("merge commit SHA is nil, is there a merge conflict?")
+	if err := CheckPrCanBuild(pr); err != nil {
+		return nil, nil, err
 	}
 
 	clog.Log.Tracef("listing files...")
-	filesFiltered, err := ghr.GetPullRequestTestFiles(pri, cfg)
+	filesFiltered, changed, err := ghr.GetPullRequestTestFiles(files, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get PR files for %s/%s/pull/%d: %w", ghr.Owner, ghr.Name, pri, err)
+		return nil, nil, fmt.Errorf("failed to get PR files for %s/%s/pull/%d: %w", ghr.Owner, ghr.Name, pri, err)
 	}
 
 	// for each file get content and parse out test files & services
 	serviceTestMap := map[string]map[string]bool{}
+	testChains := map[string][]provider.Chain{}
 
 	clog.Log.Debugf("  downloading & parsing %d files concurrently (max %d):", len(filesFiltered), cfg.Concurrency)
 	mu := sync.Mutex{}
@@ -162,6 +233,7 @@ func (ghr GithubRepo) PrTestsFromAPI(pri int, cfg DiscoveryConfig) (map[string][
 
 				serviceTestMap[service][t] = true
 			}
+			addTestChains(testChains, f, tests)
 			mu.Unlock()
 		}(f)
 	}
@@ -169,7 +241,7 @@ func (ghr GithubRepo) PrTestsFromAPI(pri int, cfg DiscoveryConfig) (map[string][
 	wg.Wait()
 
 	if len(errs) > 0 {
-		return nil, errors.Join(errs...)
+		return nil, nil, errors.Join(errs...)
 	}
 
 	serviceTests := map[string][]string{}
@@ -187,20 +259,56 @@ func (ghr GithubRepo) PrTestsFromAPI(pri int, cfg DiscoveryConfig) (map[string][
 		sort.Strings(serviceTests[service])
 	}
 
-	return serviceTests, nil
+	changedPaths := make([]string, 0, len(changed))
+	var moduleFiles []string
+	for _, df := range changed {
+		changedPaths = append(changedPaths, df.Path)
+		if provider.IsModuleFile(df.Path) {
+			moduleFiles = append(moduleFiles, df.Path)
+		}
+	}
+	if len(moduleFiles) > 0 {
+		cout.Printf("  <yellow>WARNING:</> %s changed, bumped modules are only traced with --local-repo-path\n", strings.Join(moduleFiles, ", "))
+	}
+	smokeTests, smokeChains := cfg.SmokeSuites.Tests(changedPaths)
+	mergeSmokeTests(serviceTests, smokeTests)
+	for t, chains := range smokeChains {
+		testChains[t] = append(testChains[t], chains...)
+	}
+
+	d := &Discovery{
+		PR:           pri,
+		Title:        pr.GetTitle(),
+		URL:          pr.GetHTMLURL(),
+		MergeSHA:     pr.GetMergeCommitSHA(),
+		ChangedFiles: changed,
+		TestFiles:    newDiscoveryFiles(filesFiltered),
+		smokeTests:   smokeTests,
+		testChains:   testChains,
+	}
+	return d, serviceTests, nil
 }
 
-// CheckPrCanBuild verifies a PR exists, is open, and has a merge commit. Used by the
-// direct-trigger path (--service + --all/test regex), which skips discovery and would
-// otherwise happily trigger builds on a stale or missing refs/pull/N/merge ref.
-func (f *FlagData) CheckPrCanBuild(number int) error {
-	ghr := f.NewRepo()
-	client, ctx := ghr.NewClient()
+// diffAware returns whether only what the PR changed in a file is traced or tested: not with --whole-file-symbols,
+// nor for added and renamed files, where everything is new.
+func diffAware(f *github.CommitFile, cfg DiscoveryConfig) bool {
+	return !cfg.WholeFileSymbols && f.GetStatus() != "added" && f.GetStatus() != "renamed"
+}
 
-	pr, _, err := client.PullRequests.Get(ctx, ghr.Owner, ghr.Name, number)
-	if err != nil {
-		return gh.WrapGitHubError(err, fmt.Sprintf("fetching PR %s/%s/#%d", ghr.Owner, ghr.Name, number))
+// changedLines returns the lines a PR patch changed, or nil to use the whole file when there are none.
+func changedLines(relPath, patch string) []provider.LineRange {
+	lines, err := provider.ParsePatchLines(patch)
+	if err != nil || len(lines) == 0 {
+		clog.Log.Debugf("    no changed lines for %s, using the whole file: %v", relPath, err)
+		return nil
 	}
+	return lines
+}
+
+// CheckPrCanBuild verifies a PR is open and has a merge commit. Used by API discovery and the
+// direct-trigger path (--service + --all/test regex), which skips discovery and would
+// otherwise happily trigger builds on a stale or missing refs/pull/N/merge ref.
+func CheckPrCanBuild(pr *github.PullRequest) error {
 	if pr.GetState() == gh.PRStateClosed {
 		return errors.New("cannot start build for a closed pr")
 	}
@@ -210,88 +318,99 @@ func (f *FlagData) CheckPrCanBuild(number int) error {
 	return nil
 }
 
-// GetPullRequestTestFiles fetches all changed files in a PR and determines the related test files.
-// It classifies files based on the DiscoveryConfig and lists contents of directories containing changed resources to find related tests.
-func (ghr GithubRepo) GetPullRequestTestFiles(pri int, cfg DiscoveryConfig) ([]provider.File, error) {
+// GetPullRequestTestFiles determines the test files related to a PR's changed files, returning them and the changed
+// service package files. It classifies files based on the DiscoveryConfig and lists contents of
+// directories containing changed resources to find related tests.
+func (ghr GithubRepo) GetPullRequestTestFiles(files []*github.CommitFile, cfg DiscoveryConfig) ([]provider.File, []DiscoveryFile, error) {
 	// track resource files that need sibling test file discovery
 	// key: directory path, value: list of resource prefixes (e.g. "foo")
 	resourcePrefixesByPackage := map[string][]string{}
+	prefixChains := map[string][]provider.Chain{} // dir/prefix -> the changed files it came from
+	addPrefix := func(dir string, pf provider.File, prefix string) {
+		resourcePrefixesByPackage[dir] = append(resourcePrefixesByPackage[dir], prefix)
+		key := path.Join(dir, prefix)
+		prefixChains[key] = append(prefixChains[key], provider.Chain{{Kind: provider.LinkChangedFile, Value: pf.RelPath}})
+	}
 
 	// track changed files and test files for output
 	var changedServiceFiles []provider.File
 	skippedFiles := map[string]bool{} // service files that didn't match the regex
 
 	testFiles := map[string]*provider.File{}
-	addTestFile := func(pf provider.File, source string) {
+	addTestFile := func(pf provider.File, source string, chains ...provider.Chain) {
 		existing, ok := testFiles[pf.RelPath]
 		if !ok {
 			existing = &pf
 			testFiles[pf.RelPath] = existing
 		}
 		existing.AddDiscovery(source)
+		for _, c := range chains {
+			existing.AddChain(c)
+		}
 	}
 
-	// first get all files for the pull request and filter out every one that is not inside a service package
-	err := ghr.ListAllPullRequestFiles(pri, func(files []*github.CommitFile, _ *github.Response) error {
-		for _, f := range files {
-			if f.Filename == nil {
-				continue
-			}
-
-			pf := provider.NewFile(f.GetFilename())
-			clog.Log.Debugf("    %v (%s)", pf.RelPath, f.GetStatus())
+	// filter out every changed file that is not inside a service package
+	for _, f := range files {
+		if f.Filename == nil {
+			continue
+		}
 
-			// for now we only care about go files, data files that acctests load/rely on will be skipped for now
-			if !strings.HasSuffix(pf.RelPath, ".go") {
-				continue
-			}
+		pf := provider.NewFile(f.GetFilename())
+		clog.Log.Debugf("    %v (%s)", pf.RelPath, f.GetStatus())
 
-			// skip deleted files - they won't exist at the merge commit
-			if f.GetStatus() == "removed" {
-				clog.Log.Debugf("    skipping removed file: %s", pf.RelPath)
-				continue
-			}
+		// nothing is derived from files outside the service packages, they only run the smoke suites they match
+		if cfg.SmokeSuites.Matches(pf.RelPath) {
+			changedServiceFiles = append(changedServiceFiles, pf)
+			continue
+		}
 
-			if pf.Type == provider.FileTypeHelper {
-				// track service files that don't match the regex (e.g. client helpers)
-				changedServiceFiles = append(changedServiceFiles, pf)
+		// for now we only care about go files, data files that acctests load/rely on will be skipped for now
+		if !strings.HasSuffix(pf.RelPath, ".go") {
+			continue
+		}
 
-				// Azure migration files live in a subdirectory/separate package. These files are _usually_ prefixed with the resource name
-				// which can be used to determine a test prefix.
-				if pf.IsMigrationFile() {
-					parentDir := path.Dir(path.Dir(pf.RelPath))
-					resourcePrefixesByPackage[parentDir] = append(resourcePrefixesByPackage[parentDir], pf.MigrationResourcePrefix())
-				} else {
-					skippedFiles[pf.RelPath] = true
-				}
-				continue
-			}
+		// skip deleted files - they won't exist at the merge commit
+		if f.GetStatus() == "removed" {
+			clog.Log.Debugf("    skipping removed file: %s", pf.RelPath)
+			continue
+		}
 
-			if pf.Type == provider.FileTypeTest {
-				changedServiceFiles = append(changedServiceFiles, pf)
-				addTestFile(pf, "CHANGED")
-				continue
-			}
+		if pf.Type == provider.FileTypeHelper {
+			// track service files that don't match the regex (e.g. client helpers)
+			changedServiceFiles = append(changedServiceFiles, pf)
 
-			if pf.Type == provider.FileTypeOther || pf.Type == provider.FileTypeVendor {
-				// if they are in the service path (e.g. registration.go, resourceids.go), mark them as skipped in the output
-				if pf.InServicePackage() {
-					changedServiceFiles = append(changedServiceFiles, pf)
-					skippedFiles[pf.RelPath] = true
-				}
-				continue
+			// Azure migration files live in a subdirectory/separate package. These files are _usually_ prefixed with the resource name
+			// which can be used to determine a test prefix.
+			if pf.IsMigrationFile() {
+				addPrefix(path.Dir(path.Dir(pf.RelPath)), pf, pf.MigrationResourcePrefix())
+			} else {
+				skippedFiles[pf.RelPath] = true
 			}
+			continue
+		}
 
+		if pf.Type == provider.FileTypeTest {
 			changedServiceFiles = append(changedServiceFiles, pf)
+			if diffAware(f, cfg) {
+				pf.ChangedLines = changedLines(pf.RelPath, f.GetPatch())
+			}
+			addTestFile(pf, "CHANGED", provider.Chain{{Kind: provider.LinkChangedFile, Value: pf.RelPath}})
+			continue
+		}
 
-			// note the directory and probable resourceName so we can discover all related test files
-			resourcePrefixesByPackage[path.Dir(pf.RelPath)] = append(resourcePrefixesByPackage[path.Dir(pf.RelPath)], pf.ResourcePrefix())
+		if pf.Type == provider.FileTypeOther || pf.Type == provider.FileTypeVendor {
+			// if they are in the service path (e.g. registration.go, resourceids.go), mark them as skipped in the output
+			if pf.InServicePackage() {
+				changedServiceFiles = append(changedServiceFiles, pf)
+				skippedFiles[pf.RelPath] = true
+			}
+			continue
 		}
 
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all files for %s/%s/pull/%d: %w", ghr.Owner, ghr.Name, pri, err)
+		changedServiceFiles = append(changedServiceFiles, pf)
+
+		// note the directory and probable resourceName so we can discover all related test files
+		addPrefix(path.Dir(pf.RelPath), pf, pf.ResourcePrefix())
 	}
 
 	// For each directory containing a modified file, list all files
@@ -309,7 +428,7 @@ func (ghr GithubRepo) GetPullRequestTestFiles(pri int, cfg DiscoveryConfig) ([]p
 					clog.Log.Debugf("  directory %s not found (new in this PR?), skipping sibling test discovery", dir)
 					continue
 				}
-				return nil, fmt.Errorf("failed to list directory %s for related test files: %w", dir, err)
+				return nil, nil, fmt.Errorf("failed to list directory %s for related test files: %w", dir, err)
 			}
 
 			for _, entry := range dirContents {
@@ -318,8 +437,9 @@ func (ghr GithubRepo) GetPullRequestTestFiles(pri int, cfg DiscoveryConfig) ([]p
 					continue
 				}
 
-				shouldInclude := false
-				for _, resource := range prefixes {
+				// every matching prefix contributes its chains, a test file can be derived from several changed files
+				var chains []provider.Chain
+				for _, resource := range slices.Compact(slices.Sorted(slices.Values(prefixes))) {
 					if !strings.HasPrefix(pf.BaseName, resource) {
 						continue
 					}
@@ -327,26 +447,18 @@ func (ghr GithubRepo) GetPullRequestTestFiles(pri int, cfg DiscoveryConfig) ([]p
 					remainder := pf.BaseName[len(resource):]
 					for _, testSuffix := range cfg.AccTestFileSuffixRegexes {
 						if testSuffix.MatchString(remainder) {
-							shouldInclude = true
+							chains = append(chains, prefixChains[path.Join(dir, resource)]...)
 							break
 						}
 					}
-
-					if shouldInclude {
-						break
-					}
 				}
 
-				if !shouldInclude {
-					continue
-				}
-
-				if _, exists := testFiles[pf.RelPath]; exists {
+				if len(chains) == 0 {
 					continue
 				}
 
 				clog.Log.Debugf("    discovered related test: %s", pf.RelPath)
-				addTestFile(pf, "DERIVED")
+				addTestFile(pf, "DERIVED", chains...)
 			}
 		}
 	}
@@ -409,9 +521,15 @@ func (ghr GithubRepo) GetPullRequestTestFiles(pri int, cfg DiscoveryConfig) ([]p
 		clog.Log.Debugf("     %s", f)
 	}
 
-	files := make([]provider.File, 0, len(sortedTestFiles))
+	found := make([]provider.File, 0, len(sortedTestFiles))
 	for _, pf := range sortedTestFiles {
-		files = append(files, *pf)
+		found = append(found, *pf)
+	}
+	changed := make([]DiscoveryFile, 0, len(changedServiceFiles))
+	for _, pf := range changedServiceFiles {
+		df := newDiscoveryFile(pf)
+		df.Skipped = skippedFiles[pf.RelPath]
+		changed = append(changed, df)
 	}
-	return files, nil
+	return found, changed, nil
 }
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/git"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+// profilesFileName is looked for in the current directory, then the home directory, when --profiles-file isn't set.
+const profilesFileName = ".tctest.yaml"
+
+// Profile is a named set of flag values, for switching between providers and TeamCity servers:
+//
+//	profiles:
+//	  aws:
+//	    remotes: [hashicorp/terraform-provider-aws]
+//	    server: https://teamcity.example.com
+//	    build-type-id: AWS_ACCTEST
+//	    fileregex: ^internal/service/[^/]+/[a-z0-9_][^/]*$
+//	    services:
+//	      ec2:
+//	        build-type-ids: [AWS_ACCTEST_EC2]
+//	    smoke:
+//	      internal/provider/**: [ec2:TestAccEC2Instance_basic]
+//
+// Every key other than remotes, services and smoke is a flag name.
+type Profile struct {
+	Remotes  []string       `yaml:"remotes"`  // owner/repo of the git remotes that select the profile, default its repo
+	Services ServiceMap     `yaml:"services"` // an inline --service-map
+	Smoke    SmokeSuites    `yaml:"smoke"`    // the inline --service-map's smoke suites
+	Settings map[string]any `yaml:",inline"`
+}
+
+type ProfilesFile struct {
+	Default  string             `yaml:"default"` // used when no profile matches the git remotes
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// activeProfile is the profile applied by ApplyProfile, GetFlags uses its service map.
+var (
+	activeProfile     *Profile
+	activeProfileName string
+)
+
+// profileOnlyFlags can't be set by a profile.
+var profileOnlyFlags = []string{"profile", "profiles-file"}
+
+// LoadProfiles reads and validates a profiles file, every setting must be one of flags. Relative --service-map
+// paths are resolved against the file's directory.
+func LoadProfiles(path string, flags *pflag.FlagSet) (*ProfilesFile, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // path is from --profiles-file or the well known locations
+	if err != nil {
+		return nil, fmt.Errorf("reading profiles %s: %w", path, err)
+	}
+
+	var pf ProfilesFile
+	if err := yaml.Unmarshal(b, &pf); err != nil {
+		return nil, fmt.Errorf("parsing profiles %s: %w", path, err)
+	}
+
+	for name, p := range pf.Profiles {
+		for k, v := range p.Settings {
+			if flags.Lookup(k) == nil || slices.Contains(profileOnlyFlags, k) {
+				return nil, fmt.Errorf("profiles %s: profile %q sets unknown flag %q", path, name, k)
+			}
+			if _, ok := v.(map[string]any); ok {
+				return nil, fmt.Errorf("profiles %s: profile %q: %s must be a value or list, not a map", path, name, k)
+			}
+			if sm, ok := v.(string); ok && k == "service-map" && sm != "" && !filepath.IsAbs(sm) {
+				p.Settings[k] = filepath.Join(filepath.Dir(path), sm)
+			}
+		}
+		if err := validateServiceMap(p.Services); err != nil {
+			return nil, fmt.Errorf("profiles %s: profile %q: %w", path, name, err)
+		}
+		if err := validateSmokeSuites(p.Smoke); err != nil {
+			return nil, fmt.Errorf("profiles %s: profile %q: %w", path, name, err)
+		}
+	}
+
+	if pf.Default != "" {
+		if _, ok := pf.Profiles[pf.Default]; !ok {
+			return nil, fmt.Errorf("profiles %s: default profile %q doesn't exist", path, pf.Default)
+		}
+	}
+
+	return &pf, nil
+}
+
+// remotes returns the owner/repos that select the profile.
+func (p Profile) remotes() []string {
+	if len(p.Remotes) > 0 {
+		return p.Remotes
+	}
+	if repo, ok := p.Settings["repo"].(string); ok && repo != "" {
+		return []string{repo}
+	}
+	return nil
+}
+
+// Select returns the profile to use for a directory with these git remotes (remote name -> owner/repo): the one
+// profile whose remotes match, otherwise the default, and why it was chosen.
+func (pf ProfilesFile) Select(remotes map[string]string) (string, string, error) {
+	var matched []string
+	for _, name := range slices.Sorted(maps.Keys(pf.Profiles)) {
+		for _, want := range pf.Profiles[name].remotes() {
+			if slices.ContainsFunc(slices.Collect(maps.Values(remotes)), func(r string) bool { return strings.EqualFold(r, want) }) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return pf.Default, "default", nil
+	case 1:
+		return matched[0], "matches the git remotes", nil
+	}
+	return "", "", fmt.Errorf("the git remotes match profiles %s, choose one with --profile", strings.Join(matched, ", "))
+}
+
+// findProfilesFile returns the profiles file in the current or home directory, or "" if there is none.
+func findProfilesFile() string {
+	var dirs []string
+	if cwd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, cwd)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, profilesFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// ApplyProfile merges the selected profile's settings into the config, so flags and environment variables still
+// override them. The profile is --profile, otherwise the one matching the current directory's git remotes. It returns
+// which profile was applied and why, for printing once the verbosity is known.
+func ApplyProfile(flags *pflag.FlagSet) (string, error) {
+	name := viper.GetString("profile")
+
+	path := viper.GetString("profiles-file")
+	if path == "" {
+		path = findProfilesFile()
+	}
+	if path == "" {
+		if name != "" {
+			return "", fmt.Errorf("--profile %s is set, but there is no %s in the current or home directory (see --profiles-file)", name, profilesFileName)
+		}
+		return "", nil
+	}
+
+	pf, err := LoadProfiles(path, flags)
+	if err != nil {
+		return "", err
+	}
+
+	reason := "--profile"
+	if name == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("getting the current directory to select a profile: %w", err)
+		}
+		remotes, err := git.RemoteRepos(cwd)
+		if err != nil {
+			clog.Log.Debugf("no git remotes to select a profile with: %v", err)
+		}
+		if name, reason, err = pf.Select(remotes); err != nil {
+			return "", err
+		}
+		if name == "" {
+			return "", nil
+		}
+	}
+
+	p, ok := pf.Profiles[name]
+	if !ok {
+		return "", errors.New("unknown profile " + name + ", expected one of: " + strings.Join(slices.Sorted(maps.Keys(pf.Profiles)), ", "))
+	}
+
+	if err := viper.MergeConfigMap(p.Settings); err != nil {
+		return "", fmt.Errorf("applying profile %s: %w", name, err)
+	}
+	activeProfile, activeProfileName = &p, name
+
+	return fmt.Sprintf("using profile <cyan>%s</> from %s <darkGray>(%s)</>", name, path, reason), nil
+}
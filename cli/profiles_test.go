@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func testProfileFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("server", "", "")
+	flags.String("build-type-id", "", "")
+	flags.String("repo", "", "")
+	flags.String("service-map", "", "")
+	flags.String("profile", "", "")
+	return flags
+}
+
+func writeProfiles(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), profilesFileName)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing profiles: %v", err)
+	}
+	return path
+}
+
+func TestLoadProfiles(t *testing.T) {
+	t.Parallel()
+
+	path := writeProfiles(t, `default: azurerm
+profiles:
+  azurerm:
+    repo: hashicorp/terraform-provider-azurerm
+    server: https://tc.example.com
+    build-type-id: TF_AZURERM
+    services:
+      postgres:
+        build-type-ids: [TF_PG]
+  aws:
+    remotes: [hashicorp/terraform-provider-aws, someone/aws-fork]
+    build-type-id: TF_AWS
+    service-map: aws-services.yaml
+`)
+
+	pf, err := LoadProfiles(path, testProfileFlags())
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if got := pf.Profiles["azurerm"].Settings["build-type-id"]; got != "TF_AZURERM" {
+		t.Errorf("azurerm build-type-id = %v, want TF_AZURERM", got)
+	}
+	if got := pf.Profiles["azurerm"].Services["postgres"].BuildTypeIDs; len(got) != 1 || got[0] != "TF_PG" {
+		t.Errorf("azurerm postgres build types = %v, want [TF_PG]", got)
+	}
+	if got, want := pf.Profiles["aws"].Settings["service-map"], filepath.Join(filepath.Dir(path), "aws-services.yaml"); got != want {
+		t.Errorf("aws service-map = %v, want %s", got, want)
+	}
+
+	cases := []struct {
+		name    string
+		remotes map[string]string
+		want    string
+	}{
+		{"repo setting", map[string]string{"origin": "hashicorp/terraform-provider-azurerm"}, "azurerm"},
+		{"remotes different case", map[string]string{"origin": "HashiCorp/Terraform-Provider-AWS"}, "aws"},
+		{"fork with upstream", map[string]string{"origin": "someone/aws-fork", "upstream": "hashicorp/terraform-provider-aws"}, "aws"},
+		{"no match", map[string]string{"origin": "katbyte/tctest"}, "azurerm"},
+		{"not a git repo", nil, "azurerm"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, _, err := pf.Select(tt.remotes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := pf.Select(map[string]string{"origin": "hashicorp/terraform-provider-azurerm", "aws": "hashicorp/terraform-provider-aws"}); err == nil {
+		t.Error("remotes matching two profiles, want an error")
+	}
+}
+
+func TestLoadProfilesInvalid(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"unknown flag":      "profiles:\n  aws:\n    servr: https://tc.example.com\n",
+		"profile flag":      "profiles:\n  aws:\n    profile: azurerm\n",
+		"map value":         "profiles:\n  aws:\n    server:\n      url: https://tc.example.com\n",
+		"missing default":   "default: gcp\nprofiles:\n  aws:\n    server: https://tc.example.com\n",
+		"empty build types": "profiles:\n  aws:\n    services:\n      ec2:\n        build-type-ids: ['']\n",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := LoadProfiles(writeProfiles(t, content), testProfileFlags()); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
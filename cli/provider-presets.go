@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"path"
+
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/git"
+	"github.com/katbyte/tctest/lib/provider"
+	"github.com/spf13/viper"
+)
+
+// ProviderPreset is the discovery layout of a known provider repository.
+type ProviderPreset struct {
+	FileRegex                string
+	AccTestFileSuffixRegexes []string
+	ServiceDir               string // where the service packages live, one of provider.ServiceDirPrefixes
+}
+
+// ProviderPresets are keyed by repository name, and applied as defaults when --repo names one of them, so flags,
+// environment variables, the config file and profiles still override them.
+var ProviderPresets = map[string]ProviderPreset{
+	"terraform-provider-azurerm": {
+		FileRegex: `^internal/services/[^/]+/[a-z0-9_][^/]*$`,
+		AccTestFileSuffixRegexes: []string{
+			`^_resource.*_test$`, // also covers test files like `linux_virtual_machine_scale_set_resource_auth_test.go`
+			`^_test$`,
+			`^_data_source_test$`,
+		},
+		ServiceDir: "internal/services",
+	},
+	"terraform-provider-aws": {
+		FileRegex: `^internal/service/[^/]+/[a-z0-9_][^/]*$`,
+		AccTestFileSuffixRegexes: []string{
+			`^_test$`,
+			`^_list_test$`,
+			`^_identity_gen_test$`,
+			`^_tags_gen_test$`,
+			`^_data_source_test$`,
+		},
+		ServiceDir: "internal/service",
+	},
+}
+
+// detectedDefaults records where applyRepoDefaults got each default it set from, for doctor.
+var detectedDefaults = map[string]string{}
+
+// serviceDirsFor returns where the service packages of a repo live: its preset's directory, or every known layout.
+func serviceDirsFor(repo string) []string {
+	if preset, ok := ProviderPresets[path.Base(repo)]; ok {
+		return []string{preset.ServiceDir}
+	}
+	return provider.ServiceDirPrefixes
+}
+
+// detectRepo returns the owner/repo of the git clone in dir, preferring the upstream remote of a fork over origin,
+// or "" when it isn't a clone of a GitHub-like host.
+func detectRepo(dir string) (repo, remote string) {
+	remotes, err := git.RemoteRepos(dir)
+	if err != nil {
+		clog.Log.Debugf("not detecting --repo, no git remotes in %s: %v", dir, err)
+		return "", ""
+	}
+
+	for _, remote := range []string{"upstream", "origin"} {
+		if repo, ok := remotes[remote]; ok {
+			return repo, remote
+		}
+	}
+	return "", ""
+}
+
+// applyRepoDefaults detects --repo from the clone in dir when it isn't set, then applies the provider preset for the
+// repo as viper defaults. It returns what it did, for printing once the verbosity is known, and where each default
+// came from, for doctor. The preset's service directory is picked up by GetFlags, see serviceDirsFor.
+func applyRepoDefaults(v *viper.Viper, dir string) (notes []string, sources map[string]string) {
+	sources = map[string]string{}
+
+	repo := v.GetString("repo")
+	if repo == "" {
+		var remote string
+		if repo, remote = detectRepo(dir); repo == "" {
+			return nil, sources
+		}
+		v.SetDefault("repo", repo)
+		sources["repo"] = "the " + remote + " git remote"
+		notes = append(notes, "detected repo <cyan>"+repo+"</> from the "+remote+" remote")
+	}
+
+	name := path.Base(repo)
+	preset, ok := ProviderPresets[name]
+	if !ok {
+		return notes, sources
+	}
+
+	v.SetDefault("fileregex", preset.FileRegex)
+	v.SetDefault("acctest-file-suffix-regexes", preset.AccTestFileSuffixRegexes)
+	sources["fileregex"] = name + " provider layout"
+	sources["acctest-file-suffix-regexes"] = name + " provider layout"
+
+	return append(notes, "using the <cyan>"+name+"</> provider layout"), sources
+}
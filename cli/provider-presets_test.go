@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/katbyte/tctest/lib/git"
+	"github.com/katbyte/tctest/lib/provider"
+	"github.com/spf13/viper"
+)
+
+// gitClone returns a git repo with the given remotes, name -> URL.
+func gitClone(t *testing.T, remotes map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if _, err := git.Run(dir, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(remotes)) {
+		if _, err := git.Run(dir, "remote", "add", name, remotes[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDetectRepo(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		remotes map[string]string
+		repo    string
+		remote  string
+	}{
+		{name: "origin", remotes: map[string]string{"origin": "git@github.com:katbyte/tctest.git"}, repo: "katbyte/tctest", remote: "origin"},
+		{
+			name:    "upstream of a fork",
+			remotes: map[string]string{"origin": "https://github.com/katbyte/terraform-provider-aws.git", "upstream": "https://github.com/hashicorp/terraform-provider-aws.git"},
+			repo:    "hashicorp/terraform-provider-aws",
+			remote:  "upstream",
+		},
+		{name: "other remotes only", remotes: map[string]string{"fork": "https://github.com/katbyte/tctest.git"}},
+		{name: "local path", remotes: map[string]string{"origin": "/src/tctest"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo, remote := detectRepo(gitClone(t, tt.remotes))
+			if repo != tt.repo || remote != tt.remote {
+				t.Errorf("detectRepo = %q, %q, want %q, %q", repo, remote, tt.repo, tt.remote)
+			}
+		})
+	}
+
+	if repo, remote := detectRepo(t.TempDir()); repo != "" || remote != "" {
+		t.Errorf("detectRepo outside a clone = %q, %q, want nothing", repo, remote)
+	}
+}
+
+func TestApplyRepoDefaults(t *testing.T) {
+	t.Parallel()
+
+	for name, preset := range ProviderPresets {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := viper.New()
+			notes, sources := applyRepoDefaults(v, gitClone(t, map[string]string{"origin": "https://github.com/hashicorp/" + name + ".git"}))
+			if got := v.GetString("repo"); got != "hashicorp/"+name {
+				t.Errorf("repo = %q, want the detected hashicorp/%s", got, name)
+			}
+			if got := v.GetString("fileregex"); got != preset.FileRegex {
+				t.Errorf("fileregex = %q, want %q", got, preset.FileRegex)
+			}
+			if got := v.GetStringSlice("acctest-file-suffix-regexes"); !slices.Equal(got, preset.AccTestFileSuffixRegexes) {
+				t.Errorf("acctest-file-suffix-regexes = %v, want %v", got, preset.AccTestFileSuffixRegexes)
+			}
+			if sources["repo"] != "the origin git remote" || sources["fileregex"] != name+" provider layout" {
+				t.Errorf("sources = %v", sources)
+			}
+			if len(notes) != 2 {
+				t.Errorf("notes = %v, want the detected repo and the layout", notes)
+			}
+			if got := serviceDirsFor(v.GetString("repo")); !slices.Equal(got, []string{preset.ServiceDir}) {
+				t.Errorf("serviceDirsFor = %v, want %s", got, preset.ServiceDir)
+			}
+		})
+	}
+
+	t.Run("set repo wins over the clone", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("repo", "hashicorp/terraform-provider-aws")
+		v.Set("fileregex", "^custom$")
+		_, sources := applyRepoDefaults(v, gitClone(t, map[string]string{"origin": "https://github.com/hashicorp/terraform-provider-azurerm.git"}))
+		if got := v.GetString("repo"); got != "hashicorp/terraform-provider-aws" {
+			t.Errorf("repo = %q, want the one set", got)
+		}
+		if got := v.GetString("fileregex"); got != "^custom$" {
+			t.Errorf("fileregex = %q, the preset overrode the one set", got)
+		}
+		if _, ok := sources["repo"]; ok {
+			t.Errorf("sources = %v, the repo wasn't detected", sources)
+		}
+	})
+
+	t.Run("unknown repo", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		notes, sources := applyRepoDefaults(v, gitClone(t, map[string]string{"origin": "https://github.com/katbyte/tctest.git"}))
+		if v.IsSet("fileregex") || len(notes) != 1 || len(sources) != 1 {
+			t.Errorf("unknown repo applied a preset: notes %v, sources %v", notes, sources)
+		}
+		if got := serviceDirsFor("katbyte/tctest"); !slices.Equal(got, provider.ServiceDirPrefixes) {
+			t.Errorf("serviceDirsFor = %v, want every layout", got)
+		}
+	})
+}
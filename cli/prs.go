@@ -1,12 +1,16 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
+	"github.com/google/go-github/v89/github"
 	"github.com/katbyte/tctest/lib/clog"
 	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/gh"
 )
 
 func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string) error {
@@ -23,20 +27,74 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 		return err
 	}
 
-	// the direct-trigger path (--service + --all/test regex) triggers one build per service,
+	// the direct-trigger path (--service + --all/test regex) triggers one build per service build type,
 	// so enforce --max-builds-per-pr up front
-	if serviceFilter != nil && (f.RunAllTests || testRegExParam != "") && f.TC.Build.MaxBuildsPerPR > 0 && len(serviceFilter.services) > f.TC.Build.MaxBuildsPerPR {
-		return fmt.Errorf("--service would trigger %d builds per PR, exceeding --max-builds-per-pr limit of %d (use --max-builds-per-pr 0 for no limit)", len(serviceFilter.services), f.TC.Build.MaxBuildsPerPR)
+	if serviceFilter != nil && (f.RunAllTests || testRegExParam != "") && f.TC.Build.MaxBuildsPerPR > 0 {
+		if n := f.countServiceBuilds(serviceFilter.services); n > f.TC.Build.MaxBuildsPerPR {
+			return fmt.Errorf("--service would trigger %d builds per PR, exceeding --max-builds-per-pr limit of %d (use --max-builds-per-pr 0 for no limit)", n, f.TC.Build.MaxBuildsPerPR)
+		}
 	}
 
+	// the builds triggered together, for cancel --last and results --last
+	f.runID = newAuditRunID()
+
 	ok := 0
 	failed := 0
 	buildsTriggered := 0
 	buildsFailed := 0
 	servicesSkipped := 0
+	held := 0
+	interrupted := false
+prLoop:
 	for _, number := range prNumbers {
 		title := prs[number]
 
+		// fetched once for the trust policy, discovery, templated properties and the audit log
+		ghpr, err := f.NewRepo().GetPullRequest(number)
+		if err != nil {
+			cout.Errorf("  <red>ERROR:</> %v\n\n", err)
+			failed++
+			continue
+		}
+		pr := NewPullRequestDetails(ghpr)
+
+		if f.unattended {
+			trusted, reason, err := f.CheckPrTrust(ghpr)
+			if err != nil {
+				cout.Errorf("  <red>ERROR: checking PR #%d can be trusted:</> %v\n\n", number, err)
+				failed++
+				continue
+			}
+			if !trusted {
+				cout.Printf("PR <cyan>#%d</> %s <yellow>held for review:</> %s\n\n", number, title, reason)
+				f.Held = append(f.Held, number)
+				held++
+				continue
+			}
+			clog.Log.Debugf("PR #%d is trusted: %s", number, reason)
+		}
+
+		// listed once for the sensitive change check and discovery
+		files, err := f.NewRepo().ListPullRequestFiles(number)
+		if err != nil {
+			cout.Errorf("  <red>ERROR:</> %v\n\n", err)
+			failed++
+			continue
+		}
+
+		// changes to the CI setup are always shown, and need allowing when no one chose to test the PR
+		sensitive := ScanSensitiveChanges(number, files)
+		if len(sensitive) > 0 && f.unattended && !f.allowSensitive(ghpr) {
+			allow := "--allow-sensitive"
+			if f.Trust.SensitiveLabel != "" {
+				allow += " or the " + f.Trust.SensitiveLabel + " label"
+			}
+			cout.Printf("PR <cyan>#%d</> %s <yellow>held for review:</> changes %d sensitive file(s), needs %s\n\n", number, title, len(sensitive), allow)
+			f.Held = append(f.Held, number)
+			held++
+			continue
+		}
+
 		// when --service + (--all or explicit test_regex), skip discovery and trigger directly
 		if serviceFilter != nil && (f.RunAllTests || testRegExParam != "") {
 			testRegEx := testRegExParam
@@ -48,15 +106,22 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 
 			// discovery validates the PR as a side effect; here we skip discovery, so check
 			// the PR is open and mergeable before triggering builds on its merge ref
-			if err := f.CheckPrCanBuild(number); err != nil {
+			if err := CheckPrCanBuild(ghpr); err != nil {
 				cout.Errorf("  <red>ERROR:</> %v\n\n", err)
 				failed++
 				continue
 			}
 
 			for _, s := range serviceFilter.services {
-				if err := f.triggerServiceBuild(s, number, testRegEx); err != nil {
+				if f.retryServices != nil && !slices.Contains(f.retryServices, s) {
+					continue
+				}
+				if err := f.triggerServiceBuild(s, pr, testRegEx, 0, auditModeDirect); errors.Is(err, errInterrupted) {
+					interrupted = true
+					break prLoop
+				} else if err != nil {
 					buildsFailed++
+					f.FailedServices = append(f.FailedServices, s)
 				} else {
 					buildsTriggered++
 				}
@@ -66,7 +131,7 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 		}
 
 		// discover tests from PR files
-		serviceTests, err := f.GetPrTests(number, title)
+		serviceTests, err := f.GetPrTests(number, title, ghpr, files)
 		if err != nil {
 			cout.Errorf("  <red>ERROR: discovering tests:</> %v\n\n", err)
 			failed++
@@ -75,15 +140,15 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 
 		// check max-builds-per-pr limit
 		if f.TC.Build.MaxBuildsPerPR > 0 {
-			serviceCount := 0
+			var services []string
 			for s := range serviceTests {
 				if serviceFilter != nil && !serviceFilter.set[s] {
 					continue
 				}
-				serviceCount++
+				services = append(services, s)
 			}
-			if serviceCount > f.TC.Build.MaxBuildsPerPR {
-				cout.Errorf("  <red>ERROR:</> would trigger <yellow>%d</> service builds, exceeding --max-builds-per-pr limit of <yellow>%d</>\n\n", serviceCount, f.TC.Build.MaxBuildsPerPR)
+			if buildCount := f.countServiceBuilds(services); buildCount > f.TC.Build.MaxBuildsPerPR {
+				cout.Errorf("  <red>ERROR:</> would trigger <yellow>%d</> service builds, exceeding --max-builds-per-pr limit of <yellow>%d</>\n\n", buildCount, f.TC.Build.MaxBuildsPerPR)
 				failed++
 				continue
 			}
@@ -99,6 +164,10 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 				clog.Log.Debugf("  skipping service %s (not in --service filter)", s)
 				continue
 			}
+			if f.retryServices != nil && !slices.Contains(f.retryServices, s) {
+				clog.Log.Debugf("  skipping service %s (triggered before)", s)
+				continue
+			}
 
 			serviceInfo := ""
 			if s != "" {
@@ -107,24 +176,25 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 
 			// generate the test regex: --all wins, then an explicit regex, then discovered tests (+ --add-tests)
 			testRegEx := testRegExParam
+			testCount := 0
 			switch {
 			case f.RunAllTests:
 				testRegEx = "TestAcc"
 			case testRegEx == "":
-				allTests := append([]string{}, tests...)
-				allTests = append(allTests, f.AddTests...)
-
-				if len(allTests) == 0 {
+				if testRegEx = f.discoveredTestPattern(tests); testRegEx == "" {
 					cout.Errorf("  %s<red>ERROR:</> no tests found, use TestAcc or --all to run all tests\n", serviceInfo)
 					continue
 				}
-
-				testRegEx = "(" + strings.Join(allTests, "|") + ")"
+				testCount = len(tests) + len(f.AddTests)
 			}
 
-			if err := f.triggerServiceBuild(s, number, testRegEx); err != nil {
+			if err := f.triggerServiceBuild(s, pr, testRegEx, testCount, f.discoveryMode); errors.Is(err, errInterrupted) {
+				interrupted = true
+				break prLoop
+			} else if err != nil {
 				buildsFailed++
 				prFailed++
+				f.FailedServices = append(f.FailedServices, s)
 				continue
 			}
 			buildsTriggered++
@@ -146,10 +216,19 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 	if servicesSkipped > 0 {
 		cout.Printf(" <darkGray>(%d service(s) skipped by --service filter)</>", servicesSkipped)
 	}
+	if held > 0 {
+		cout.Printf(" <yellow>(%d PR(s) held for review)</>", held)
+	}
+	if interrupted {
+		cout.Printf(" <yellow>(stopped while waiting for a build slot)</>")
+	}
 	cout.Printf("\n\n")
 
 	cout.FlushJSON()
 
+	if interrupted {
+		return errInterrupted
+	}
 	if failed > 0 {
 		return fmt.Errorf("%d of %d PRs failed", failed, len(prNumbers))
 	}
@@ -160,6 +239,43 @@ func (f *FlagData) GetAndRunPrsTests(prs map[int]string, testRegExParam string)
 	return nil
 }
 
+// FilterPrs retrieves all open PRs and returns them along with the ones passing every filter.
+func (f *FlagData) FilterPrs(filters []Filter) (open, matched []github.PullRequest, err error) {
+	r := f.NewRepo()
+
+	cout.Printf("Retrieving all prs for <white>%s</>/<cyan>%s</>...", r.Owner, r.Name)
+	prs, err := r.GetAllPullRequests(gh.PRStateOpen) // todo should this return a list not map? probably
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving PRs: %w", err)
+	}
+	cout.Printf(" found <yellow>%d</>\n", len(*prs))
+
+	cout.Printf("Filtering:\n")
+	for _, pr := range *prs {
+		cout.Printf("  #<cyan>%d</> <gray>(%s)</>\n", pr.GetNumber(), pr.GetHTMLURL())
+
+		passed := true
+		for _, f := range filters {
+			ok, err := f.PR(pr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("ERROR: running filter %s: %w", f.Name, err)
+			}
+			if !ok {
+				passed = false
+				break
+			}
+		}
+
+		if passed {
+			matched = append(matched, pr)
+		}
+
+		cout.Println()
+	}
+
+	return *prs, matched, nil
+}
+
 // serviceFilterResult holds the resolved and validated service filter
 type serviceFilterResult struct {
 	services []string        // ordered list of services
@@ -175,7 +291,7 @@ func (f *FlagData) resolveServiceFilter() (*serviceFilterResult, error) {
 	ghr := f.NewRepo()
 
 	cout.Printf("Fetching service list from <cyan>%s/%s</>...\n", ghr.Owner, ghr.Name)
-	validServices, err := ghr.ListServices()
+	validServices, err := ghr.ListServices(f.DiscoveryConfig.ServiceDirs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list services: %w", err)
 	}
@@ -212,32 +328,69 @@ func (f *FlagData) resolveServiceFilter() (*serviceFilterResult, error) {
 	return &serviceFilterResult{services: services, set: set}, nil
 }
 
-// triggerServiceBuild triggers a build for a single service on a PR
-func (f *FlagData) triggerServiceBuild(service string, prNumber int, testRegEx string) error {
-	serviceInfo := ""
-	if service != "" {
-		serviceInfo = "[" + service + "]"
-	}
+// TriggeredBuild records a build triggered by this run, for commands that keep state about them.
+type TriggeredBuild struct {
+	PR          int    `json:"pr"`
+	Service     string `json:"service"`
+	Matrix      string `json:"matrix,omitempty"`
+	BuildTypeID string `json:"build_type_id"`
+	BuildID     int    `json:"build_id"`
+	URL         string `json:"url"`
+}
 
-	buildTypeID := f.TC.Build.TypeID
-	if service != "" && f.TC.Build.AddServiceSuffix {
-		buildTypeID += "_" + strings.ToUpper(service)
-	}
+// prBranch returns the TeamCity branch a PR's builds run on, its merge ref.
+func prBranch(pr int) string {
+	return fmt.Sprintf("refs/pull/%d/merge", pr)
+}
 
-	branch := fmt.Sprintf("refs/pull/%d/merge", prNumber)
+// triggerServiceBuild triggers the build(s) for a single service on a PR, one per build type ID the service maps to
+// and --matrix cell
+func (f *FlagData) triggerServiceBuild(service string, pr *PullRequestDetails, testRegEx string, testCount int, mode string) error {
+	prNumber := pr.Number
+	branch := prBranch(prNumber)
+
+	var errs []error
+	for _, cell := range f.matrixCells() {
+		for _, buildTypeID := range f.serviceBuildTypeIDs(service) {
+			spec := f.newBuildSpec(buildTypeID, branch, testRegEx, service)
+			spec.PR = pr
+			spec.TestCount = testCount
+			spec.Mode = mode
+			f.applyServiceConfig(&spec)
+			applyMatrixCell(&spec, cell)
+
+			if err := f.waitForSlot(service); err != nil {
+				return errors.Join(append(errs, err)...)
+			}
 
-	buildID, buildURL, err := f.BuildCmd(buildTypeID, branch, testRegEx, serviceInfo)
-	if err != nil {
-		cout.Errorf("  <red>ERROR: Unable to trigger build:</> %v\n", err)
-		cout.Println()
-		return err
-	}
+			buildID, buildURL, err := f.BuildCmd(spec)
+			if err != nil {
+				cout.Errorf("  <red>ERROR: Unable to trigger build:</> %v\n", err)
+				cout.Println()
+				errs = append(errs, err)
+				continue
+			}
 
-	// dry-run triggers nothing, so don't emit machine-readable records for it
-	if !f.DryRun {
-		cout.Quietf("%d@%s@%d %s\n", prNumber, service, buildID, buildURL)
-		cout.AddResult(prNumber, service, buildID, buildURL)
+			// dry-run triggers nothing, so don't emit machine-readable records for it
+			if !f.DryRun {
+				if len(cell) > 0 {
+					cout.Quietf("%d@%s@%d %s %s\n", prNumber, service, buildID, buildURL, cell)
+				} else {
+					cout.Quietf("%d@%s@%d %s\n", prNumber, service, buildID, buildURL)
+				}
+				cout.AddResult(prNumber, service, cell.String(), buildID, buildURL)
+				f.Triggered = append(f.Triggered, TriggeredBuild{
+					PR:          prNumber,
+					Service:     service,
+					Matrix:      cell.String(),
+					BuildTypeID: buildTypeID,
+					BuildID:     buildID,
+					URL:         buildURL,
+				})
+			}
+			cout.Println()
+		}
 	}
-	cout.Println()
-	return nil
+
+	return errors.Join(errs...)
 }
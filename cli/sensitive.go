@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/cout"
+)
+
+// SensitiveChange is a changed file that can alter what CI runs with its credentials, rather than what is tested.
+type SensitiveChange struct {
+	Path   string
+	Reason string
+}
+
+// sensitivePaths are the directories and files that make up the CI setup itself.
+var sensitivePaths = []struct {
+	match  string // a directory prefix when it ends in /, otherwise a file name
+	reason string
+}{
+	{".teamcity/", "TeamCity build configuration"},
+	{".github/", "GitHub Actions and repo configuration"},
+	{"scripts/", "scripts run by the build"},
+	{"GNUmakefile", "make targets run by the build"},
+}
+
+var (
+	// replace directives are the only go.mod lines with =>, whether on their own or in a replace block
+	goModReplaceRe = regexp.MustCompile(`^[+-]\s*(replace\b|.*=>)`)
+	testMainRe     = regexp.MustCompile(`^[+-].*\bfunc TestMain\(`)
+	sweeperRe      = regexp.MustCompile(`^[+-].*\b(AddTestSweepers|TestSweepers?)\b`)
+)
+
+// FindSensitiveChanges returns the files in a PR that change the CI setup: build configuration, scripts, go.mod
+// replace directives, and the TestMain and sweeper code that runs around the tests. A go.mod or Go file without a
+// patch (too large for GitHub to diff) is reported as it can't be checked.
+func FindSensitiveChanges(files []*github.CommitFile) []SensitiveChange {
+	var changes []SensitiveChange
+	for _, file := range files {
+		name := file.GetFilename()
+		if reason := sensitiveChangeReason(name, file.GetPatch(), patchOmitted(file)); reason != "" {
+			changes = append(changes, SensitiveChange{Path: name, Reason: reason})
+		}
+	}
+	return changes
+}
+
+// patchOmitted returns true if GitHub didn't include the diff of a changed file. Removed files can't add anything,
+// and renames without changes have nothing to diff.
+func patchOmitted(file *github.CommitFile) bool {
+	if file.GetPatch() != "" || file.GetStatus() == "removed" {
+		return false
+	}
+	return file.GetStatus() != "renamed" || file.GetChanges() > 0
+}
+
+func sensitiveChangeReason(name, patch string, omitted bool) string {
+	for _, p := range sensitivePaths {
+		dir := strings.HasSuffix(p.match, "/")
+		if (dir && strings.HasPrefix(name, p.match)) || (!dir && path.Base(name) == p.match) {
+			return p.reason
+		}
+	}
+
+	lines := strings.Split(patch, "\n")
+	matches := func(re *regexp.Regexp) bool {
+		return slices.ContainsFunc(lines, re.MatchString)
+	}
+
+	switch {
+	case path.Base(name) == "go.mod":
+		if omitted {
+			return "go.mod changed, but GitHub has no diff to check for replace directives"
+		}
+		if matches(goModReplaceRe) {
+			return "go.mod replace directive"
+		}
+	case !strings.HasSuffix(name, ".go"):
+	case slices.Contains(strings.Split(path.Dir(name), "/"), "sweep") || strings.Contains(path.Base(name), "sweep"):
+		return "sweeper code, which deletes cloud resources"
+	case omitted:
+		return "changed, but GitHub has no diff to check for TestMain or sweepers"
+	case matches(testMainRe):
+		return "TestMain, which runs before every test in the package"
+	case matches(sweeperRe):
+		return "sweeper code, which deletes cloud resources"
+	}
+
+	return ""
+}
+
+// ScanSensitiveChanges prints the files of a PR's changed files that change the CI setup.
+func ScanSensitiveChanges(number int, files []*github.CommitFile) []SensitiveChange {
+	changes := FindSensitiveChanges(files)
+	if len(changes) > 0 {
+		cout.Printf("PR <cyan>#%d</> <red>changes %d file(s) that can alter what CI runs:</>\n", number, len(changes))
+		for _, c := range changes {
+			cout.Printf("  <red>SENSITIVE</> %s <darkGray>(%s)</>\n", c.Path, c.Reason)
+		}
+	}
+	return changes
+}
+
+// allowSensitive returns true if builds may be triggered for a PR with sensitive changes without a person choosing
+// to: with --allow-sensitive, or when the PR carries --allow-sensitive-label.
+func (f *FlagData) allowSensitive(pr *github.PullRequest) bool {
+	if f.Trust.AllowSensitive {
+		return true
+	}
+	label := f.Trust.SensitiveLabel
+	return label != "" && slices.ContainsFunc(pr.Labels, func(l *github.Label) bool { return l.GetName() == label })
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v89/github"
+)
+
+func TestFindSensitiveChanges(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		file      string
+		patch     string
+		status    string
+		sensitive bool
+	}{
+		{"teamcity config", ".teamcity/components/settings.kt", "", "", true},
+		{"github workflow", ".github/workflows/test.yaml", "", "", true},
+		{"script", "scripts/run-gradually-deprecated.sh", "", "", true},
+		{"makefile", "GNUmakefile", "", "", true},
+		{"other makefile-like file", "GNUmakefile.bak/readme.md", "", "", false},
+		{"resource", "internal/services/dns/dns_a_record_resource.go", "+\treturn nil", "", false},
+		{"docs", "website/docs/r/dns_a_record.html.markdown", "+replace me", "", false},
+		{"go.mod require", "go.mod", "@@ -1,3 +1,3 @@\n-\tgithub.com/foo/bar v1.0.0\n+\tgithub.com/foo/bar v1.1.0", "", false},
+		{"go.mod replace", "go.mod", "@@ -1,3 +1,4 @@\n+replace github.com/foo/bar => github.com/evil/bar v1.0.0", "", true},
+		{"go.mod replace block", "go.mod", "@@ -10,3 +10,4 @@\n replace (\n+\tgithub.com/foo/bar => ../bar\n )", "", true},
+		{"go.mod replace context only", "go.mod", "@@ -10,3 +10,4 @@\n replace github.com/foo/bar => ../bar\n+// comment", "", false},
+		{"go.mod without patch", "go.mod", "", "", true},
+		{"TestMain added", "internal/services/dns/dns_test.go", "+func TestMain(m *testing.M) {", "", true},
+		{"TestMain context only", "internal/services/dns/dns_test.go", " func TestMain(m *testing.M) {\n+\t// comment", "", false},
+		{"sweeper registration", "internal/services/dns/dns_test.go", "+\tresource.AddTestSweepers(\"dns\", &resource.Sweeper{", "", true},
+		{"sweeper file", "internal/services/dns/dns_zone_sweeper.go", "", "", true},
+		{"sweep package", "internal/sweep/awsv2/register.go", "", "", true},
+		{"go file without patch", "internal/services/dns/dns_test.go", "", "modified", true},
+		{"removed go file without patch", "internal/services/dns/dns_test.go", "", "removed", false},
+		{"go file renamed without changes", "internal/services/dns/dns_helpers.go", "", "renamed", false},
+		{"removed go.mod", "go.mod", "", "removed", false},
+		{"docs without patch", "website/docs/r/dns_a_record.html.markdown", "", "modified", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			changes := FindSensitiveChanges([]*github.CommitFile{{Filename: new(tc.file), Patch: new(tc.patch), Status: new(tc.status)}})
+			if got := len(changes) > 0; got != tc.sensitive {
+				t.Errorf("sensitive = %t (%v), want %t", got, changes, tc.sensitive)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+	"github.com/katbyte/tctest/lib/cout"
+)
+
+// WebhookRules decide which GitHub events trigger a discovered test run.
+type WebhookRules struct {
+	TriggerLabels  []string // adding one of these labels to a PR triggers a run
+	RetestOnPush   bool     // new commits on a PR carrying a trigger label trigger a run
+	CommentCommand string   // a PR comment starting with this triggers a run
+}
+
+// WebhookRun is called for every PR a label or push event triggers, WebhookComment for every command comment and
+// WebhookPush for every push to a PR, before any run it triggers. The server serialises calls, so they can use the
+// (not goroutine safe) FlagData and output helpers.
+type (
+	WebhookRun     func(pr int, title string) error
+	WebhookComment func(pr int, title string, comment *github.IssueComment) error
+	WebhookPush    func(pr *github.PullRequest) error
+)
+
+// WebhookServer receives GitHub pull_request and issue_comment webhook events and triggers test runs for them.
+type WebhookServer struct {
+	Secret  []byte
+	Repo    string // the owner/repo events must come from, as the runs trigger builds for its PRs
+	Rules   WebhookRules
+	Run     WebhookRun
+	Comment WebhookComment
+	Push    WebhookPush
+
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+func (f *FlagData) NewWebhookServer() *WebhookServer {
+	// events pick the PRs to test, so PRs are held for review like they are for prs
+	f.unattended = true
+
+	return &WebhookServer{
+		Secret: []byte(f.Serve.WebhookSecret),
+		Repo:   f.GH.Repo,
+		Rules: WebhookRules{
+			TriggerLabels:  f.Serve.TriggerLabels,
+			RetestOnPush:   f.Serve.RetestOnPush,
+			CommentCommand: f.Comments.Command,
+		},
+		Run: func(pr int, title string) error {
+			f.Triggered = nil
+			f.Held = nil
+			return f.GetAndRunPrsTests(map[int]string{pr: title}, "")
+		},
+		Comment: f.HandleCommentCommand,
+		// the label only vouches for the commits that were reviewed
+		Push: f.revokeSafeToTest,
+	}
+}
+
+// ServeCmd runs the webhook receiver until the process is stopped.
+func (f *FlagData) ServeCmd() error {
+	if !f.Serve.Webhooks {
+		return errors.New("nothing to serve, use --webhooks to receive GitHub webhook events")
+	}
+	if f.Serve.WebhookSecret == "" {
+		return errors.New("--webhook-secret is required so webhook signatures can be verified")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks", f.NewWebhookServer())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+
+	cout.Printf("listening for GitHub webhooks on <cyan>%s/webhooks</>\n", f.Serve.Listen)
+	srv := &http.Server{
+		Addr:              f.Serve.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+func (s *WebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := github.ValidatePayload(r, s.Secret)
+	if err != nil {
+		clog.Log.Debugf("rejected webhook: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to parse webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := event.(*github.PingEvent); ok {
+		_, _ = w.Write([]byte("pong\n"))
+		return
+	}
+
+	// another repo's webhook sharing the secret must not trigger builds for the PR of the same number here
+	if repo := eventRepo(event); !strings.EqualFold(repo, s.Repo) {
+		clog.Log.Debugf("rejected webhook from %q, expected %s", repo, s.Repo)
+		http.Error(w, fmt.Sprintf("event is for repository %q, not %s", repo, s.Repo), http.StatusForbidden)
+		return
+	}
+
+	var pushed *github.PullRequest
+	if e, ok := event.(*github.PullRequestEvent); ok && e.GetAction() == "synchronize" && s.Push != nil {
+		pushed = e.GetPullRequest()
+	}
+
+	job, reason := s.Rules.match(event)
+	if job.pr == 0 && pushed == nil {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprintf(w, "ignored: %s\n", reason)
+		return
+	}
+
+	// discovery and triggering take far longer than GitHub waits for a webhook response
+	s.wg.Go(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// handled before the run, so the run sees the PR as it is after the push
+		if pushed != nil {
+			if err := s.Push(pushed); err != nil {
+				cout.Errorf("<red>ERROR:</> webhook push to PR #%d: %v\n", pushed.GetNumber(), err)
+			}
+		}
+		if job.pr == 0 {
+			return
+		}
+
+		cout.Printf("webhook: PR <cyan>#%d</> %s\n", job.pr, reason)
+		var err error
+		if job.comment != nil {
+			err = s.Comment(job.pr, job.title, job.comment)
+		} else {
+			err = s.Run(job.pr, job.title)
+		}
+		if err != nil {
+			cout.Errorf("<red>ERROR:</> webhook run for PR #%d: %v\n", job.pr, err)
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	if job.pr == 0 {
+		_, _ = fmt.Fprintf(w, "ignored: %s\n", reason)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "triggering PR #%d: %s\n", job.pr, reason)
+}
+
+// webhookJob is the work an event triggers, a zero pr means nothing.
+type webhookJob struct {
+	pr      int
+	title   string
+	comment *github.IssueComment // set for comment commands
+}
+
+// Wait blocks until every triggered run has finished.
+func (s *WebhookServer) Wait() {
+	s.wg.Wait()
+}
+
+// match returns the job an event should trigger, with the reason either way.
+func (rules WebhookRules) match(event any) (job webhookJob, reason string) {
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		p := e.GetPullRequest()
+		if p.GetState() != "open" {
+			return webhookJob{}, "pull request is not open"
+		}
+
+		switch e.GetAction() {
+		case "labeled":
+			if label := e.GetLabel().GetName(); slices.Contains(rules.TriggerLabels, label) {
+				return webhookJob{pr: p.GetNumber(), title: p.GetTitle()}, "labelled " + label
+			}
+			return webhookJob{}, "label is not a trigger label"
+		case "synchronize":
+			if !rules.RetestOnPush {
+				return webhookJob{}, "retesting on push is disabled"
+			}
+			for _, l := range p.Labels {
+				if slices.Contains(rules.TriggerLabels, l.GetName()) {
+					return webhookJob{pr: p.GetNumber(), title: p.GetTitle()}, "new commits on a PR labelled " + l.GetName()
+				}
+			}
+			return webhookJob{}, "pull request has no trigger label"
+		}
+		return webhookJob{}, "pull_request action " + e.GetAction() + " is not handled"
+
+	case *github.IssueCommentEvent:
+		if e.GetAction() != "created" {
+			return webhookJob{}, "issue_comment action " + e.GetAction() + " is not handled"
+		}
+		if !e.GetIssue().IsPullRequest() {
+			return webhookJob{}, "comment is not on a pull request"
+		}
+		if rules.CommentCommand == "" || !isCommand(e.GetComment().GetBody(), rules.CommentCommand) {
+			return webhookJob{}, "comment is not a " + rules.CommentCommand + " command"
+		}
+		return webhookJob{pr: e.GetIssue().GetNumber(), title: e.GetIssue().GetTitle(), comment: e.GetComment()}, "commented " + rules.CommentCommand
+	}
+
+	return webhookJob{}, fmt.Sprintf("event %T is not handled", event)
+}
+
+// eventRepo returns the owner/repo an event was delivered for, "" for events without one.
+func eventRepo(event any) string {
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok {
+		return e.GetRepo().GetFullName()
+	}
+	return ""
+}
+
+// isCommand returns true if the first line of the comment is the command, optionally followed by arguments.
+func isCommand(body, command string) bool {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	fields := strings.Fields(line)
+	return len(fields) > 0 && fields[0] == command
+}
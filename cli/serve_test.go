@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v89/github"
+)
+
+const testWebhookSecret = "s3cret"
+
+func newTestWebhookServer() (*WebhookServer, *[]int) {
+	var mu sync.Mutex
+	var ran []int
+
+	record := func(pr int) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, pr)
+	}
+
+	s := &WebhookServer{
+		Secret: []byte(testWebhookSecret),
+		Repo:   "hashicorp/terraform-provider-azurerm",
+		Rules: WebhookRules{
+			TriggerLabels:  []string{"acc-test"},
+			RetestOnPush:   true,
+			CommentCommand: "/tctest",
+		},
+		Run: func(pr int, _ string) error {
+			record(pr)
+			return nil
+		},
+		Comment: func(pr int, _ string, c *github.IssueComment) error {
+			if c.GetID() != 99001 {
+				return fmt.Errorf("unexpected comment %d", c.GetID())
+			}
+			record(pr)
+			return nil
+		},
+	}
+	return s, &ran
+}
+
+func webhookRequest(t *testing.T, event, fixture, secret string) *http.Request {
+	t.Helper()
+
+	payload, err := os.ReadFile(filepath.Join("testdata", "webhooks", fixture))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	r := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/webhooks", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestWebhookServer(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		event   string
+		fixture string
+		secret  string
+		status  int
+		wantRun bool
+	}{
+		{"trigger label", "pull_request", "pull_request_labeled.json", testWebhookSecret, http.StatusAccepted, true},
+		{"other label", "pull_request", "pull_request_labeled_other.json", testWebhookSecret, http.StatusAccepted, false},
+		{"push to labelled pr", "pull_request", "pull_request_synchronize.json", testWebhookSecret, http.StatusAccepted, true},
+		{"push to unlabelled pr", "pull_request", "pull_request_synchronize_unlabelled.json", testWebhookSecret, http.StatusAccepted, false},
+		{"comment command on pr", "issue_comment", "issue_comment_created.json", testWebhookSecret, http.StatusAccepted, true},
+		{"comment command on issue", "issue_comment", "issue_comment_on_issue.json", testWebhookSecret, http.StatusAccepted, false},
+		{"ping", "ping", "ping.json", testWebhookSecret, http.StatusOK, false},
+		{"bad signature", "pull_request", "pull_request_labeled.json", "wrong", http.StatusUnauthorized, false},
+		{"trigger label in another repo", "pull_request", "pull_request_labeled_other_repo.json", testWebhookSecret, http.StatusForbidden, false},
+		{"comment command in another repo", "issue_comment", "issue_comment_created_other_repo.json", testWebhookSecret, http.StatusForbidden, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, ran := newTestWebhookServer()
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, webhookRequest(t, tt.event, tt.fixture, tt.secret))
+			s.Wait()
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body: %s)", w.Code, tt.status, w.Body.String())
+			}
+
+			want := []int(nil)
+			if tt.wantRun {
+				want = []int{1234}
+			}
+			if len(*ran) != len(want) || (len(want) > 0 && (*ran)[0] != want[0]) {
+				t.Errorf("runs = %v, want %v (body: %s)", *ran, want, w.Body.String())
+			}
+		})
+	}
+}
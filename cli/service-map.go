@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ServiceConfig holds the per-service overrides for TeamCity projects whose build configurations don't follow
+// the TYPEID_SERVICE convention, or that need extra properties, tags, or timeouts for some services.
+type ServiceConfig struct {
+	BuildTypeIDs []string          `yaml:"build-type-ids"`
+	Properties   map[string]string `yaml:"properties"`
+	QueueTimeout int               `yaml:"queue-timeout"`
+	RunTimeout   int               `yaml:"run-timeout"`
+	Tags         []string          `yaml:"tags"`
+	SmokeTests   []string          `yaml:"smoke-tests"` // run instead of the traced tests when tracing reaches too many resources
+}
+
+// ServiceMap maps a service name (the directory under internal/service(s)/) to its overrides.
+type ServiceMap map[string]ServiceConfig
+
+// loadedServiceMap and loadedSmokeSuites are the --service-map loaded once in PersistentPreRunE, GetFlags uses them.
+var (
+	loadedServiceMap  ServiceMap
+	loadedSmokeSuites SmokeSuites
+)
+
+type serviceMapFile struct {
+	Services ServiceMap  `yaml:"services"`
+	Smoke    SmokeSuites `yaml:"smoke"`
+}
+
+// LoadServiceMap reads a service mapping file. YAML is a superset of JSON so both formats are parsed the same way:
+//
+//	services:
+//	  network:
+//	    build-type-ids: [TF_NETWORK_A, TF_NETWORK_B]
+//	    properties:
+//	      ARM_TEST_LOCATION: westeurope
+//	    tags: [network]
+//	    run-timeout: 240
+//	    smoke-tests: [TestAccVirtualNetwork_basic]
+//	smoke:
+//	  internal/provider/**: [network:TestAccVirtualNetwork_basic]
+//
+// It returns the services and the smoke suites, see SmokeSuites.
+func LoadServiceMap(path string) (ServiceMap, SmokeSuites, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // path is from the user-provided --service-map flag
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading service map %s: %w", path, err)
+	}
+
+	var smf serviceMapFile
+	if err := yaml.Unmarshal(b, &smf); err != nil {
+		return nil, nil, fmt.Errorf("parsing service map %s: %w", path, err)
+	}
+
+	if err := validateServiceMap(smf.Services); err != nil {
+		return nil, nil, fmt.Errorf("service map %s: %w", path, err)
+	}
+	if err := validateSmokeSuites(smf.Smoke); err != nil {
+		return nil, nil, fmt.Errorf("service map %s: %w", path, err)
+	}
+
+	return smf.Services, smf.Smoke, nil
+}
+
+// validateServiceMap checks the build type IDs and property templates of a service map.
+func validateServiceMap(sm ServiceMap) error {
+	for service, sc := range sm {
+		for _, id := range sc.BuildTypeIDs {
+			if strings.TrimSpace(id) == "" {
+				return fmt.Errorf("service %q has an empty build type id", service)
+			}
+		}
+		for _, t := range sc.SmokeTests {
+			if strings.TrimSpace(t) == "" {
+				return fmt.Errorf("service %q has an empty smoke test", service)
+			}
+			if _, err := regexp.Compile(t); err != nil {
+				return fmt.Errorf("service %q smoke test %q is not a valid regex: %w", service, t, err)
+			}
+		}
+		for k, v := range sc.Properties {
+			if k == "" || strings.ContainsAny(k, "=;") {
+				return fmt.Errorf("service %q has an invalid property name %q", service, k)
+			}
+			if err := validatePropertyTemplates(k + "=" + v); err != nil {
+				return fmt.Errorf("service %q: %w", service, err)
+			}
+		}
+	}
+	return nil
+}
+
+// serviceBuildTypeIDs returns the build type IDs to trigger for a service: the mapped IDs when the service map
+// has any, otherwise the build type ID with the optional _SERVICE suffix.
+func (f *FlagData) serviceBuildTypeIDs(service string) []string {
+	if sc, ok := f.TC.Build.ServiceMap[service]; ok && len(sc.BuildTypeIDs) > 0 {
+		return sc.BuildTypeIDs
+	}
+
+	buildTypeID := f.TC.Build.TypeID
+	if service != "" && f.TC.Build.AddServiceSuffix {
+		buildTypeID += "_" + strings.ToUpper(service)
+	}
+
+	return []string{buildTypeID}
+}
+
+// countServiceBuilds returns how many builds triggering the given services would queue, including every
+// --matrix cell.
+func (f *FlagData) countServiceBuilds(services []string) int {
+	n := 0
+	for _, s := range services {
+		n += len(f.serviceBuildTypeIDs(s))
+	}
+	return n * len(f.matrixCells())
+}
+
+// applyServiceConfig layers a service's mapped properties, tags and timeouts on top of the build spec.
+func (f *FlagData) applyServiceConfig(spec *BuildSpec) {
+	sc, ok := f.TC.Build.ServiceMap[spec.Service]
+	if !ok {
+		return
+	}
+
+	// sorted so the properties sent to TeamCity (and shown in --dry-run) are deterministic
+	for _, k := range slices.Sorted(maps.Keys(sc.Properties)) {
+		if spec.Properties != "" {
+			spec.Properties += ";"
+		}
+		spec.Properties += k + "=" + sc.Properties[k]
+	}
+
+	spec.Tags = append(spec.Tags, sc.Tags...)
+
+	if sc.QueueTimeout > 0 {
+		spec.QueueTimeout = sc.QueueTimeout
+	}
+	if sc.RunTimeout > 0 {
+		spec.RunTimeout = sc.RunTimeout
+	}
+}
+
+// resultsBuildTypeIDs returns the build type IDs `results pr` should look in: the IDs for each --service when
+// set, otherwise the base build type ID plus every ID in the service map.
+func (f *FlagData) resultsBuildTypeIDs() []string {
+	var ids []string
+	if len(f.Services) > 0 {
+		for _, s := range f.Services {
+			ids = append(ids, f.serviceBuildTypeIDs(s)...)
+		}
+	} else {
+		ids = append(ids, f.TC.Build.TypeID)
+		for _, s := range slices.Sorted(maps.Keys(f.TC.Build.ServiceMap)) {
+			ids = append(ids, f.TC.Build.ServiceMap[s].BuildTypeIDs...)
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadServiceMap(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		contents string
+		err      string
+		services []string
+		smoke    int
+	}{
+		{
+			name: "yaml",
+			contents: `services:
+  network:
+    build-type-ids: [TF_NETWORK_A, TF_NETWORK_B]
+    properties:
+      ARM_TEST_LOCATION: westeurope
+    tags: [network]
+    run-timeout: 240
+    smoke-tests: [TestAccVirtualNetwork_basic]
+  dns:
+    tags: [dns]
+smoke:
+  internal/provider/**: [network:TestAccVirtualNetwork_basic]
+`,
+			services: []string{"dns", "network"},
+			smoke:    1,
+		},
+		{
+			name:     "json",
+			contents: `{"services": {"network": {"build-type-ids": ["TF_NETWORK"]}}}`,
+			services: []string{"network"},
+		},
+		{name: "invalid yaml", contents: "services: [", err: "parsing service map"},
+		{name: "empty build type id", contents: "services:\n  network:\n    build-type-ids: ['']\n", err: `service "network" has an empty build type id`},
+		{name: "invalid property name", contents: "services:\n  network:\n    properties:\n      'A;B': x\n", err: `invalid property name "A;B"`},
+		{name: "invalid property template", contents: "services:\n  network:\n    properties:\n      A: '{{.PR'\n", err: `service "network"`},
+		{name: "invalid smoke test", contents: "services:\n  network:\n    smoke-tests: ['TestAcc(']\n", err: "is not a valid regex"},
+		{name: "invalid smoke suite", contents: "smoke:\n  main.go: [TestAccResourceGroup_basic]\n", err: "must be service:test"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "services.yaml")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			sm, smoke, err := LoadServiceMap(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := slices.Sorted(maps.Keys(sm)); !slices.Equal(got, tt.services) {
+				t.Errorf("services = %v, want %v", got, tt.services)
+			}
+			if len(smoke) != tt.smoke {
+				t.Errorf("%d smoke suites, want %d", len(smoke), tt.smoke)
+			}
+		})
+	}
+
+	if _, _, err := LoadServiceMap(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "reading service map") {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+func TestServiceBuildTypeIDs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		service string
+		suffix  bool
+		want    []string
+	}{
+		{name: "mapped", service: "network", want: []string{"TF_NETWORK_A", "TF_NETWORK_B"}},
+		{name: "mapped ignores the suffix", service: "network", suffix: true, want: []string{"TF_NETWORK_A", "TF_NETWORK_B"}},
+		{name: "mapped without ids", service: "dns", want: []string{"TF_ACC"}},
+		{name: "unmapped", service: "compute", want: []string{"TF_ACC"}},
+		{name: "unmapped with the suffix", service: "compute", suffix: true, want: []string{"TF_ACC_COMPUTE"}},
+		{name: "no service", suffix: true, want: []string{"TF_ACC"}},
+	}
+
+	for _, tt := range cases {
+		f := FlagData{}
+		f.TC.Build.TypeID = "TF_ACC"
+		f.TC.Build.AddServiceSuffix = tt.suffix
+		f.TC.Build.ServiceMap = ServiceMap{
+			"network": {BuildTypeIDs: []string{"TF_NETWORK_A", "TF_NETWORK_B"}},
+			"dns":     {Tags: []string{"dns"}},
+		}
+
+		if got := f.serviceBuildTypeIDs(tt.service); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: serviceBuildTypeIDs(%q) = %v, want %v", tt.name, tt.service, got, tt.want)
+		}
+	}
+}
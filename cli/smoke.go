@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/katbyte/tctest/lib/provider"
+)
+
+// SmokeSuites maps path globs of files outside the service packages to the service:test patterns to run when a PR
+// changes one of them, as nothing can be traced from e.g. the provider registration or the acceptance framework:
+//
+//	smoke:
+//	  internal/provider/**: [network:TestAccVirtualNetwork_basic, resource:TestAccResourceGroup_basic]
+//	  main.go: [resource:TestAccResourceGroup_basic]
+//
+// * matches within a path segment and ** across them.
+type SmokeSuites map[string][]string
+
+// validateSmokeSuites checks every glob compiles and every entry is a service qualified test regex.
+func validateSmokeSuites(s SmokeSuites) error {
+	for glob, entries := range s {
+		if strings.TrimSpace(glob) == "" {
+			return errors.New("smoke suite has an empty glob")
+		}
+		if _, err := globRegexp(glob); err != nil {
+			return fmt.Errorf("smoke suite %q: %w", glob, err)
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("smoke suite %q has no tests", glob)
+		}
+		for _, e := range entries {
+			service, test, ok := strings.Cut(e, ":")
+			if !ok || strings.TrimSpace(service) == "" || strings.TrimSpace(test) == "" {
+				return fmt.Errorf("smoke suite %q entry %q must be service:test", glob, e)
+			}
+			if _, err := regexp.Compile(test); err != nil {
+				return fmt.Errorf("smoke suite %q test %q is not a valid regex: %w", glob, test, err)
+			}
+		}
+	}
+	return nil
+}
+
+// globRegexp compiles a path glob: ** matches any number of path segments, * and ? within one.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// globs returns the globs matching a changed file, none for the service packages and vendor as those are traced.
+func (s SmokeSuites) globs(relPath string) []string {
+	pf := provider.NewFile(relPath)
+	if pf.InServicePackage() || strings.HasPrefix(relPath, "vendor/") {
+		return nil
+	}
+
+	var matched []string
+	for _, glob := range slices.Sorted(maps.Keys(s)) {
+		if re, err := globRegexp(glob); err == nil && re.MatchString(relPath) {
+			matched = append(matched, glob)
+		}
+	}
+	return matched
+}
+
+// Matches returns whether a changed file runs a smoke suite.
+func (s SmokeSuites) Matches(relPath string) bool {
+	return len(s.globs(relPath)) > 0
+}
+
+// Tests returns the tests of each service the smoke suites run for the changed files, and why each was selected.
+func (s SmokeSuites) Tests(changed []string) (map[string][]string, map[string][]provider.Chain) {
+	tests := map[string][]string{}
+	testChains := map[string][]provider.Chain{}
+	for _, relPath := range changed {
+		for _, glob := range s.globs(relPath) {
+			chain := provider.Chain{{Kind: provider.LinkChangedFile, Value: relPath}}.Then(provider.LinkSmokeSuite, glob)
+			for _, e := range s[glob] {
+				service, test, _ := strings.Cut(e, ":")
+				if !slices.Contains(tests[service], test) {
+					tests[service] = append(tests[service], test)
+				}
+				testChains[test] = append(testChains[test], chain.Then(provider.LinkTest, test))
+			}
+		}
+	}
+	for service := range tests {
+		slices.Sort(tests[service])
+	}
+	return tests, testChains
+}
+
+// mergeSmokeTests adds the smoke tests of each service to the discovered ones.
+func mergeSmokeTests(serviceTests, smoke map[string][]string) {
+	for service, tests := range smoke {
+		serviceTests[service] = slices.Compact(slices.Sorted(slices.Values(slices.Concat(serviceTests[service], tests))))
+	}
+}
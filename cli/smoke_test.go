@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSmokeSuitesTests(t *testing.T) {
+	t.Parallel()
+
+	s := SmokeSuites{
+		"internal/provider/**": {"network:TestAccVirtualNetwork_basic", "resource:TestAccResourceGroup_basic"},
+		"main.go":              {"resource:TestAccResourceGroup_basic"},
+		"internal/*/README.md": {"network:TestAccSubnet_basic"},
+		"**/*.md":              {"dns:TestAccDnsZone_basic"},
+	}
+
+	cases := []struct {
+		name    string
+		changed []string
+		want    map[string][]string
+		chains  int
+	}{
+		{
+			name:    "** matches across path segments",
+			changed: []string{"internal/provider/framework/config.go"},
+			want:    map[string][]string{"network": {"TestAccVirtualNetwork_basic"}, "resource": {"TestAccResourceGroup_basic"}},
+			chains:  2,
+		},
+		{
+			name:    "tests selected by several files have a chain from each",
+			changed: []string{"internal/provider/provider.go", "main.go"},
+			want:    map[string][]string{"network": {"TestAccVirtualNetwork_basic"}, "resource": {"TestAccResourceGroup_basic"}},
+			chains:  3,
+		},
+		{
+			name:    "* stays within a path segment",
+			changed: []string{"internal/acceptance/README.md", "internal/acceptance/check/README.md"},
+			want:    map[string][]string{"network": {"TestAccSubnet_basic"}, "dns": {"TestAccDnsZone_basic"}},
+			chains:  3,
+		},
+		{
+			name:    "service packages and vendor are traced rather than smoke tested",
+			changed: []string{"internal/services/network/README.md", "vendor/github.com/foo/README.md", "cmd/main.go"},
+			want:    map[string][]string{},
+		},
+	}
+
+	for _, tt := range cases {
+		got, chains := s.Tests(tt.changed)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Tests = %v, want %v", tt.name, got, tt.want)
+		}
+		n := 0
+		for _, c := range chains {
+			n += len(c)
+		}
+		if n != tt.chains {
+			t.Errorf("%s: %d chains, want %d: %v", tt.name, n, tt.chains, chains)
+		}
+	}
+}
+
+func TestValidateSmokeSuites(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    SmokeSuites
+		err  string
+	}{
+		{name: "valid", s: SmokeSuites{"internal/provider/**": {"network:TestAccVirtualNetwork_(basic|complete)"}}},
+		{name: "no tests", s: SmokeSuites{"main.go": nil}, err: "has no tests"},
+		{name: "unqualified test", s: SmokeSuites{"main.go": {"TestAccResourceGroup_basic"}}, err: "must be service:test"},
+		{name: "invalid regex", s: SmokeSuites{"main.go": {"resource:TestAcc("}}, err: "is not a valid regex"},
+	}
+
+	for _, tt := range cases {
+		err := validateSmokeSuites(tt.s)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
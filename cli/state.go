@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stateDir returns the directory tctest keeps local state in, creating it if needed: --state-dir when set,
+// otherwise ~/.tctest.d (~/.tctest is already taken by the config file).
+func (f *FlagData) stateDir() (string, error) {
+	dir := f.StateDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("finding home directory for the state dir (set --state-dir instead): %w", err)
+		}
+		dir = filepath.Join(home, ".tctest.d")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("creating state dir %s: %w", dir, err)
+	}
+
+	return dir, nil
+}
+
+// readJSONState reads a JSON state file into v, leaving v untouched if the file doesn't exist yet.
+func readJSONState(path string, v any) error {
+	b, err := os.ReadFile(path) //nolint:gosec // path is within the state dir
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state file %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONState writes v to a JSON state file via a temporary file, so an interrupted write can't corrupt it.
+func writeJSONState(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encoding state file %s: %w", path, err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("writing state file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing state file %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,21 +1,37 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/katbyte/tctest/lib/clog"
 	"github.com/katbyte/tctest/lib/cout"
+	"github.com/katbyte/tctest/lib/tc"
 	"github.com/pkg/browser"
 )
 
-func (f *FlagData) BuildCmd(buildTypeID, branch, testRegex, service string) (buildID int, buildURL string, err error) {
-	tc := f.NewTCServer()
-
-	cout.Printf("triggering <magenta>%s</>%s @ <darkGray>%s...</>\n", branch, service, buildTypeID)
+// BuildSpec describes a single TeamCity build to trigger. newBuildSpec fills in the defaults from the flags,
+// which per-service configuration may then override.
+type BuildSpec struct {
+	TypeID       string
+	Branch       string
+	TestRegEx    string
+	Service      string              // empty for builds not tied to a service (e.g. the branch command)
+	PR           *PullRequestDetails // nil for branch builds
+	TestCount    int                 // number of discovered tests in TestRegEx, 0 for --all or an explicit regex
+	Properties   string              // KEY1=VALUE1;KEY2=VALUE2, values may be templates (see BuildTemplateData)
+	Tags         []string
+	Matrix       MatrixCell // the --matrix cell this build is for, nil when --matrix isn't used
+	Mode         string     // how the tests were chosen, for the audit log
+	QueueTimeout int
+	RunTimeout   int
+}
 
+func (f *FlagData) newBuildSpec(buildTypeID, branch, testRegEx, service string) BuildSpec {
 	properties := f.TC.Build.Parameters
 	if f.TC.Build.Comment {
 		if properties != "" {
@@ -24,15 +40,65 @@ func (f *FlagData) BuildCmd(buildTypeID, branch, testRegex, service string) (bui
 		properties += "POST_GITHUB_COMMENT=true"
 	}
 
+	return BuildSpec{
+		TypeID:       buildTypeID,
+		Branch:       branch,
+		TestRegEx:    testRegEx,
+		Service:      service,
+		Properties:   properties,
+		Tags:         append(append([]string{}, f.TC.Build.Tags...), f.tctestTags(service)...),
+		QueueTimeout: f.TC.Build.QueueTimeout,
+		RunTimeout:   f.TC.Build.RunTimeout,
+	}
+}
+
+func (f *FlagData) BuildCmd(spec BuildSpec) (buildID int, buildURL string, err error) {
+	server, err := f.NewTCServer()
+	if err != nil {
+		return 0, "", err
+	}
+
+	serviceInfo := ""
+	if spec.Service != "" {
+		serviceInfo = "[" + spec.Service + "]"
+	}
+
+	if len(spec.Matrix) > 0 {
+		serviceInfo += "{" + spec.Matrix.String() + "}"
+	}
+
+	cout.Printf("triggering <magenta>%s</>%s @ <darkGray>%s...</>\n", spec.Branch, serviceInfo, spec.TypeID)
+
+	data := spec.templateData()
+	params, err := renderBuildParameters(f.buildParameterTemplates(), data)
+	if err != nil {
+		return 0, "", err
+	}
+	properties, err := renderProperties(spec.Properties, data)
+	if err != nil {
+		return 0, "", err
+	}
+	extra, err := tc.ParseProperties(properties)
+	if err != nil {
+		return 0, "", err
+	}
+
 	if f.DryRun {
-		cout.Printf("  <yellow>[DRY RUN]</> would trigger build on <darkGray>%s</> with test regex <darkGray>%s</>\n", buildTypeID, testRegex)
+		cout.Printf("  <yellow>[DRY RUN]</> would trigger build on <darkGray>%s</> with test regex <darkGray>%s</>\n", spec.TypeID, spec.TestRegEx)
+		for _, p := range params {
+			cout.Printf("  <yellow>[DRY RUN]</> parameter: <darkGray>%s=%s</>\n", p.Name, p.Value)
+		}
 		if properties != "" {
 			cout.Printf("  <yellow>[DRY RUN]</> properties: <darkGray>%s</>\n", properties)
 		}
+		if len(spec.Tags) > 0 {
+			cout.Printf("  <yellow>[DRY RUN]</> tags: <darkGray>%v</>\n", spec.Tags)
+		}
 		return 0, "", nil
 	}
 
-	buildID, buildURL, err = tc.RunBuild(buildTypeID, properties, branch, testRegex, f.TC.Build.SkipQueue)
+	sent := mergeProperties(params, extra)
+	buildID, buildURL, err = server.RunBuild(spec.TypeID, sent, spec.Tags, f.TC.Build.SkipQueue)
 	if err != nil {
 		return 0, "", fmt.Errorf("unable to trigger build: %w", err)
 	}
@@ -41,15 +107,11 @@ func (f *FlagData) BuildCmd(buildTypeID, branch, testRegex, service string) (bui
 		buildURL += "&fromSakuraUI=true"
 	}
 
-	cout.Printf("  build <green>%d</> queued: <darkGray>%s</> with <darkGray>%s</>\n", buildID, buildURL, testRegex)
+	cout.Printf("  build <green>%d</> queued: <darkGray>%s</> with <darkGray>%s</>\n", buildID, buildURL, spec.TestRegEx)
+	f.auditTrigger(spec, sent, buildID, buildURL)
 
-	if len(f.TC.Build.Tags) > 0 {
-		cout.Printf("  adding labels: <yellow>%v</>...\n", f.TC.Build.Tags)
-		if err := tc.AddTags(buildID, f.TC.Build.Tags); err != nil {
-			cout.Printf("  <yellow>WARNING:</> failed to add tags to build %d: %v\n", buildID, err)
-		} else {
-			cout.Printf("  tags added successfully\n")
-		}
+	if len(spec.Tags) > 0 {
+		cout.Printf("  tagged: <yellow>%v</>\n", spec.Tags)
 	}
 
 	if f.OpenInBrowser {
@@ -60,7 +122,7 @@ func (f *FlagData) BuildCmd(buildTypeID, branch, testRegex, service string) (bui
 
 	if f.TC.Build.Wait {
 		clog.Log.Debugf("waiting...")
-		err := tc.WaitForBuild(buildID, f.TC.Build.QueueTimeout, f.TC.Build.RunTimeout)
+		err := server.WaitForBuild(buildID, spec.QueueTimeout, spec.RunTimeout)
 		if err != nil {
 			return buildID, buildURL, fmt.Errorf("error waiting for build %d to finish: %w", buildID, err)
 		}
@@ -74,9 +136,12 @@ func (f *FlagData) BuildCmd(buildTypeID, branch, testRegex, service string) (bui
 }
 
 func (f *FlagData) BuildResultsCmd(buildID int) error {
-	tc := f.NewTCServer()
+	server, err := f.NewTCServer()
+	if err != nil {
+		return err
+	}
 
-	statusCode, buildStatus, err := tc.BuildState(buildID)
+	statusCode, buildStatus, err := server.BuildState(buildID)
 	if err != nil {
 		return fmt.Errorf("error looking for build %d state: %w", buildID, err)
 	}
@@ -88,17 +153,17 @@ func (f *FlagData) BuildResultsCmd(buildID int) error {
 	}
 
 	if buildStatus != "finished" && f.TC.Build.Wait {
-		if err := tc.WaitForBuild(buildID, f.TC.Build.QueueTimeout, f.TC.Build.RunTimeout); err != nil {
+		if err := server.WaitForBuild(buildID, f.TC.Build.QueueTimeout, f.TC.Build.RunTimeout); err != nil {
 			return fmt.Errorf("error waiting for build %d to finish: %w", buildID, err)
 		}
 	}
 
-	statusCode, body, err := tc.BuildLog(buildID)
+	statusCode, body, err := server.BuildLog(buildID)
 	if err != nil {
 		return fmt.Errorf("error looking for build %d results: %w", buildID, err)
 	}
 
-	if err := tc.CheckBuildLogStatus(statusCode, buildID); err != nil {
+	if err := server.CheckBuildLogStatus(statusCode, buildID); err != nil {
 		return err
 	}
 
@@ -113,20 +178,61 @@ func (f *FlagData) BuildResultsCmd(buildID int) error {
 }
 
 func (f *FlagData) BuildResultsForPRCmd(pr int) error {
-	tc := f.NewTCServer()
-
-	builds, err := tc.GetBuildsForPR(f.TC.Build.TypeID, pr, f.TC.Build.Latest, f.TC.Build.Wait, f.TC.Build.QueueTimeout, f.TC.Build.RunTimeout)
+	server, err := f.NewTCServer()
 	if err != nil {
-		return fmt.Errorf("error looking for builds for PR %d state: %w", pr, err)
+		return err
+	}
+
+	// a service map may spread a PR's builds over several build configurations, so look in each of them
+	buildTypeIDs := f.resultsBuildTypeIDs()
+	var builds []tc.Build
+	for _, buildTypeID := range buildTypeIDs {
+		typeBuilds, err := server.GetBuildsForPR(buildTypeID, pr)
+		if err != nil {
+			if len(buildTypeIDs) > 1 && errors.Is(err, tc.ErrNoBuilds) {
+				clog.Log.Debugf("no builds for PR %d in %s", pr, buildTypeID)
+				continue
+			}
+			return fmt.Errorf("error looking for builds for PR %d state: %w", pr, err)
+		}
+		if f.TC.Build.Latest {
+			*typeBuilds = latestBuildPerCell(*typeBuilds)
+		}
+		builds = append(builds, *typeBuilds...)
+	}
+
+	if f.TC.Build.Wait {
+		for _, build := range builds {
+			if build.State == "finished" {
+				continue
+			}
+			if err := server.WaitForBuild(build.ID, f.TC.Build.QueueTimeout, f.TC.Build.RunTimeout); err != nil {
+				return fmt.Errorf("error waiting for PR %d, build %d to finish: %w", pr, build.ID, err)
+			}
+		}
+	}
+	if len(builds) == 0 {
+		return fmt.Errorf("no builds for PR %d found in %s", pr, strings.Join(buildTypeIDs, ", "))
 	}
 
-	for _, build := range *builds {
-		statusCode, body, err := tc.BuildLog(build.ID)
+	// group --matrix builds by cell, keeping TeamCity's order within each cell
+	slices.SortStableFunc(builds, func(a, b tc.Build) int {
+		return strings.Compare(matrixCellFromTags(a.Tags), matrixCellFromTags(b.Tags))
+	})
+
+	cell := ""
+	for i, build := range builds {
+		if c := matrixCellFromTags(build.Tags); c != "" && (i == 0 || c != cell) {
+			cout.Printf("<cyan>Matrix %s</>\n", c)
+			cell = c
+		}
+
+		statusCode, body, err := server.BuildLog(build.ID)
 		if err != nil {
 			return fmt.Errorf("error looking for PR %d, build %d results: %w", pr, build.ID, err)
 		}
 
-		if err := tc.CheckBuildLogStatus(statusCode, build.ID); err != nil {
+		if err := server.CheckBuildLogStatus(statusCode, build.ID); err != nil {
 			return err
 		}
 
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/katbyte/tctest/lib/cout"
+)
+
+// tctestTag is added to every build tctest triggers, so it can find the builds of a PR across build types and count
+// the builds it has in flight. When throttling, builds also get tctestTag-<service>.
+const tctestTag = "tctest"
+
+// errInterrupted is returned when Ctrl-C is pressed while waiting for a build slot.
+var errInterrupted = errors.New("interrupted while waiting for a build slot")
+
+func (f *FlagData) throttling() bool {
+	return f.TC.Build.MaxRunning > 0 || f.TC.Build.MaxRunningPerService > 0
+}
+
+func throttleServiceTag(service string) string {
+	return tctestTag + "-" + service
+}
+
+// tctestTags returns the tags tctest adds to a build, the ones waitForSlot counts builds by.
+func (f *FlagData) tctestTags(service string) []string {
+	if !f.throttling() || service == "" {
+		return []string{tctestTag}
+	}
+	return []string{tctestTag, throttleServiceTag(service)}
+}
+
+// buildCounter counts the running and queued builds with a tag, tc.Server outside of the tests.
+type buildCounter interface {
+	CountActiveBuildsWithTag(tag string) (int, error)
+}
+
+// waitForSlot blocks until fewer than --max-running tagged builds (and --max-running-per-service for the service)
+// are running or queued in TeamCity. Ctrl-C is only caught while waiting, everywhere else it exits as usual.
+func (f *FlagData) waitForSlot(service string) error {
+	if !f.throttling() || f.DryRun {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server, err := f.NewTCServer()
+	if err != nil {
+		return err
+	}
+	return f.waitForSlotIn(ctx, server, service)
+}
+
+// waitForSlotIn is waitForSlot counting builds with counter until ctx is done.
+func (f *FlagData) waitForSlotIn(ctx context.Context, counter buildCounter, service string) error {
+	start := time.Now()
+	// the status line ticks every second, or every poll when polling more often
+	tick := time.Second
+	if p := f.TC.Build.ThrottlePollInterval; p > 0 && p < tick {
+		tick = p
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var status string
+	var nextPoll time.Time
+	for {
+		if now := time.Now(); !now.Before(nextPoll) {
+			nextPoll = now.Add(f.TC.Build.ThrottlePollInterval)
+
+			s, free, err := f.slotStatus(counter, service)
+			if err != nil {
+				return err
+			}
+			if free {
+				if status != "" {
+					cout.Printf("\n")
+				}
+				return nil
+			}
+			status = s
+		}
+
+		cout.Printf("\r  <yellow>waiting for slot</> %s <darkGray>(%s, Ctrl-C to stop)</>", status, time.Since(start).Truncate(time.Second))
+
+		select {
+		case <-ctx.Done():
+			cout.Printf("\n")
+			return errInterrupted
+		case <-ticker.C:
+		}
+	}
+}
+
+// slotStatus counts the tagged builds and returns a status line and whether a build for the service can start.
+func (f *FlagData) slotStatus(counter buildCounter, service string) (status string, free bool, err error) {
+	free = true
+
+	if limit := f.TC.Build.MaxRunning; limit > 0 {
+		n, err := counter.CountActiveBuildsWithTag(tctestTag)
+		if err != nil {
+			return "", false, fmt.Errorf("checking running builds: %w", err)
+		}
+		status = fmt.Sprintf("%d/%d running or queued", n, limit)
+		free = n < limit
+	}
+
+	if limit := f.TC.Build.MaxRunningPerService; limit > 0 && service != "" {
+		n, err := counter.CountActiveBuildsWithTag(throttleServiceTag(service))
+		if err != nil {
+			return "", false, fmt.Errorf("checking running %s builds: %w", service, err)
+		}
+		if status != "" {
+			status += ", "
+		}
+		status += fmt.Sprintf("%s %d/%d", service, n, limit)
+		free = free && n < limit
+	}
+
+	return status, free, nil
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCounter returns the next of its counts for a tag on each call, repeating the last.
+type fakeCounter struct {
+	mu     sync.Mutex
+	counts map[string][]int
+	calls  map[string]int
+	err    error
+}
+
+func (c *fakeCounter) CountActiveBuildsWithTag(tag string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	counts := c.counts[tag]
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	n := counts[min(c.calls[tag], len(counts)-1)]
+	c.calls[tag]++
+	return n, nil
+}
+
+func throttledFlags(maxRunning, perService int) *FlagData {
+	f := &FlagData{}
+	f.TC.Build.MaxRunning = maxRunning
+	f.TC.Build.MaxRunningPerService = perService
+	f.TC.Build.ThrottlePollInterval = time.Millisecond
+	return f
+}
+
+func TestSlotStatus(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		maxRunning int
+		perService int
+		service    string
+		counts     map[string][]int
+		status     string
+		free       bool
+	}{
+		{name: "free", maxRunning: 3, counts: map[string][]int{"tctest": {2}}, status: "2/3 running or queued", free: true},
+		{name: "full", maxRunning: 3, counts: map[string][]int{"tctest": {3}}, status: "3/3 running or queued"},
+		{name: "service full", maxRunning: 3, perService: 1, service: "network", counts: map[string][]int{"tctest": {1}, "tctest-network": {1}}, status: "1/3 running or queued, network 1/1"},
+		{name: "service free", perService: 2, service: "network", counts: map[string][]int{"tctest": {9}, "tctest-network": {1}}, status: "network 1/2", free: true},
+		{name: "per service limit without a service", perService: 1, counts: map[string][]int{"tctest": {9}}, free: true},
+	}
+
+	for _, tt := range cases {
+		f := throttledFlags(tt.maxRunning, tt.perService)
+		status, free, err := f.slotStatus(&fakeCounter{counts: tt.counts}, tt.service)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if status != tt.status || free != tt.free {
+			t.Errorf("%s: slotStatus = %q, %t, want %q, %t", tt.name, status, free, tt.status, tt.free)
+		}
+	}
+
+	_, _, err := throttledFlags(1, 0).slotStatus(&fakeCounter{err: errors.New("boom")}, "")
+	if err == nil || !strings.Contains(err.Error(), "checking running builds") {
+		t.Errorf("slotStatus error = %v, want it to say what it was checking", err)
+	}
+}
+
+func TestWaitForSlotIn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waits until a slot is free", func(t *testing.T) {
+		t.Parallel()
+
+		counter := &fakeCounter{counts: map[string][]int{"tctest": {2, 2, 1}}}
+		if err := throttledFlags(2, 0).waitForSlotIn(t.Context(), counter, "network"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if counter.calls["tctest"] != 3 {
+			t.Errorf("polled %d times, want 3", counter.calls["tctest"])
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+		defer cancel()
+		counter := &fakeCounter{counts: map[string][]int{"tctest": {1}, "tctest-network": {1}}}
+		if err := throttledFlags(0, 1).waitForSlotIn(ctx, counter, "network"); !errors.Is(err, errInterrupted) {
+			t.Fatalf("error = %v, want errInterrupted", err)
+		}
+	})
+
+	t.Run("counting fails", func(t *testing.T) {
+		t.Parallel()
+
+		if err := throttledFlags(1, 0).waitForSlotIn(t.Context(), &fakeCounter{err: errors.New("boom")}, ""); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+)
+
+// fork policies: review holds fork PRs that aren't trusted, trust-all triggers builds for every PR
+const (
+	ForkPolicyReview   = "review"
+	ForkPolicyTrustAll = "trust-all"
+)
+
+var forkPolicies = []string{ForkPolicyReview, ForkPolicyTrustAll}
+
+func validateForkPolicy(policy string) error {
+	if !slices.Contains(forkPolicies, policy) {
+		return fmt.Errorf("unknown --fork-policy %q, expected one of: %s", policy, strings.Join(forkPolicies, ", "))
+	}
+	return nil
+}
+
+// isFork returns true if the PR's head is not the base repo. A deleted head repo counts as a fork.
+func isFork(pr *github.PullRequest) bool {
+	head := pr.GetHead().GetRepo()
+	return head == nil || !strings.EqualFold(head.GetFullName(), pr.GetBase().GetRepo().GetFullName())
+}
+
+// CheckPrTrust decides if builds may be triggered for a PR, with the reason either way. PRs from the repo itself
+// are trusted, fork PRs only when their author is on --trusted-authors or a member of one of --trusted-orgs, or
+// when they carry the --safe-to-test-label, which serve and watch remove from a fork PR when it is pushed to.
+func (f *FlagData) CheckPrTrust(pr *github.PullRequest) (bool, string, error) {
+	if f.Trust.ForkPolicy == ForkPolicyTrustAll {
+		return true, "--fork-policy is " + ForkPolicyTrustAll, nil
+	}
+
+	if !isFork(pr) {
+		return true, "not from a fork", nil
+	}
+
+	author := pr.GetUser().GetLogin()
+	if slices.ContainsFunc(f.Trust.Authors, func(a string) bool { return strings.EqualFold(a, author) }) {
+		return true, author + " is a trusted author", nil
+	}
+
+	for _, org := range f.Trust.Orgs {
+		member, err := f.NewRepo().IsOrgMember(org, author)
+		if err != nil {
+			return false, "", err
+		}
+		if member {
+			return true, author + " is a member of " + org, nil
+		}
+	}
+
+	from := "a deleted fork"
+	if head := pr.GetHead().GetRepo(); head != nil {
+		from = head.GetFullName()
+	}
+	reason := fmt.Sprintf("from %s by %s, who is not trusted", from, author)
+
+	label := f.Trust.SafeToTestLabel
+	if label == "" || !slices.ContainsFunc(pr.Labels, func(l *github.Label) bool { return l.GetName() == label }) {
+		return false, reason, nil
+	}
+
+	// plain pushes aren't on the timeline, serve and watch remove the label when they see one, but GitHub records when
+	// the branch was force pushed, so a label added before that doesn't count either
+	timeline, err := f.NewRepo().ListIssueTimeline(pr.GetNumber())
+	if err != nil {
+		return false, "", err
+	}
+	labelled, forcePushed := safeToTestTimes(timeline, label)
+	if forcePushed.After(labelled) {
+		return false, reason + ", and the branch was force pushed after it was labelled " + label, nil
+	}
+
+	return true, "labelled " + label, nil
+}
+
+// safeToTestTimes returns when the label was last added and when the PR's branch was last force pushed, both as
+// recorded by GitHub rather than the commit dates set by the author.
+func safeToTestTimes(timeline []*github.Timeline, label string) (labelled, forcePushed time.Time) {
+	for _, e := range timeline {
+		at := e.GetCreatedAt().Time
+		switch {
+		case e.GetEvent() == "labeled" && e.GetLabel().GetName() == label:
+			labelled = latest(labelled, at)
+		case e.GetEvent() == "head_ref_force_pushed":
+			forcePushed = latest(forcePushed, at)
+		default:
+			continue
+		}
+		clog.Log.Debugf("timeline: %s at %s", e.GetEvent(), at)
+	}
+	return labelled, forcePushed
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// revokeSafeToTest removes the safe to test label from a fork PR that has been pushed to, so new commits need
+// another review. Used by serve as it sees each push, and by watch when a PR's head changed between passes.
+func (f *FlagData) revokeSafeToTest(pr *github.PullRequest) error {
+	label := f.Trust.SafeToTestLabel
+	if label == "" || f.Trust.ForkPolicy == ForkPolicyTrustAll || !isFork(pr) {
+		return nil
+	}
+	if !slices.ContainsFunc(pr.Labels, func(l *github.Label) bool { return l.GetName() == label }) {
+		return nil
+	}
+
+	if f.DryRun {
+		clog.Log.Debugf("dry run, not removing %s from PR #%d", label, pr.GetNumber())
+		return nil
+	}
+	return f.NewRepo().RemoveLabel(pr.GetNumber(), label)
+}
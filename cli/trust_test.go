@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v89/github"
+)
+
+func testPr(head, author string, labels ...string) *github.PullRequest {
+	pr := &github.PullRequest{
+		Number: new(1),
+		User:   &github.User{Login: new(author)},
+		Base:   &github.PullRequestBranch{Repo: &github.Repository{FullName: new("hashicorp/terraform-provider-azurerm")}},
+		Head:   &github.PullRequestBranch{},
+	}
+	if head != "" {
+		pr.Head.Repo = &github.Repository{FullName: new(head)}
+	}
+	for _, l := range labels {
+		pr.Labels = append(pr.Labels, &github.Label{Name: new(l)})
+	}
+	return pr
+}
+
+func TestCheckPrTrust(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		policy  string
+		authors []string
+		pr      *github.PullRequest
+		trusted bool
+	}{
+		{"same repo", ForkPolicyReview, nil, testPr("hashicorp/terraform-provider-azurerm", "someone"), true},
+		{"same repo different case", ForkPolicyReview, nil, testPr("HashiCorp/terraform-provider-azurerm", "someone"), true},
+		{"fork", ForkPolicyReview, nil, testPr("someone/terraform-provider-azurerm", "someone"), false},
+		{"deleted fork", ForkPolicyReview, nil, testPr("", "someone"), false},
+		{"fork by trusted author", ForkPolicyReview, []string{"Someone"}, testPr("someone/terraform-provider-azurerm", "someone"), true},
+		{"fork with other labels", ForkPolicyReview, nil, testPr("someone/terraform-provider-azurerm", "someone", "acc-test"), false},
+		{"fork with trust-all", ForkPolicyTrustAll, nil, testPr("someone/terraform-provider-azurerm", "someone"), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &FlagData{Trust: FlagsTrust{ForkPolicy: tc.policy, Authors: tc.authors, SafeToTestLabel: "safe-to-test"}}
+			trusted, reason, err := f.CheckPrTrust(tc.pr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if trusted != tc.trusted {
+				t.Errorf("trusted = %t (%s), want %t", trusted, reason, tc.trusted)
+			}
+		})
+	}
+}
+
+func TestSafeToTestTimes(t *testing.T) {
+	t.Parallel()
+
+	at := func(hour int) *github.Timestamp {
+		return &github.Timestamp{Time: time.Date(2026, 1, 1, hour, 0, 0, 0, time.UTC)}
+	}
+	labeled := func(name string, hour int) *github.Timeline {
+		return &github.Timeline{Event: new("labeled"), Label: &github.Label{Name: new(name)}, CreatedAt: at(hour)}
+	}
+	forcePushed := func(hour int) *github.Timeline {
+		return &github.Timeline{Event: new("head_ref_force_pushed"), CreatedAt: at(hour)}
+	}
+
+	cases := []struct {
+		name      string
+		timeline  []*github.Timeline
+		labelled  int // hour, 0 when never
+		forcePush int
+	}{
+		{name: "labelled", timeline: []*github.Timeline{labeled("safe-to-test", 2)}, labelled: 2},
+		{name: "labelled again after a force push", timeline: []*github.Timeline{labeled("safe-to-test", 1), forcePushed(2), labeled("safe-to-test", 3)}, labelled: 3, forcePush: 2},
+		{name: "force pushed after the label", timeline: []*github.Timeline{labeled("safe-to-test", 1), forcePushed(2)}, labelled: 1, forcePush: 2},
+		{name: "other labels", timeline: []*github.Timeline{labeled("acc-test", 4), forcePushed(1)}, forcePush: 1},
+		{name: "commits don't count", timeline: []*github.Timeline{labeled("safe-to-test", 1), {Event: new("committed"), CreatedAt: at(5)}}, labelled: 1},
+	}
+
+	hour := func(h int) time.Time {
+		if h == 0 {
+			return time.Time{}
+		}
+		return at(h).Time
+	}
+	for _, tt := range cases {
+		labelled, forcePushed := safeToTestTimes(tt.timeline, "safe-to-test")
+		if !labelled.Equal(hour(tt.labelled)) || !forcePushed.Equal(hour(tt.forcePush)) {
+			t.Errorf("%s: safeToTestTimes = %s, %s, want labelled at %d and force pushed at %d", tt.name, labelled, forcePushed, tt.labelled, tt.forcePush)
+		}
+	}
+}
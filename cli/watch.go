@@ -0,0 +1,244 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/cout"
+)
+
+// WatchState is what `watch` remembers between passes and restarts: the SHAs each PR was last tested at, and the
+// head each open PR was last seen at, held ones included, to notice pushes to PRs labelled safe to test.
+type WatchState struct {
+	PRs   map[int]WatchedPR `json:"prs"`
+	Heads map[int]string    `json:"heads,omitempty"`
+}
+
+type WatchedPR struct {
+	HeadSHA  string           `json:"head_sha"`
+	MergeSHA string           `json:"merge_sha"`
+	TestedAt time.Time        `json:"tested_at"`
+	Builds   []TriggeredBuild `json:"builds"`
+
+	// Retry are the services whose builds failed to trigger at these SHAs, the next pass triggers only them
+	Retry []string `json:"retry,omitempty"`
+}
+
+// watchRun is the outcome of testing a PR in a watch pass.
+type watchRun struct {
+	Triggered []TriggeredBuild
+	Failed    []string // the services whose builds failed to trigger
+	Held      bool
+}
+
+// watchRunFunc tests a PR, only the retry services when there are any.
+type watchRunFunc func(pr github.PullRequest, retry []string) (watchRun, error)
+
+// watchStatePath returns the state file for the repo, so watching several repos doesn't mix their state.
+func (f *FlagData) watchStatePath() (string, error) {
+	dir, err := f.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	r := f.NewRepo()
+	return filepath.Join(dir, fmt.Sprintf("watch-%s-%s.json", r.Owner, r.Name)), nil
+}
+
+// WatchCmd finds open PRs matching the filters every interval and triggers builds for those whose head or merge
+// SHA changed since they were last tested.
+func (f *FlagData) WatchCmd() error {
+	if f.Watch.Interval <= 0 && !f.Watch.Once {
+		return fmt.Errorf("--watch-interval must be positive, got %s", f.Watch.Interval)
+	}
+
+	f.unattended = true
+
+	statePath, err := f.watchStatePath()
+	if err != nil {
+		return err
+	}
+
+	state := WatchState{PRs: map[int]WatchedPR{}}
+	if err := readJSONState(statePath, &state); err != nil {
+		return err
+	}
+	if state.PRs == nil {
+		state.PRs = map[int]WatchedPR{}
+	}
+
+	cout.Printf("Filters:\n")
+	filters, err := f.GetFilters()
+	if err != nil {
+		return fmt.Errorf("error creating filters: %w", err)
+	}
+	cout.Printf("watching with state in <darkGray>%s</>\n\n", statePath)
+
+	for {
+		err := f.watchFilteredPass(&state, statePath, filters)
+		if errors.Is(err, errInterrupted) {
+			return err
+		}
+		if err != nil {
+			// a failed pass (GitHub or TeamCity being unavailable) is retried on the next interval
+			cout.Errorf("<red>ERROR:</> %v\n", err)
+			if f.Watch.Once {
+				return err
+			}
+		}
+
+		if f.Watch.Once {
+			return nil
+		}
+
+		cout.Printf("next check in <yellow>%s</> <darkGray>(at %s, Ctrl-C to stop)</>\n\n", f.Watch.Interval, time.Now().Add(f.Watch.Interval).Format(time.Kitchen))
+		if !sleepUnlessInterrupted(f.Watch.Interval) {
+			cout.Printf("stopped watching\n")
+			return nil
+		}
+	}
+}
+
+// watchFilteredPass runs a single check of the open PRs matching the filters.
+func (f *FlagData) watchFilteredPass(state *WatchState, statePath string, filters []Filter) error {
+	open, matched, err := f.FilterPrs(filters)
+	if err != nil {
+		return err
+	}
+	return f.watchPass(state, statePath, open, matched, f.runWatchedPR)
+}
+
+// watchPass tests the matched PRs with new commits, or services left to retry, and saves the state after each one.
+func (f *FlagData) watchPass(state *WatchState, statePath string, open, matched []github.PullRequest, run watchRunFunc) error {
+	// forget PRs that have been closed or merged
+	openSet := make(map[int]bool, len(open))
+	for _, pr := range open {
+		openSet[pr.GetNumber()] = true
+	}
+	for n := range state.PRs {
+		if !openSet[n] {
+			delete(state.PRs, n)
+		}
+	}
+	for n := range state.Heads {
+		if !openSet[n] {
+			delete(state.Heads, n)
+		}
+	}
+
+	// a fork PR that was pushed to since the last pass needs another review, so it loses its safe to test label as
+	// it would with serve. Failing to remove it fails the pass, so nothing is tested with it and the next pass retries.
+	if state.Heads == nil {
+		state.Heads = map[int]string{}
+	}
+	for _, pr := range open {
+		number, head := pr.GetNumber(), pr.GetHead().GetSHA()
+		if seen, ok := state.Heads[number]; ok && seen != head {
+			if err := f.revokeSafeToTest(&pr); err != nil {
+				return fmt.Errorf("removing %s from PR #%d: %w", f.Trust.SafeToTestLabel, number, err)
+			}
+		}
+		state.Heads[number] = head
+	}
+
+	var changed []github.PullRequest
+	for _, pr := range matched {
+		last, ok := state.PRs[pr.GetNumber()]
+		if ok && last.sameSHAs(pr) && len(last.Retry) == 0 {
+			cout.Printf("  #<cyan>%d</> <darkGray>unchanged since %s</>\n", pr.GetNumber(), last.TestedAt.Format(time.RFC3339))
+			continue
+		}
+		changed = append(changed, pr)
+	}
+	cout.Printf("testing <yellow>%d</> of <yellow>%d</> matching prs with new commits or failed triggers\n\n", len(changed), len(matched))
+
+	for _, pr := range changed {
+		number := pr.GetNumber()
+
+		// the builds that triggered at these SHAs aren't queued again, only the services that failed to
+		last, retrying := state.PRs[number]
+		retrying = retrying && last.sameSHAs(pr)
+		var retry []string
+		if retrying {
+			retry = last.Retry
+			cout.Printf("  #<cyan>%d</> <yellow>retrying</> %s\n", number, strings.Join(retry, ", "))
+		}
+
+		r, err := run(pr, retry)
+		if errors.Is(err, errInterrupted) {
+			return err
+		}
+		if err != nil {
+			cout.Errorf("  <red>ERROR:</> PR #%d: %v\n\n", number, err)
+			if len(r.Failed) == 0 {
+				continue // nothing triggered, so it is retried on the next pass
+			}
+		}
+
+		// held PRs are checked again on the next pass, in case they have been labelled safe to test
+		if f.DryRun || r.Held {
+			continue
+		}
+
+		watched := WatchedPR{
+			HeadSHA:  pr.GetHead().GetSHA(),
+			MergeSHA: pr.GetMergeCommitSHA(),
+			TestedAt: time.Now().UTC(),
+			Builds:   r.Triggered,
+			Retry:    r.Failed,
+		}
+		if retrying {
+			watched.Builds = slices.Concat(last.Builds, r.Triggered)
+		}
+		state.PRs[number] = watched
+		if err := writeJSONState(statePath, state); err != nil {
+			return err
+		}
+	}
+
+	// closed PRs may have been dropped even when nothing was tested
+	if !f.DryRun {
+		return writeJSONState(statePath, state)
+	}
+	return nil
+}
+
+// sameSHAs returns whether the PR is still at the SHAs it was tested at.
+func (w WatchedPR) sameSHAs(pr github.PullRequest) bool {
+	return w.HeadSHA == pr.GetHead().GetSHA() && w.MergeSHA == pr.GetMergeCommitSHA()
+}
+
+// runWatchedPR tests a PR for watch, triggering only the retry services when there are any.
+func (f *FlagData) runWatchedPR(pr github.PullRequest, retry []string) (watchRun, error) {
+	f.Triggered = nil
+	f.Held = nil
+	f.FailedServices = nil
+	f.retryServices = retry
+	defer func() { f.retryServices = nil }()
+
+	err := f.GetAndRunPrsTests(map[int]string{pr.GetNumber(): pr.GetTitle()}, "")
+	return watchRun{Triggered: f.Triggered, Failed: f.FailedServices, Held: len(f.Held) > 0}, err
+}
+
+// sleepUnlessInterrupted sleeps for d, returning false if Ctrl-C was pressed first. Ctrl-C is only caught while
+// sleeping, so an interrupted pass still exits as usual.
+func sleepUnlessInterrupted(d time.Duration) bool {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
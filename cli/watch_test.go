@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v89/github"
+)
+
+func watchTestPR(number int, head string) github.PullRequest {
+	return github.PullRequest{
+		Number:         github.Ptr(number),
+		Title:          github.Ptr(fmt.Sprintf("PR %d", number)),
+		Head:           &github.PullRequestBranch{SHA: github.Ptr(head)},
+		MergeCommitSHA: github.Ptr("merge-" + head),
+	}
+}
+
+func TestWatchStateRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "watch.json")
+	state := WatchState{PRs: map[int]WatchedPR{
+		1234: {
+			HeadSHA:  "aaa",
+			MergeSHA: "bbb",
+			TestedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+			Builds:   []TriggeredBuild{{PR: 1234, Service: "dns", BuildTypeID: "TF_DNS", BuildID: 1, URL: "https://tc/1"}},
+			Retry:    []string{"postgres"},
+		},
+	}, Heads: map[int]string{1234: "aaa", 1235: "ccc"}}
+	if err := writeJSONState(path, &state); err != nil {
+		t.Fatal(err)
+	}
+
+	var got WatchState
+	if err := readJSONState(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("read state = %+v, want %+v", got, state)
+	}
+
+	// a missing file leaves the state untouched
+	missing := WatchState{PRs: map[int]WatchedPR{}}
+	if err := readJSONState(filepath.Join(t.TempDir(), "missing.json"), &missing); err != nil || len(missing.PRs) != 0 {
+		t.Errorf("reading a missing state file = %+v, %v", missing, err)
+	}
+}
+
+func TestWatchPass(t *testing.T) {
+	t.Parallel()
+
+	type call struct {
+		pr    int
+		retry []string
+	}
+	var calls []call
+	// outcomes is what testing each PR returns, by PR number
+	outcomes := map[int]func(retry []string) (watchRun, error){}
+	run := func(pr github.PullRequest, retry []string) (watchRun, error) {
+		calls = append(calls, call{pr.GetNumber(), retry})
+		return outcomes[pr.GetNumber()](retry)
+	}
+	build := func(pr int, service string) TriggeredBuild {
+		return TriggeredBuild{PR: pr, Service: service, BuildTypeID: "TF_" + service}
+	}
+
+	f := &FlagData{}
+	path := filepath.Join(t.TempDir(), "watch.json")
+	state := WatchState{PRs: map[int]WatchedPR{99: {HeadSHA: "closed"}}}
+
+	pass := func(name string, prs []github.PullRequest, want []call) {
+		t.Helper()
+		calls = nil
+		open := append([]github.PullRequest{watchTestPR(50, "unmatched")}, prs...)
+		if err := f.watchPass(&state, path, open, prs, run); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("%s: tested %+v, want %+v", name, calls, want)
+		}
+
+		var saved WatchState
+		if err := readJSONState(path, &saved); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(saved, state) {
+			t.Errorf("%s: saved state %+v, want %+v", name, saved, state)
+		}
+	}
+
+	// 1 fails to trigger postgres, 2 fails before triggering anything, 3 is held
+	outcomes[1] = func([]string) (watchRun, error) {
+		return watchRun{Triggered: []TriggeredBuild{build(1, "dns")}, Failed: []string{"postgres"}}, errors.New("1 build(s) failed to trigger")
+	}
+	outcomes[2] = func([]string) (watchRun, error) { return watchRun{}, errors.New("discovering tests") }
+	outcomes[3] = func([]string) (watchRun, error) { return watchRun{Held: true}, nil }
+	prs := []github.PullRequest{watchTestPR(1, "a"), watchTestPR(2, "b"), watchTestPR(3, "c")}
+	pass("first pass", prs, []call{{1, nil}, {2, nil}, {3, nil}})
+	if _, ok := state.PRs[99]; ok {
+		t.Error("the closed PR wasn't dropped")
+	}
+	if got := state.PRs[1]; !slices.Equal(got.Retry, []string{"postgres"}) || len(got.Builds) != 1 {
+		t.Errorf("PR 1 recorded as %+v, want the dns build and postgres to retry", got)
+	}
+	if _, ok := state.PRs[2]; ok {
+		t.Error("PR 2 was recorded without triggering anything")
+	}
+	if _, ok := state.PRs[3]; ok {
+		t.Error("the held PR was recorded")
+	}
+	if want := map[int]string{1: "a", 2: "b", 3: "c", 50: "unmatched"}; !reflect.DeepEqual(state.Heads, want) {
+		t.Errorf("heads = %v, want every open PR's, held and unmatched ones too: %v", state.Heads, want)
+	}
+
+	// only postgres is retried for 1, 2 and 3 are tried again in full
+	outcomes[1] = func(retry []string) (watchRun, error) {
+		var r watchRun
+		for _, s := range retry {
+			r.Triggered = append(r.Triggered, build(1, s))
+		}
+		return r, nil
+	}
+	pass("retry pass", prs, []call{{1, []string{"postgres"}}, {2, nil}, {3, nil}})
+	if got := state.PRs[1]; len(got.Retry) != 0 || !reflect.DeepEqual(got.Builds, []TriggeredBuild{build(1, "dns"), build(1, "postgres")}) {
+		t.Errorf("PR 1 recorded as %+v after the retry, want both builds", got)
+	}
+
+	// 1 is unchanged until it gets a new commit
+	delete(outcomes, 2)
+	delete(outcomes, 3)
+	pass("unchanged pass", prs[:1], nil)
+	pass("new commit pass", []github.PullRequest{watchTestPR(1, "d")}, []call{{1, nil}})
+	if got := state.PRs[1]; got.HeadSHA != "d" || len(got.Builds) != 0 {
+		t.Errorf("PR 1 recorded as %+v after the new commit, want the new SHA and no builds", got)
+	}
+	if want := map[int]string{1: "d", 50: "unmatched"}; !reflect.DeepEqual(state.Heads, want) {
+		t.Errorf("heads = %v after 2 and 3 closed, want %v", state.Heads, want)
+	}
+}
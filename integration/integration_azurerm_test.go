@@ -1,11 +1,17 @@
 package integration
 
 import (
+	"cmp"
 	"encoding/json"
 	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // azurermPRs defines the PRs the mock GitHub serves for the azurerm fixture.
@@ -37,6 +43,10 @@ var azurermPRs = []prDef{
 		{"internal/services/postgres/postgresql_flexible_server_resource_test.go", "modified"},
 		{"internal/services/postgres/validate/database_charset.go", "modified"},
 	}},
+	{1009, "open", "provider registration changed", []changedFile{
+		{"internal/provider/services.go", "modified"},
+		{"main.go", "modified"},
+	}},
 	{1020, "open", "postgres improvement", []changedFile{
 		{"internal/services/postgres/postgresql_flexible_server_resource_test.go", "modified"},
 	}},
@@ -84,6 +94,27 @@ var azurermASTPRs = []prDef{
 		{"internal/services/postgres/postgresql_flexible_server_resource_test.go", "modified"},
 		{"internal/services/postgres/validate/database_charset.go", "modified"},
 	}},
+	{2012, "open", "shared package changed", []changedFile{
+		{"internal/timeouts/timeouts.go", "modified"},
+	}},
+	{2017, "open", "acceptance test helper changed", []changedFile{
+		{"internal/acceptance/data.go", "modified"},
+	}},
+	{2014, "open", "transitively vendored dependency changed", []changedFile{
+		{"vendor/github.com/hashicorp/go-azure-sdk/sdk/client/resourcemanager/client.go", "modified"},
+	}},
+	{2015, "open", "unused transitively vendored dependency changed", []changedFile{
+		{"vendor/github.com/hashicorp/go-azure-sdk/sdk/client/resourcemanager/poller.go", "modified"},
+	}},
+	{2016, "open", "dependency bump", []changedFile{
+		{"go.mod", "modified"},
+		{"go.sum", "modified"},
+		{"vendor/modules.txt", "modified"},
+	}},
+	{2013, "open", "provider registration changed", []changedFile{
+		{"internal/provider/services.go", "modified"},
+		{"main.go", "modified"},
+	}},
 }
 
 func azurermEnv(gh *mockGitHub, tc *mockTeamCity) map[string]string {
@@ -226,6 +257,7 @@ func TestASTDiscoveryAzureRM(t *testing.T) {
 		name     string
 		args     []string
 		extra    map[string]string
+		mode     string // the discovery mode reported, AST when empty
 		want     []trigger
 		wantExit int
 	}{
@@ -247,11 +279,72 @@ func TestASTDiscoveryAzureRM(t *testing.T) {
 			// uses an upgrader from a different file — it must NOT be selected
 			want: []trigger{{"TF_E2E_POSTGRES", "refs/pull/2003/merge", "(TestAccPostgreSQLAdministrator)"}},
 		},
+		{
+			name:  "types mode traces cross-package helper through its uses",
+			args:  []string{"pr", "2002"},
+			extra: map[string]string{"TCTEST_MODE": "types"},
+			mode:  "types",
+			want:  []trigger{{"TF_E2E_POSTGRES", "refs/pull/2002/merge", "(TestAccPostgresqlFlexibleServerDatabase)"}},
+		},
+		{
+			name:  "types mode traces two-level helper chain only to symbol users",
+			args:  []string{"pr", "2003"},
+			extra: map[string]string{"TCTEST_MODE": "types"},
+			mode:  "types",
+			want:  []trigger{{"TF_E2E_POSTGRES", "refs/pull/2003/merge", "(TestAccPostgreSQLAdministrator)"}},
+		},
+		{
+			name: "shared package change traces importers across services",
+			args: []string{"pr", "2012"},
+			want: []trigger{
+				{"TF_E2E_COSMOS", "refs/pull/2012/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"},
+				{"TF_E2E_DNS", "refs/pull/2012/merge", "(TestAccDataSourceDnsAAAARecord|TestAccDnsAAAARecord|TestAccDnsARecord)"},
+				{"TF_E2E_POSTGRES", "refs/pull/2012/merge", "(TestAccDataSourcePostgresqlflexibleServer|TestAccPostgreSQLAdministrator|TestAccPostgresqlFlexibleServer|TestAccPostgresqlFlexibleServerDatabase)"},
+			},
+		},
+		{
+			name: "shared package only tests import traces to its test files",
+			args: []string{"pr", "2017"},
+			want: []trigger{
+				{"TF_E2E_COSMOS", "refs/pull/2017/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"},
+				{"TF_E2E_DNS", "refs/pull/2017/merge", "(TestAccAzureRMDNSZoneDataSource|TestAccDataSourceDnsAAAARecord|TestAccDnsAAAARecord|TestAccDnsARecord)"},
+				{"TF_E2E_POSTGRES", "refs/pull/2017/merge", "(TestAccDataSourcePostgresqlflexibleServer|TestAccPostgreSQLAdministrator|TestAccPostgresqlFlexibleServer|TestAccPostgresqlFlexibleServerDatabase|TestAccPostgresqlFlexibleServerVirtualEndpoint)"},
+			},
+		},
+		{
+			name:  "types mode traces shared package change across services",
+			args:  []string{"pr", "2012"},
+			extra: map[string]string{"TCTEST_MODE": "types"},
+			mode:  "types",
+			want: []trigger{
+				{"TF_E2E_COSMOS", "refs/pull/2012/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"},
+				{"TF_E2E_DNS", "refs/pull/2012/merge", "(TestAccDataSourceDnsAAAARecord|TestAccDnsAAAARecord|TestAccDnsARecord)"},
+				{"TF_E2E_POSTGRES", "refs/pull/2012/merge", "(TestAccDataSourcePostgresqlflexibleServer|TestAccPostgreSQLAdministrator|TestAccPostgresqlFlexibleServer|TestAccPostgresqlFlexibleServerDatabase)"},
+			},
+		},
 		{
 			name: "vendored dependency change traces to importing resources",
 			args: []string{"pr", "2004"},
 			want: []trigger{{"TF_E2E_COSMOS", "refs/pull/2004/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"}},
 		},
+		{
+			name: "basic vendor mode only traces direct importers",
+			args: []string{"pr", "2014"},
+			want: nil,
+		},
+		{
+			name:  "deep vendor mode traces through the vendored packages importing the change",
+			args:  []string{"pr", "2014"},
+			extra: map[string]string{"TCTEST_LOCAL_VENDOR_MODE": "deep"},
+			want:  []trigger{{"TF_E2E_COSMOS", "refs/pull/2014/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"}},
+		},
+		{
+			// no vendored package uses the poller, so nothing imports the change transitively
+			name:  "deep vendor mode follows only the changed symbols",
+			args:  []string{"pr", "2015"},
+			extra: map[string]string{"TCTEST_LOCAL_VENDOR_MODE": "deep"},
+			want:  nil,
+		},
 
 		// the simple discovery cases covered by the API-mode tests, mirrored
 		// here to prove the local AST path handles them identically
@@ -330,14 +423,150 @@ func TestASTDiscoveryAzureRM(t *testing.T) {
 			if res.exitCode != tt.wantExit {
 				t.Fatalf("exit code = %d, want %d\noutput:\n%s", res.exitCode, tt.wantExit, res.output)
 			}
-			if !strings.Contains(res.output, "[AST]") {
-				t.Fatalf("expected AST discovery mode to be used\noutput:\n%s", res.output)
+			if mode := cmp.Or(tt.mode, "AST"); !strings.Contains(res.output, "[mode="+mode+"]") {
+				t.Fatalf("expected %s discovery mode to be used\noutput:\n%s", mode, res.output)
 			}
 			assertTriggers(t, tc, res, tt.want)
 		})
 	}
 }
 
+// TestSharedPackageSmokeTests covers --max-traced-resources: a shared package
+// tracing to more resource files runs each service's smoke-tests instead, and
+// services without any keep their traced tests.
+func TestSharedPackageSmokeTests(t *testing.T) {
+	t.Parallel()
+	scenario(t, "ast/azurerm", "shared package over --max-traced-resources runs smoke tests")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermASTPRs)
+	tc := newMockTeamCity(t)
+
+	serviceMap := filepath.Join(t.TempDir(), "services.yaml")
+	err := os.WriteFile(serviceMap, []byte(`services:
+  dns:
+    smoke-tests: [TestAccDnsARecord_basic]
+  postgres:
+    smoke-tests: [TestAccPostgresqlFlexibleServer_basic, TestAccPostgresqlFlexibleServerDatabase_basic]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("writing service map: %v", err)
+	}
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_LOCAL_REPO_PATH"] = cloneUpstream(t, azurermUpstream)
+	res := runTCTest(t, env, "pr", "2012", "--service-map", serviceMap, "--max-traced-resources", "5")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	if !strings.Contains(res.output, "9 resource file(s) traced from shared packages exceeds --max-traced-resources 5") ||
+		!strings.Contains(res.output, "no smoke-tests in the service map for cosmos") {
+		t.Errorf("expected the cap to be reported, and cosmos to have no smoke-tests\noutput:\n%s", res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_COSMOS", "refs/pull/2012/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"},
+		{"TF_E2E_DNS", "refs/pull/2012/merge", "(TestAccDnsARecord_basic)"},
+		{"TF_E2E_POSTGRES", "refs/pull/2012/merge", "(TestAccPostgresqlFlexibleServerDatabase_basic|TestAccPostgresqlFlexibleServer_basic)"},
+	})
+}
+
+func TestSmokeSuites(t *testing.T) {
+	t.Parallel()
+
+	serviceMap := filepath.Join(t.TempDir(), "services.yaml")
+	err := os.WriteFile(serviceMap, []byte(`smoke:
+  internal/provider/**: [postgres:TestAccPostgresqlFlexibleServer_basic, dns:TestAccDnsZone_basic]
+  main.go: [dns:TestAccDnsZone_basic]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("writing service map: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		ast  bool
+		pr   string
+	}{
+		{name: "api", pr: "1009"},
+		{name: "ast", ast: true, pr: "2013"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "smoke", "changes outside the service packages run the smoke suites ("+tt.name+")")
+			prs := azurermPRs
+			if tt.ast {
+				prs = azurermASTPRs
+			}
+			gh := newMockGitHub(t, "testdata/azurerm", prs)
+			tc := newMockTeamCity(t)
+
+			env := azurermEnv(gh, tc)
+			if tt.ast {
+				env["TCTEST_LOCAL_REPO_PATH"] = cloneUpstream(t, azurermUpstream)
+			}
+			res := runTCTest(t, env, "pr", tt.pr, "--service-map", serviceMap)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			if !strings.Contains(res.output, "TestAccDnsZone_basic [SMOKE]") {
+				t.Errorf("expected the smoke tests to be tagged\noutput:\n%s", res.output)
+			}
+			assertTriggers(t, tc, res, []trigger{
+				{"TF_E2E_DNS", "refs/pull/" + tt.pr + "/merge", "(TestAccDnsZone_basic)"},
+				{"TF_E2E_POSTGRES", "refs/pull/" + tt.pr + "/merge", "(TestAccPostgresqlFlexibleServer_basic)"},
+			})
+		})
+	}
+}
+
+// TestModuleBumps covers dependency bump discovery: the modules a PR bumps in
+// go.mod, go.sum and vendor/modules.txt select the tests of the resources
+// importing them, explained grouped by module.
+func TestModuleBumps(t *testing.T) {
+	t.Parallel()
+	scenario(t, "ast/azurerm", "bumped modules select the tests of their importers")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermASTPRs)
+	gh.patches = map[string]string{
+		"go.mod": "@@ -5,5 +5,5 @@ require (\n \tgithub.com/hashicorp/go-azure-helpers v0.71.0\n" +
+			"-\tgithub.com/hashicorp/go-azure-sdk/resource-manager v0.20250131.1134653\n+\tgithub.com/hashicorp/go-azure-sdk/resource-manager v0.20250214.1134653\n" +
+			"-\tgithub.com/hashicorp/go-azure-sdk/sdk v0.20250131.1134653\n+\tgithub.com/hashicorp/go-azure-sdk/sdk v0.20250214.1134653\n )",
+		"go.sum": "@@ -10,2 +10,2 @@\n-github.com/hashicorp/go-azure-sdk/resource-manager v0.20250131.1134653 h1:a=\n" +
+			"+github.com/hashicorp/go-azure-sdk/resource-manager v0.20250214.1134653 h1:b=",
+		"vendor/modules.txt": "@@ -1,1 +1,1 @@\n-# github.com/hashicorp/go-azure-sdk/resource-manager v0.20250131.1134653\n" +
+			"+# github.com/hashicorp/go-azure-sdk/resource-manager v0.20250214.1134653",
+	}
+	tc := newMockTeamCity(t)
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_LOCAL_REPO_PATH"] = cloneUpstream(t, azurermUpstream)
+	res := runTCTest(t, env, "list", "2016", "--explain", "TestAccCosmosDBAccount")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	for _, want := range []string{
+		"tracing imports of 2 bumped module(s)",
+		"module github.com/hashicorp/go-azure-sdk/resource-manager v0.20250131.1134653 → v0.20250214.1134653:",
+		"go.mod, go.sum, vendor/modules.txt [changed file]",
+		"→ github.com/hashicorp/go-azure-sdk/resource-manager/cosmosdb/2024-08-15/cosmosdb [package]",
+	} {
+		if !strings.Contains(res.output, want) {
+			t.Errorf("output missing %q\noutput:\n%s", want, res.output)
+		}
+	}
+	if got := strings.Count(res.output, "→ TestAccCosmosDBAccount [test]"); got != 1 {
+		t.Errorf("explained %d chain(s) for TestAccCosmosDBAccount, want 1\noutput:\n%s", got, res.output)
+	}
+
+	res = runTCTest(t, env, "pr", "2016")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_COSMOS", "refs/pull/2016/merge", "(TestAccCosmosDBAccount|TestAccDataSourceCosmosDBAccount)"},
+		{"TF_E2E_DNS", "refs/pull/2016/merge", "(TestAccAzureRMDNSZoneDataSource|TestAccDataSourceDnsAAAARecord|TestAccDnsAAAARecord|TestAccDnsARecord)"},
+		{"TF_E2E_POSTGRES", "refs/pull/2016/merge", "(TestAccDataSourcePostgresqlflexibleServer|TestAccPostgresqlFlexibleServer|TestAccPostgresqlFlexibleServerDatabase|TestAccPostgresqlFlexibleServerVirtualEndpoint)"},
+	})
+}
+
 // TestJSONOutput locks the --json machine-readable contract: stdout is a valid
 // JSON array of triggered builds and nothing else.
 func TestJSONOutput(t *testing.T) {
@@ -434,6 +663,969 @@ func TestPrsCommand(t *testing.T) {
 				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
 			}
 			assertTriggers(t, tc, res, tt.want)
+
+			// each tested PR and its files are fetched once, for the checks and discovery alike
+			for _, tr := range tt.want {
+				n := strings.TrimSuffix(strings.TrimPrefix(tr.Branch, "refs/pull/"), "/merge")
+				for _, what := range []string{"pulls/" + n, "files/" + n} {
+					if got := gh.fetched(what); got != 1 {
+						t.Errorf("fetched %s %d times, want once", what, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestServiceMap covers --service-map: mapped services trigger their configured
+// build type IDs, unmapped services keep the _SERVICE suffix convention.
+func TestServiceMap(t *testing.T) {
+	t.Parallel()
+	scenario(t, "service-map", "mapped services trigger their configured build types")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	serviceMap := filepath.Join(t.TempDir(), "services.yaml")
+	err := os.WriteFile(serviceMap, []byte(`services:
+  postgres:
+    build-type-ids: [TF_PG_SINGLE, TF_PG_FLEXIBLE]
+    properties:
+      ARM_TEST_LOCATION: westeurope
+`), 0o600)
+	if err != nil {
+		t.Fatalf("writing service map: %v", err)
+	}
+
+	res := runTCTest(t, azurermEnv(gh, tc), "pr", "1004", "--service-map", serviceMap)
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_PG_FLEXIBLE", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+		{"TF_PG_SINGLE", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+	})
+}
+
+func TestMatrix(t *testing.T) {
+	t.Parallel()
+	scenario(t, "matrix", "each discovered service build is triggered once per matrix cell")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "pr", "1004", "--json", "--matrix", "ARM_TEST_LOCATION=westeurope,eastus2")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+	})
+	for _, want := range []string{`"matrix": "ARM_TEST_LOCATION=eastus2"`, `"matrix": "ARM_TEST_LOCATION=westeurope"`} {
+		if !strings.Contains(res.output, want) {
+			t.Errorf("JSON output missing %s\noutput:\n%s", want, res.output)
+		}
+	}
+}
+
+func TestMatrixExceedsMaxBuilds(t *testing.T) {
+	t.Parallel()
+	scenario(t, "matrix", "matrix cells count towards --max-builds-per-pr")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "pr", "1004", "--matrix", "A=1,2,3")
+	if res.exitCode == 0 {
+		t.Fatalf("exit code = 0, want non-zero\noutput:\n%s", res.output)
+	}
+	if !strings.Contains(res.output, "exceeding --max-builds-per-pr") {
+		t.Errorf("output missing the max-builds error\noutput:\n%s", res.output)
+	}
+	assertTriggers(t, tc, res, nil)
+}
+
+func TestMaxRunning(t *testing.T) {
+	t.Parallel()
+	scenario(t, "max-running", "builds are triggered while slots are free")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "pr", "1004", "--max-running", "2", "--max-running-per-service", "1")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+	})
+}
+
+// TestWatch covers the watch command: PRs are only retriggered once their head
+// SHA changes, with the state kept across runs in the state dir.
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	scenario(t, "watch", "prs are retriggered only when they get new commits")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	gh.openPRs = []listPR{
+		{number: 1020, author: "katbyte", labels: []string{"needs-testing"}, head: "aaa"},
+		{number: 1021, author: "someone-else", labels: []string{"bug"}, head: "bbb"},
+	}
+	tc := newMockTeamCity(t)
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_STATE_DIR"] = t.TempDir()
+	args := []string{"watch", "--watch-once", "-l", "needs-testing"}
+
+	first := []trigger{{"TF_E2E_POSTGRES", "refs/pull/1020/merge", "(TestAccPostgresqlFlexibleServer)"}}
+	for _, run := range []struct {
+		name string
+		head string
+		want []trigger
+	}{
+		{"first pass tests the matching pr", "aaa", first},
+		{"unchanged pr is not retriggered", "aaa", first},
+		{"new commit retriggers the pr", "ccc", append(first, first...)},
+	} {
+		gh.openPRs[0].head = run.head
+		res := runTCTest(t, env, args...)
+		if res.exitCode != 0 {
+			t.Fatalf("%s: exit code = %d, want 0\noutput:\n%s", run.name, res.exitCode, res.output)
+		}
+		assertTriggers(t, tc, res, run.want)
+	}
+}
+
+// TestCommentCommands covers the comments command: unacknowledged /tctest
+// comments from permitted users trigger builds and get a reaction and reply.
+func TestCommentCommands(t *testing.T) {
+	t.Parallel()
+	scenario(t, "comments", "permitted /tctest comments trigger builds and are acknowledged")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	gh.comments = map[int][]mockComment{1004: {
+		{id: 1, user: "katbyte", body: "/tctest", reactions: []string{"integration-test:rocket"}},
+		{id: 2, user: "outsider", body: "/tctest --all"},
+		{id: 3, user: "katbyte", body: "/tctest TestAccFoo_basic", reactions: []string{"outsider:rocket"}},
+		{id: 4, user: "friend", body: "/tctest\nthanks!"},
+		{id: 5, user: "katbyte", body: "lgtm"},
+		{id: 6, user: "outsider", body: "/tctest --nope"},
+		{id: 7, user: "katbyte", body: "/tctest --nope"},
+		{id: 8, user: "outsider", body: "/tctest", reactions: []string{"integration-test:-1"}},
+		{id: 9, user: "katbyte", body: "/tctest --nope", reactions: []string{"integration-test:confused"}},
+	}}
+	gh.permissions = map[string]string{"katbyte": "write"}
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "comments", "1004", "--comment-allowlist", "friend")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "TestAccFoo_basic"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "TestAccFoo_basic"},
+	})
+
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	// someone else's 🚀 doesn't mark 3 handled, tctest's reactions mark 1, 8 and 9 handled, and only the permitted
+	// user's unparseable command gets a reply
+	if want := []string{"2:-1", "3:rocket", "4:rocket", "6:-1", "7:confused"}; !slices.Equal(gh.reactions, want) {
+		t.Errorf("reactions = %v, want %v", gh.reactions, want)
+	}
+	if len(gh.replies) != 3 {
+		t.Fatalf("replies = %q, want 3", gh.replies)
+	}
+	for i, who := range []string{"@katbyte", "@friend"} {
+		if !strings.HasPrefix(gh.replies[i], who+" triggered 2 build(s)") || !strings.Contains(gh.replies[i], "viewQueued") {
+			t.Errorf("reply %d = %q, want build links for %s", i, gh.replies[i], who)
+		}
+	}
+	if !strings.HasPrefix(gh.replies[2], "@katbyte unable to parse the command") {
+		t.Errorf("reply 2 = %q, want the parse error", gh.replies[2])
+	}
+}
+
+// TestCommentCommandHolds covers commands on PRs prs would hold: one from a user
+// who can push is the review, one from a user only on the allowlist is held.
+func TestCommentCommandHolds(t *testing.T) {
+	t.Parallel()
+
+	prs := slices.Clone(azurermPRs)
+	for i, pr := range prs {
+		if pr.number == 1021 {
+			prs[i].files = append(slices.Clone(pr.files), changedFile{".teamcity/components/build_config.kt", "modified"})
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		pr   int
+		want trigger
+	}{
+		{"untrusted fork", 1020, trigger{"TF_E2E_POSTGRES", "refs/pull/1020/merge", "(TestAccPostgresqlFlexibleServer)"}},
+		{"sensitive changes", 1021, trigger{"TF_E2E_DNS", "refs/pull/1021/merge", "(TestAccDnsARecord)"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "comments", "commands from allowlisted users are held on "+tt.name)
+			gh := newMockGitHub(t, "testdata/azurerm", prs)
+			gh.openPRs = []listPR{{number: 1020, author: "contributor"}, {number: 1021, author: "katbyte"}}
+			gh.forks = map[int]string{1020: "contributor/terraform-provider-azurerm"}
+			gh.comments = map[int][]mockComment{tt.pr: {
+				{id: 1, user: "friend", body: "/tctest"},
+				{id: 2, user: "katbyte", body: "/tctest"},
+			}}
+			gh.permissions = map[string]string{"katbyte": "write"}
+			tc := newMockTeamCity(t)
+
+			res := runTCTest(t, azurermEnv(gh, tc), "comments", strconv.Itoa(tt.pr), "--comment-allowlist", "friend")
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			assertTriggers(t, tc, res, []trigger{tt.want})
+
+			gh.mu.Lock()
+			defer gh.mu.Unlock()
+			if len(gh.replies) != 2 {
+				t.Fatalf("replies = %q, want 2", gh.replies)
+			}
+			if !strings.HasPrefix(gh.replies[0], "@friend the PR is held for review") {
+				t.Errorf("reply 0 = %q, want the PR held", gh.replies[0])
+			}
+			if !strings.HasPrefix(gh.replies[1], "@katbyte triggered 1 build(s)") {
+				t.Errorf("reply 1 = %q, want the build link", gh.replies[1])
+			}
+		})
+	}
+}
+
+// TestCommentCancel covers /tctest cancel: the PR's queued builds are found by
+// the tctest tag in every per-service build type and cancelled.
+func TestCommentCancel(t *testing.T) {
+	t.Parallel()
+	scenario(t, "comments", "/tctest cancel cancels the PR's builds in every build type")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	gh.permissions = map[string]string{"katbyte": "write"}
+	tc := newMockTeamCity(t)
+
+	if res := runTCTest(t, azurermEnv(gh, tc), "pr", "1004"); res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	gh.mu.Lock()
+	gh.comments = map[int][]mockComment{1004: {{id: 1, user: "katbyte", body: "/tctest cancel"}}}
+	gh.mu.Unlock()
+
+	res := runTCTest(t, azurermEnv(gh, tc), "comments", "1004")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+
+	tc.mu.Lock()
+	cancelled := slices.Sorted(slices.Values(tc.cancelled))
+	tc.mu.Unlock()
+	if want := []int{714001, 714002}; !slices.Equal(cancelled, want) {
+		t.Errorf("cancelled = %v, want %v\noutput:\n%s", cancelled, want, res.output)
+	}
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	if len(gh.replies) != 1 || !strings.HasPrefix(gh.replies[0], "@katbyte cancelled 2 build(s)") {
+		t.Errorf("replies = %q, want the cancelled builds", gh.replies)
+	}
+}
+
+// TestPrDirectives covers tctest: directives in the PR description overriding,
+// extending and pruning the discovered tests.
+func TestPrDirectives(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		body string
+		args []string
+		want []trigger
+	}{
+		{
+			name: "skip and add",
+			body: "tctest-skip: TestAccDnsARecord\ntctest-add: postgres:TestAccPostgresqlExtra_",
+			want: []trigger{{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlExtra_|TestAccPostgresqlFlexibleServer)"}},
+		},
+		{
+			name: "override limited to a service",
+			body: "```tctest\nTestAccOverride_\nservices: dns\n```",
+			want: []trigger{{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccOverride_)"}},
+		},
+		{
+			name: "ignored with --ignore-pr-directives",
+			body: "tctest: TestAccOverride_",
+			args: []string{"--ignore-pr-directives"},
+			want: []trigger{
+				{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+				{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "pr-directives", tt.name)
+			gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+			gh.bodies = map[int]string{1004: tt.body}
+			tc := newMockTeamCity(t)
+
+			res := runTCTest(t, azurermEnv(gh, tc), append([]string{"pr", "1004"}, tt.args...)...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			assertTriggers(t, tc, res, tt.want)
+		})
+	}
+}
+
+// TestForkTrust covers the fork trust policy: prs holds PRs from forks unless
+// the author is trusted or the PR is labelled safe-to-test and a maintainer approved its head commit.
+func TestWatchRevokesSafeToTest(t *testing.T) {
+	t.Parallel()
+	scenario(t, "watch", "a push to a fork pr removes its safe-to-test label")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	gh.openPRs = []listPR{{number: 1020, author: "contributor", labels: []string{"needs-testing", "safe-to-test"}, head: "aaa"}}
+	gh.forks = map[int]string{1020: "contributor/terraform-provider-azurerm"}
+	gh.timelines = map[int][]mockEvent{1020: {{event: "labeled", label: "safe-to-test", at: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}}}
+	tc := newMockTeamCity(t)
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_STATE_DIR"] = t.TempDir()
+	args := []string{"watch", "--watch-once", "-l", "needs-testing"}
+
+	first := []trigger{{"TF_E2E_POSTGRES", "refs/pull/1020/merge", "(TestAccPostgresqlFlexibleServer)"}}
+	for _, run := range []struct {
+		name      string
+		head      string
+		want      []trigger
+		unlabeled []string
+	}{
+		{"the labelled fork pr is tested", "aaa", first, nil},
+		{"a push removes the label and holds the pr", "ccc", first, []string{"1020:safe-to-test"}},
+		{"the pr stays held", "ccc", first, []string{"1020:safe-to-test"}},
+	} {
+		gh.openPRs[0].head = run.head
+		res := runTCTest(t, env, args...)
+		if res.exitCode != 0 {
+			t.Fatalf("%s: exit code = %d, want 0\noutput:\n%s", run.name, res.exitCode, res.output)
+		}
+		assertTriggers(t, tc, res, run.want)
+		if !slices.Equal(gh.unlabeled, run.unlabeled) {
+			t.Errorf("%s: removed labels %v, want %v", run.name, gh.unlabeled, run.unlabeled)
+		}
+		if run.head == "ccc" && !strings.Contains(res.output, "held for review:") {
+			t.Errorf("%s: the pr wasn't held\noutput:\n%s", run.name, res.output)
+		}
+	}
+}
+
+func TestForkTrust(t *testing.T) {
+	t.Parallel()
+
+	at := func(hour int) time.Time { return time.Date(2026, 1, 1, hour, 0, 0, 0, time.UTC) }
+	postgres := trigger{"TF_E2E_POSTGRES", "refs/pull/1020/merge", "(TestAccPostgresqlFlexibleServer)"}
+	dns := trigger{"TF_E2E_DNS", "refs/pull/1021/merge", "(TestAccDnsARecord)"}
+
+	cases := []struct {
+		name     string
+		labels   []string
+		timeline []mockEvent
+		args     []string
+		want     []trigger
+		held     int
+	}{
+		{
+			name: "forks are held for review",
+			held: 2,
+		},
+		{
+			name: "trusted author",
+			args: []string{"--trusted-authors", "contributor"},
+			want: []trigger{postgres},
+			held: 1,
+		},
+		{
+			name: "trusted org member",
+			args: []string{"--trusted-orgs", "hashicorp"},
+			want: []trigger{dns},
+			held: 1,
+		},
+		{
+			name:     "labelled safe-to-test",
+			labels:   []string{"safe-to-test"},
+			timeline: []mockEvent{{event: "committed", at: at(1)}, {event: "labeled", label: "safe-to-test", at: at(2)}},
+			want:     []trigger{postgres},
+			held:     1,
+		},
+		{
+			name:     "force pushed after being labelled",
+			labels:   []string{"safe-to-test"},
+			timeline: []mockEvent{{event: "labeled", label: "safe-to-test", at: at(1)}, {event: "head_ref_force_pushed", at: at(2)}},
+			held:     2,
+		},
+		{
+			name:     "labelled again after a force push",
+			labels:   []string{"safe-to-test"},
+			timeline: []mockEvent{{event: "labeled", label: "safe-to-test", at: at(1)}, {event: "head_ref_force_pushed", at: at(2)}, {event: "labeled", label: "safe-to-test", at: at(3)}},
+			want:     []trigger{postgres},
+			held:     1,
+		},
+		{
+			name: "trust-all",
+			args: []string{"--fork-policy", "trust-all"},
+			want: []trigger{postgres, dns},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "fork-trust", tt.name)
+			gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+			gh.openPRs = []listPR{
+				{number: 1020, author: "contributor", labels: tt.labels, head: "head2"},
+				{number: 1021, author: "someone-else"},
+			}
+			gh.forks = map[int]string{1020: "contributor/terraform-provider-azurerm", 1021: "someone-else/terraform-provider-azurerm"}
+			gh.timelines = map[int][]mockEvent{1020: tt.timeline}
+			gh.orgMembers = map[string][]string{"hashicorp": {"someone-else"}}
+			tc := newMockTeamCity(t)
+
+			res := runTCTest(t, azurermEnv(gh, tc), append([]string{"prs"}, tt.args...)...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			assertTriggers(t, tc, res, tt.want)
+			if got := strings.Count(res.output, "held for review:"); got != tt.held {
+				t.Errorf("held %d PR(s), want %d\noutput:\n%s", got, tt.held, res.output)
+			}
+		})
+	}
+}
+
+// TestSensitiveChanges covers PRs changing the CI setup: they are always
+// flagged, and prs holds them unless they are allowed.
+func TestSensitiveChanges(t *testing.T) {
+	t.Parallel()
+
+	prs := slices.Clone(azurermPRs)
+	for i, pr := range prs {
+		if pr.number == 1020 {
+			prs[i].files = append(slices.Clone(pr.files), changedFile{".teamcity/components/build_config.kt", "modified"}, changedFile{"go.mod", "modified"})
+		}
+	}
+	postgres := trigger{"TF_E2E_POSTGRES", "refs/pull/1020/merge", "(TestAccPostgresqlFlexibleServer)"}
+	dns := trigger{"TF_E2E_DNS", "refs/pull/1021/merge", "(TestAccDnsARecord)"}
+
+	cases := []struct {
+		name    string
+		args    []string
+		omitted string // a file GitHub sends without a patch
+		want    []trigger
+		held    int
+	}{
+		{
+			name: "prs holds sensitive changes",
+			args: []string{"prs"},
+			want: []trigger{dns},
+			held: 1,
+		},
+		{
+			name: "prs with --allow-sensitive",
+			args: []string{"prs", "--allow-sensitive"},
+			want: []trigger{postgres, dns},
+		},
+		{
+			name: "prs with the allow label",
+			args: []string{"prs", "--allow-sensitive-label", "ci-reviewed"},
+			want: []trigger{postgres, dns},
+		},
+		{
+			name: "pr only warns",
+			args: []string{"pr", "1020"},
+			want: []trigger{postgres},
+		},
+		{
+			name:    "prs holds go files without a patch",
+			args:    []string{"prs"},
+			omitted: "internal/services/dns/dns_a_record_resource.go",
+			held:    2,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "sensitive", tt.name)
+			gh := newMockGitHub(t, "testdata/azurerm", prs)
+			gh.openPRs = []listPR{
+				{number: 1020, author: "katbyte", labels: []string{"ci-reviewed"}},
+				{number: 1021, author: "katbyte"},
+			}
+			gh.patches = map[string]string{"go.mod": "@@ -5,3 +5,4 @@\n+replace github.com/hashicorp/go-azure-sdk => github.com/someone/go-azure-sdk v0.0.1"}
+			if tt.omitted != "" {
+				gh.patches[tt.omitted] = ""
+			}
+			tc := newMockTeamCity(t)
+
+			res := runTCTest(t, azurermEnv(gh, tc), tt.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			assertTriggers(t, tc, res, tt.want)
+			for _, want := range []string{".teamcity/components/build_config.kt", "go.mod replace directive"} {
+				if !strings.Contains(res.output, want) {
+					t.Errorf("output doesn't flag %q\noutput:\n%s", want, res.output)
+				}
+			}
+			if got := strings.Count(res.output, "held for review:"); got != tt.held {
+				t.Errorf("held %d PR(s), want %d\noutput:\n%s", got, tt.held, res.output)
+			}
+		})
+	}
+}
+
+// TestAuditLog covers the audit log: triggers and cancels are recorded with
+// secrets redacted, repeats are flagged, and history, results --last and
+// cancel --last read it back.
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+	scenario(t, "audit", "builds are recorded and browsable with history")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_STATE_DIR"] = t.TempDir()
+	env["TCTEST_PROPERTIES"] = "ARM_CLIENT_SECRET=hunter2;ARM_TEST_LOCATION=westeurope"
+
+	run := func(args ...string) runResult {
+		t.Helper()
+		res := runTCTest(t, env, args...)
+		if res.exitCode != 0 {
+			t.Fatalf("tctest %v: exit code = %d, want 0\noutput:\n%s", args, res.exitCode, res.output)
+		}
+		return res
+	}
+
+	run("pr", "1004")
+	if res := run("pr", "1004"); strings.Count(res.output, "already triggered as build") != 2 {
+		t.Errorf("retesting the same merge commit wasn't flagged\noutput:\n%s", res.output)
+	}
+	run("pr", "1021")
+
+	var records []struct {
+		Action     string            `json:"action"`
+		PR         int               `json:"pr"`
+		MergeSHA   string            `json:"merge_sha"`
+		Service    string            `json:"service"`
+		BuildID    int               `json:"build_id"`
+		Pattern    string            `json:"pattern"`
+		Properties map[string]string `json:"properties"`
+		Mode       string            `json:"mode"`
+	}
+	if err := json.Unmarshal([]byte(run("history", "--json", "--pr", "1004", "--since", "1d").output), &records); err != nil {
+		t.Fatalf("parsing history --json: %v", err)
+	}
+	var actions []string
+	for _, r := range records {
+		actions = append(actions, r.Action)
+		if r.PR != 1004 || r.MergeSHA != mergeSHA || r.Mode != "api" || r.BuildID == 0 || r.Pattern == "" {
+			t.Errorf("incomplete record: %+v", r)
+		}
+		if r.Properties["ARM_CLIENT_SECRET"] != "[REDACTED]" || r.Properties["ARM_TEST_LOCATION"] != "westeurope" {
+			t.Errorf("properties = %v, want the secret redacted", r.Properties)
+		}
+	}
+	if want := []string{"trigger", "trigger", "rerun", "rerun"}; !slices.Equal(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+
+	// the mock has no build logs, so only check the right build is looked up
+	if res := runTCTest(t, env, "results", "--last"); !strings.Contains(res.output, "PR #1021 [dns] build 714005") {
+		t.Errorf("results --last didn't show the last run's build\noutput:\n%s", res.output)
+	}
+
+	run("cancel", "--last")
+	tc.mu.Lock()
+	cancelled := slices.Clone(tc.cancelled)
+	tc.mu.Unlock()
+	if want := []int{714005}; !slices.Equal(cancelled, want) {
+		t.Errorf("cancelled = %v, want %v", cancelled, want)
+	}
+	if res := run("history"); !strings.Contains(res.output, "cancel") || !strings.Contains(res.output, "6 of 6 audit log entries") {
+		t.Errorf("history doesn't include the cancel\noutput:\n%s", res.output)
+	}
+
+	// the audit log's flags are only on the commands that read it
+	for flag, args := range map[string][]string{"--last": {"pr", "1004", "--last"}, "--since": {"prs", "--since", "1d"}, "--pr": {"results", "--pr", "1004"}} {
+		if res := runTCTest(t, env, args...); res.exitCode == 0 || !strings.Contains(res.output, "unknown flag: "+flag) {
+			t.Errorf("tctest %v accepted %s\noutput:\n%s", args, flag, res.output)
+		}
+	}
+}
+
+// TestProfiles covers --profile: the profile's settings and inline service map
+// apply, flags still override them.
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+	scenario(t, "profiles", "a named profile sets the build types, flags override it")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+
+	profiles := filepath.Join(t.TempDir(), "profiles.yaml")
+	err := os.WriteFile(profiles, []byte(`profiles:
+  aws:
+    repo: hashicorp/terraform-provider-aws
+    build-type-id: TF_AWS
+  azurerm:
+    repo: hashicorp/terraform-provider-azurerm
+    build-type-id: TF_PROFILE
+    build-type-id-add-service-suffix: false
+    services:
+      postgres:
+        build-type-ids: [TF_PG_PROFILE]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("writing profiles: %v", err)
+	}
+
+	run := func(tc *mockTeamCity, args ...string) runResult {
+		env := azurermEnv(gh, tc)
+		env["TCTEST_BUILD_TYPE_ID"] = "" // from the profile
+		env["TCTEST_BUILD_TYPE_ID_ADD_SERVICE_SUFFIX"] = ""
+		env["TCTEST_PROFILES_FILE"] = profiles
+		return runTCTest(t, env, append([]string{"pr", "1004"}, args...)...)
+	}
+
+	tc := newMockTeamCity(t)
+	res := run(tc, "--profile", "azurerm")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_PG_PROFILE", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+		{"TF_PROFILE", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+	})
+
+	tc = newMockTeamCity(t)
+	res = run(tc, "--profile", "azurerm", "--build-type-id", "TF_FLAG")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_FLAG", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_PG_PROFILE", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+	})
+
+	res = run(newMockTeamCity(t), "--profile", "gcp")
+	if res.exitCode == 0 || !strings.Contains(res.output, "unknown profile gcp, expected one of: aws, azurerm") {
+		t.Errorf("unknown profile: exit code = %d, want an error\noutput:\n%s", res.exitCode, res.output)
+	}
+}
+
+// TestDetectRepo covers running tctest inside a clone of a fork: --repo comes
+// from the upstream remote and the provider layout from the repo name.
+func TestDetectRepo(t *testing.T) {
+	t.Parallel()
+	scenario(t, "profiles", "--repo is detected from the upstream remote of a fork clone")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	clone := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", "git@github.com:someone/terraform-provider-azurerm.git"},
+		{"remote", "add", "upstream", "https://github.com/hashicorp/terraform-provider-azurerm"},
+	} {
+		if err := runGit(clone, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := azurermEnv(gh, tc)
+	env["TCTEST_REPO"] = ""
+	res := runTCTestIn(t, clone, env, "pr", "1004", "--verbose")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	for _, want := range []string{"detected repo hashicorp/terraform-provider-azurerm from the upstream remote", "using the terraform-provider-azurerm provider layout"} {
+		if !strings.Contains(res.output, want) {
+			t.Errorf("output missing %q\noutput:\n%s", want, res.output)
+		}
+	}
+	assertTriggers(t, tc, res, []trigger{
+		{"TF_E2E_DNS", "refs/pull/1004/merge", "(TestAccDnsARecord)"},
+		{"TF_E2E_POSTGRES", "refs/pull/1004/merge", "(TestAccPostgresqlFlexibleServer)"},
+	})
+}
+
+// TestDoctor covers the doctor self-check: everything passes against the
+// mocks, and missing per-service build types and credentials come with fixes.
+func TestDoctor(t *testing.T) {
+	t.Parallel()
+	scenario(t, "doctor", "doctor checks GitHub, TeamCity and the configuration")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+	tc.buildTypes = []string{"TF_E2E_COSMOS", "TF_E2E_DNS", "TF_E2E_POSTGRES"}
+
+	env := azurermEnv(gh, tc)
+	env["GITHUB_TOKEN"] = "ghp_integration"
+	res := runTCTest(t, env, "doctor", "--mode", "api")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	for _, want := range []string{
+		"token authenticates as integration-test",
+		"token scopes: repo, read:org",
+		"rate limit: 4999 of 5000 requests left",
+		"hashicorp/terraform-provider-azurerm is accessible",
+		"(TeamCity 2025.07)",
+		"all 3 per-service build types exist",
+		"$TCTEST_SERVER",
+		"--mode",
+		"everything looks good",
+	} {
+		if !strings.Contains(res.output, want) {
+			t.Errorf("output missing %q\noutput:\n%s", want, res.output)
+		}
+	}
+	if strings.Contains(res.output, "ghp_integration") {
+		t.Errorf("output shows the GitHub token\noutput:\n%s", res.output)
+	}
+
+	tc.buildTypes = []string{"TF_E2E_DNS"}
+	env["TCTEST_TOKEN_TC"] = ""
+	res = runTCTest(t, env, "doctor", "--mode", "api")
+	if res.exitCode == 0 || !strings.Contains(res.output, "no credentials") || !strings.Contains(res.output, "TCTEST_TOKEN_TC") {
+		t.Errorf("no TeamCity token: exit code = %d, want a failure with a fix\noutput:\n%s", res.exitCode, res.output)
+	}
+
+	env["TCTEST_TOKEN_TC"] = "integration-test-token"
+	res = runTCTest(t, env, "doctor", "--mode", "api")
+	if res.exitCode != 0 || !strings.Contains(res.output, "2 of 3 per-service build types don't exist: TF_E2E_COSMOS, TF_E2E_POSTGRES") {
+		t.Errorf("missing build types: exit code = %d, want 0 with a warning\noutput:\n%s", res.exitCode, res.output)
+	}
+}
+
+// TestListJSON locks the list --json discovery schema: changed files with
+// their types, test files with how they were found, and per-service tests
+// and patterns.
+func TestListJSON(t *testing.T) {
+	t.Parallel()
+	scenario(t, "output", "list --json emits the discovery as a JSON object")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--json", "--mode", "api")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+
+	type file struct {
+		Path         string   `json:"path"`
+		Service      string   `json:"service"`
+		Type         string   `json:"type"`
+		DiscoveredBy []string `json:"discovered_by"`
+	}
+	var d struct {
+		PR           int    `json:"pr"`
+		Mode         string `json:"mode"`
+		MergeSHA     string `json:"merge_sha"`
+		ChangedFiles []file `json:"changed_files"`
+		TestFiles    []file `json:"test_files"`
+		Services     []struct {
+			Service string   `json:"service"`
+			Tests   []string `json:"tests"`
+			Pattern string   `json:"pattern"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(res.output)), &d); err != nil {
+		t.Fatalf("--json output is not a bare JSON object: %v\noutput:\n%s", err, res.output)
+	}
+
+	if d.PR != 1004 || d.Mode != "api" || d.MergeSHA == "" {
+		t.Errorf("pr, mode, merge_sha = %d, %q, %q, want 1004, api and a SHA", d.PR, d.Mode, d.MergeSHA)
+	}
+	changed := map[string]string{}
+	for _, f := range d.ChangedFiles {
+		changed[f.Path] = f.Type
+	}
+	if want := map[string]string{
+		"internal/services/postgres/postgresql_flexible_server_resource_test.go": "TEST",
+		"internal/services/dns/dns_a_record_resource.go":                         "RESOURCE",
+	}; !maps.Equal(changed, want) {
+		t.Errorf("changed files = %v, want %v", changed, want)
+	}
+	tests := map[string]string{}
+	for _, f := range d.TestFiles {
+		tests[f.Path] = strings.Join(f.DiscoveredBy, "+")
+	}
+	if want := map[string]string{
+		"internal/services/postgres/postgresql_flexible_server_resource_test.go": "CHANGED",
+		"internal/services/dns/dns_a_record_resource_test.go":                    "DERIVED",
+	}; !maps.Equal(tests, want) {
+		t.Errorf("test files = %v, want %v", tests, want)
+	}
+	if len(d.Services) != 2 || d.Services[0].Service != "dns" || d.Services[0].Pattern != "(TestAccDnsARecord)" ||
+		d.Services[1].Service != "postgres" || d.Services[1].Pattern != "(TestAccPostgresqlFlexibleServer)" {
+		t.Errorf("services = %+v, want dns and postgres with their patterns", d.Services)
+	}
+}
+
+func TestListExplain(t *testing.T) {
+	t.Parallel()
+	scenario(t, "output", "list --explain shows the chain from the changed file to each test")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	res := runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--explain", "TestAccDnsARecord_basic,TestAccNotSelected")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	for _, want := range []string{
+		"why TestAccDnsARecord (dns):",
+		"internal/services/dns/dns_a_record_resource.go [changed file]",
+		"→ internal/services/dns/dns_a_record_resource_test.go [test file]",
+		"→ TestAccDnsARecord [test]",
+		"why TestAccNotSelected: not selected",
+	} {
+		if !strings.Contains(res.output, want) {
+			t.Errorf("output missing %q\noutput:\n%s", want, res.output)
+		}
+	}
+	if strings.Contains(res.output, "why TestAccPostgresqlFlexibleServer") {
+		t.Errorf("explained a test not asked about\noutput:\n%s", res.output)
+	}
+
+	res = runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--explain-all")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	if !strings.Contains(res.output, "why TestAccPostgresqlFlexibleServer (postgres):") ||
+		!strings.Contains(res.output, "internal/services/postgres/postgresql_flexible_server_resource_test.go [changed file]") {
+		t.Errorf("--explain-all did not explain the changed test file\noutput:\n%s", res.output)
+	}
+}
+
+func TestListGraph(t *testing.T) {
+	t.Parallel()
+	scenario(t, "output", "list --graph writes the discovery graph as DOT or mermaid")
+	gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+	tc := newMockTeamCity(t)
+
+	out := filepath.Join(t.TempDir(), "pr.dot")
+	res := runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--graph", out)
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	if !strings.Contains(res.output, "graph written to "+out) {
+		t.Errorf("output doesn't say where the graph was written\noutput:\n%s", res.output)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"digraph tctest {",
+		`[label="internal/services/dns/dns_a_record_resource.go", shape=box`,
+		`[label="internal/services/dns/dns_a_record_resource_test.go", shape=box`,
+		`[label="TestAccDnsARecord", shape=ellipse]`,
+		`[label="TestAccPostgresqlFlexibleServer", shape=ellipse]`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("graph missing %q:\n%s", want, b)
+		}
+	}
+
+	res = runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--graph", "-", "--graph-format", "mermaid", "--quiet")
+	if res.exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+	}
+	if !strings.HasPrefix(res.output, "flowchart LR") || !strings.Contains(res.output, `(["TestAccDnsARecord"])`) {
+		t.Errorf("--graph - --graph-format mermaid --quiet didn't print only a mermaid flowchart\noutput:\n%s", res.output)
+	}
+
+	res = runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--graph", "-")
+	if res.exitCode == 0 || !strings.Contains(res.output, "--graph - needs --quiet or --silent") {
+		t.Errorf("--graph - was mixed with the discovery output\noutput:\n%s", res.output)
+	}
+
+	res = runTCTest(t, azurermEnv(gh, tc), "pr", "1004", "--graph", out)
+	if res.exitCode == 0 || !strings.Contains(res.output, "unknown flag: --graph") {
+		t.Errorf("pr accepted list's --graph\noutput:\n%s", res.output)
+	}
+
+	res = runTCTest(t, azurermEnv(gh, tc), "list", "1004", "--mode", "api", "--graph", out, "--graph-format", "svg")
+	if res.exitCode == 0 || !strings.Contains(res.output, "unknown --graph-format") {
+		t.Errorf("an unknown --graph-format was accepted\noutput:\n%s", res.output)
+	}
+}
+
+// TestChangedTestFunctions covers a PR only changing a test file: just the tests
+// it edited, or whose config functions it edited, are selected.
+func TestChangedTestFunctions(t *testing.T) {
+	t.Parallel()
+
+	const file = "internal/services/postgres/postgresql_flexible_server_resource_test.go"
+	cases := []struct {
+		name  string
+		patch string
+		args  []string
+		want  []string
+	}{
+		{
+			name:  "test function edited",
+			patch: "@@ -44,3 +44,3 @@ func TestAccPostgresqlFlexibleServer_requiresImport(t *testing.T) {\n \t\t},\n-\t\tdata.RequiresImportErrorStep(r.requiresImport),\n+\t\tdata.RequiresImportErrorStep(r.requiresImport), // edited\n \t})",
+			want:  []string{"TestAccPostgresqlFlexibleServer_requiresImport"},
+		},
+		{
+			name:  "config function edited",
+			patch: "@@ -97,1 +97,1 @@ func (r PostgresqlFlexibleServerResource) basic(data acceptance.TestData) string {\n-  name = \"a\"\n+  name = \"b\"",
+			want:  []string{"TestAccPostgresqlFlexibleServer_basic", "TestAccPostgresqlFlexibleServer_requiresImport"},
+		},
+		{
+			name:  "imports edited",
+			patch: "@@ -3,1 +3,1 @@\n-import \"a\"\n+import \"b\"",
+			want:  []string{"TestAccPostgresqlFlexibleServer"},
+		},
+		{
+			name:  "whole file",
+			patch: "@@ -44,3 +44,3 @@\n-a\n+b",
+			args:  []string{"--whole-file-symbols"},
+			want:  []string{"TestAccPostgresqlFlexibleServer"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scenario(t, "discovery", "only the changed test functions are selected: "+tt.name)
+			gh := newMockGitHub(t, "testdata/azurerm", azurermPRs)
+			gh.patches = map[string]string{file: tt.patch}
+			tc := newMockTeamCity(t)
+
+			res := runTCTest(t, azurermEnv(gh, tc), append([]string{"list", "1001", "--json", "--mode", "api"}, tt.args...)...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0\noutput:\n%s", res.exitCode, res.output)
+			}
+			var d struct {
+				Services []struct {
+					Service string   `json:"service"`
+					Tests   []string `json:"tests"`
+				} `json:"services"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimSpace(res.output)), &d); err != nil {
+				t.Fatalf("--json output is not a bare JSON object: %v\noutput:\n%s", err, res.output)
+			}
+			if len(d.Services) != 1 || d.Services[0].Service != "postgres" || !slices.Equal(d.Services[0].Tests, tt.want) {
+				t.Errorf("services = %+v, want postgres with %v", d.Services, tt.want)
+			}
 		})
 	}
 }
@@ -8,6 +8,7 @@ package integration
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"encoding/xml"
@@ -20,11 +21,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 const mergeSHA = "0123456789abcdef0123456789abcdef01234567"
@@ -173,13 +176,62 @@ type listPR struct {
 	author string
 	labels []string
 	draft  bool
+	head   string // head SHA, for watch
+}
+
+// mockEvent is a PR timeline event: a label being added, a commit or a force push.
+type mockEvent struct {
+	event string // "labeled", "committed" or "head_ref_force_pushed"
+	label string
+	at    time.Time
+}
+
+// mockComment is a PR comment served to the comments command.
+type mockComment struct {
+	id        int64
+	user      string
+	body      string
+	reactions []string // "<login>:<content>", tctest is integration-test
 }
 
 type mockGitHub struct {
 	srv     *httptest.Server
 	fixture string // fixture tree that backs raw downloads and contents listings
 	prs     map[int]prDef
-	openPRs []listPR // served by GET /repos/{o}/{r}/pulls for the prs command
+	openPRs []listPR       // served by GET /repos/{o}/{r}/pulls for the prs command
+	bodies  map[int]string // PR descriptions, for test directives
+
+	// fork trust: forks maps PRs to the fork they come from (others come from the base repo)
+	forks      map[int]string
+	timelines  map[int][]mockEvent
+	orgMembers map[string][]string // org -> public members
+	patches    map[string]string   // file path -> diff served with the PR's files, see filePatch
+
+	// comment commands: comments and permissions are served, reactions and replies recorded
+	comments    map[int][]mockComment
+	permissions map[string]string // login -> permission, missing means none
+
+	mu        sync.Mutex
+	reactions []string // "<comment id>:<content>"
+	replies   []string
+	unlabeled []string       // "<pr>:<label>", labels removed from open PRs
+	fetches   map[string]int // "<kind>/<pr>" -> requests, for the PR and its files
+}
+
+// fetched returns how many times a PR ("pulls/<n>") or its files ("files/<n>") were requested.
+func (m *mockGitHub) fetched(what string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fetches[what]
+}
+
+func (m *mockGitHub) countFetch(what string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fetches == nil {
+		m.fetches = map[string]int{}
+	}
+	m.fetches[what]++
 }
 
 func newMockGitHub(t *testing.T, fixtureDir string, prs []prDef) *mockGitHub {
@@ -193,6 +245,15 @@ func newMockGitHub(t *testing.T, fixtureDir string, prs []prDef) *mockGitHub {
 	return m
 }
 
+// filePatch is the diff served for a changed file: its entry in patches, where an empty one is a patch GitHub
+// omitted, or a hunk without lines, which tctest treats as a change anywhere in the file.
+func (m *mockGitHub) filePatch(path string) string {
+	if patch, ok := m.patches[path]; ok {
+		return patch
+	}
+	return "@@ -1,0 +1,0 @@"
+}
+
 func (m *mockGitHub) apiURL() string { return m.srv.URL }
 func (m *mockGitHub) rawURL() string { return m.srv.URL + "/raw" }
 
@@ -217,22 +278,133 @@ func (m *mockGitHub) handle(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		_, _ = w.Write(b) //nolint:gosec // G705: fixture bytes served to the binary under test, not a browser
 
+	// /user, /rate_limit and /repos/{owner}/{repo} — for doctor
+	case len(parts) == 1 && parts[0] == "user":
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		writeJSON(w, map[string]any{"login": "integration-test"})
+	case len(parts) == 1 && parts[0] == "rate_limit":
+		writeJSON(w, map[string]any{"resources": map[string]any{"core": map[string]any{"limit": 5000, "remaining": 4999, "reset": time.Now().Add(time.Hour).Unix()}}})
+	case parts[0] == "repos" && len(parts) == 3:
+		writeJSON(w, map[string]any{"full_name": parts[1] + "/" + parts[2]})
+
 	// /repos/{owner}/{repo}/...
 	case parts[0] == "repos" && len(parts) >= 4:
-		m.handleAPI(w, parts[3:])
+		m.handleAPI(w, r, parts[1]+"/"+parts[2], parts[3:])
+
+	// /orgs/{org}/members/{user} — 204 for members, 404 otherwise
+	case parts[0] == "orgs" && len(parts) == 4 && parts[2] == "members":
+		if slices.Contains(m.orgMembers[parts[1]], parts[3]) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		jsonNotFound(w)
 
 	default:
 		jsonNotFound(w)
 	}
 }
 
-func (m *mockGitHub) handleAPI(w http.ResponseWriter, rest []string) {
+func (m *mockGitHub) handleAPI(w http.ResponseWriter, r *http.Request, repo string, rest []string) {
 	switch {
+	// issues/{n}/timeline
+	case len(rest) == 3 && rest[0] == "issues" && rest[2] == "timeline":
+		n, _ := strconv.Atoi(rest[1])
+		out := make([]map[string]any, 0, len(m.timelines[n]))
+		for _, e := range m.timelines[n] {
+			at := e.at.Format(time.RFC3339)
+			switch e.event {
+			case "committed":
+				out = append(out, map[string]any{"event": e.event, "committer": map[string]any{"date": at}, "author": map[string]any{"date": at}})
+			default:
+				out = append(out, map[string]any{"event": e.event, "label": map[string]any{"name": e.label}, "created_at": at})
+			}
+		}
+		writeJSON(w, out)
+
+	// issues/{n}/comments — list comments, or record a reply
+	case len(rest) == 3 && rest[0] == "issues" && rest[2] == "comments":
+		n, _ := strconv.Atoi(rest[1])
+		if r.Method == http.MethodPost {
+			var c struct {
+				Body string `json:"body"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&c)
+			m.mu.Lock()
+			m.replies = append(m.replies, c.Body)
+			m.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, map[string]any{"id": 1, "body": c.Body})
+			return
+		}
+		out := make([]map[string]any, 0, len(m.comments[n]))
+		for _, c := range m.comments[n] {
+			out = append(out, map[string]any{
+				"id":        c.id,
+				"body":      c.body,
+				"user":      map[string]any{"login": c.user},
+				"reactions": map[string]any{"total_count": len(c.reactions)},
+			})
+		}
+		writeJSON(w, out)
+
+	// issues/comments/{id}/reactions — list a comment's reactions, or record one
+	case len(rest) == 4 && rest[0] == "issues" && rest[1] == "comments" && rest[3] == "reactions" && r.Method == http.MethodGet:
+		out := []map[string]any{}
+		for _, comments := range m.comments {
+			for _, c := range comments {
+				if strconv.FormatInt(c.id, 10) != rest[2] {
+					continue
+				}
+				for _, reaction := range c.reactions {
+					login, content, _ := strings.Cut(reaction, ":")
+					out = append(out, map[string]any{"content": content, "user": map[string]any{"login": login}})
+				}
+			}
+		}
+		writeJSON(w, out)
+	case len(rest) == 4 && rest[0] == "issues" && rest[1] == "comments" && rest[3] == "reactions":
+		var rc struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&rc)
+		m.mu.Lock()
+		m.reactions = append(m.reactions, rest[2]+":"+rc.Content)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": 1, "content": rc.Content})
+
+	// issues/{n}/labels/{name} — remove a label from an open PR
+	case len(rest) == 4 && rest[0] == "issues" && rest[2] == "labels" && r.Method == http.MethodDelete:
+		n, _ := strconv.Atoi(rest[1])
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, pr := range m.openPRs {
+			if pr.number == n && slices.Contains(pr.labels, rest[3]) {
+				m.openPRs[i].labels = slices.DeleteFunc(slices.Clone(pr.labels), func(l string) bool { return l == rest[3] })
+				m.unlabeled = append(m.unlabeled, rest[1]+":"+rest[3])
+				writeJSON(w, []map[string]any{})
+				return
+			}
+		}
+		jsonNotFound(w)
+
+	// collaborators/{user}/permission
+	case len(rest) == 3 && rest[0] == "collaborators" && rest[2] == "permission":
+		perm, ok := m.permissions[rest[1]]
+		if !ok {
+			perm = "none"
+		}
+		writeJSON(w, map[string]any{"permission": perm, "role_name": perm})
+
 	// pulls — list open PRs (single page)
 	case len(rest) == 1 && rest[0] == "pulls":
 		out := make([]map[string]any, 0, len(m.openPRs))
 		for _, pr := range m.openPRs {
 			def := m.prs[pr.number]
+			head := repo
+			if fork, ok := m.forks[pr.number]; ok {
+				head = fork
+			}
 			labels := make([]map[string]any, 0, len(pr.labels))
 			for _, l := range pr.labels {
 				labels = append(labels, map[string]any{"name": l})
@@ -244,27 +416,52 @@ func (m *mockGitHub) handleAPI(w http.ResponseWriter, rest []string) {
 				"user":   map[string]any{"login": pr.author},
 				"labels": labels,
 				"draft":  pr.draft,
+				"head":   map[string]any{"sha": pr.head, "repo": map[string]any{"full_name": head}},
+				"base":   map[string]any{"repo": map[string]any{"full_name": repo}},
+
+				"merge_commit_sha": mergeSHA,
 			})
 		}
 		writeJSON(w, out)
 
 	// pulls/{n}
 	case len(rest) == 2 && rest[0] == "pulls":
+		m.countFetch("pulls/" + rest[1])
 		n, err := strconv.Atoi(rest[1])
 		pr, ok := m.prs[n]
 		if err != nil || !ok {
 			jsonNotFound(w)
 			return
 		}
+		head := repo
+		if fork, ok := m.forks[n]; ok {
+			head = fork
+		}
+		var author, sha string
+		labels := []map[string]any{}
+		for _, l := range m.openPRs {
+			if l.number == n {
+				author, sha = l.author, l.head
+				for _, name := range l.labels {
+					labels = append(labels, map[string]any{"name": name})
+				}
+			}
+		}
 		writeJSON(w, map[string]any{
 			"number":           pr.number,
 			"state":            pr.state,
 			"title":            pr.title,
+			"body":             m.bodies[n],
+			"user":             map[string]any{"login": author},
+			"labels":           labels,
+			"head":             map[string]any{"sha": sha, "repo": map[string]any{"full_name": head}},
+			"base":             map[string]any{"repo": map[string]any{"full_name": repo}},
 			"merge_commit_sha": mergeSHA,
 		})
 
 	// pulls/{n}/files
 	case len(rest) == 3 && rest[0] == "pulls" && rest[2] == "files":
+		m.countFetch("files/" + rest[1])
 		n, err := strconv.Atoi(rest[1])
 		pr, ok := m.prs[n]
 		if err != nil || !ok {
@@ -273,7 +470,7 @@ func (m *mockGitHub) handleAPI(w http.ResponseWriter, rest []string) {
 		}
 		files := make([]map[string]any, 0, len(pr.files))
 		for _, f := range pr.files {
-			files = append(files, map[string]any{"filename": f.path, "status": f.status})
+			files = append(files, map[string]any{"filename": f.path, "status": f.status, "patch": m.filePatch(f.path)})
 		}
 		writeJSON(w, files)
 
@@ -322,9 +519,14 @@ type trigger struct {
 type mockTeamCity struct {
 	srv *httptest.Server
 
-	mu       sync.Mutex
-	triggers []trigger
-	nextID   int
+	buildTypes []string // served for doctor, the triggers don't check them
+
+	mu        sync.Mutex
+	triggers  []trigger
+	throttled int   // triggers queued with the tctest tag --max-running counts
+	tagged    []int // the IDs of the builds queued with the tctest tag, by trigger
+	nextID    int
+	cancelled []int
 }
 
 func newMockTeamCity(t *testing.T) *mockTeamCity {
@@ -336,6 +538,65 @@ func newMockTeamCity(t *testing.T) *mockTeamCity {
 }
 
 func (m *mockTeamCity) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/app/rest/2018.1/server/version":
+		_, _ = w.Write([]byte("2025.07"))
+		return
+	case "/app/rest/2018.1/buildTypes":
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte("<buildTypes>"))
+		for _, id := range m.buildTypes {
+			_, _ = fmt.Fprintf(w, `<buildType id="%s"/>`, id)
+		}
+		_, _ = w.Write([]byte("</buildTypes>"))
+		return
+	}
+
+	// --max-running counts active builds by tag; builds never finish here, so every trigger queued with the tag
+	// stays active. Only the global tctest tag is tracked, per-service tags always report no builds.
+	if r.Method == http.MethodGet && r.URL.Path == "/app/rest/2018.1/builds" {
+		// cancel lists the queued builds of a PR's branch by tag
+		locator := r.URL.Query().Get("locator")
+		if rest, ok := strings.CutPrefix(locator, "tag:tctest,branch:name:"); ok {
+			branch, state, _ := strings.Cut(rest, ",state:")
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte("<builds>"))
+			m.mu.Lock()
+			for i, tr := range m.triggers {
+				if id := m.tagged[i]; id != 0 && tr.Branch == branch && strings.HasPrefix(state, "queued") && !slices.Contains(m.cancelled, id) {
+					_, _ = fmt.Fprintf(w, `<build id="%d" buildTypeId="%s" branchName="%s" webUrl="%s/viewQueued.html?itemId=%d"/>`, id, tr.BuildTypeID, tr.Branch, m.srv.URL, id)
+				}
+			}
+			m.mu.Unlock()
+			_, _ = w.Write([]byte("</builds>"))
+			return
+		}
+
+		count := 0
+		if strings.HasPrefix(r.URL.Query().Get("locator"), "tag:tctest,state:running") {
+			m.mu.Lock()
+			count = m.throttled
+			m.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprintf(w, `<builds count="%d"/>`, count)
+		return
+	}
+
+	// builds never start here, so every build is queued and cancelled from the queue
+	if id, ok := strings.CutPrefix(r.URL.Path, "/app/rest/2018.1/builds/"); ok && strings.HasSuffix(id, "/state") {
+		_, _ = w.Write([]byte("queued"))
+		return
+	}
+	if id, ok := strings.CutPrefix(r.URL.Path, "/app/rest/2018.1/buildQueue/id:"); ok && r.Method == http.MethodPost {
+		n, _ := strconv.Atoi(id)
+		m.mu.Lock()
+		m.cancelled = append(m.cancelled, n)
+		m.mu.Unlock()
+		_, _ = w.Write([]byte("<build/>"))
+		return
+	}
+
 	if r.Method != http.MethodPost || r.URL.Path != "/app/rest/2018.1/buildQueue" {
 		http.NotFound(w, r)
 		return
@@ -357,6 +618,11 @@ func (m *mockTeamCity) handle(w http.ResponseWriter, r *http.Request) {
 				Value string `xml:"value,attr"`
 			} `xml:"property"`
 		} `xml:"properties"`
+		Tags struct {
+			Tag []struct {
+				Name string `xml:"name,attr"`
+			} `xml:"tag"`
+		} `xml:"tags"`
 	}
 	if err := xml.Unmarshal(body, &req); err != nil {
 		http.Error(w, fmt.Sprintf("bad build xml: %v", err), http.StatusBadRequest)
@@ -373,9 +639,16 @@ func (m *mockTeamCity) handle(w http.ResponseWriter, r *http.Request) {
 	id := m.nextID
 	m.triggers = append(m.triggers, trigger{
 		BuildTypeID: req.BuildType.ID,
-		Branch:      props["teamcity.build.branch"],
-		TestPattern: props["TEST_PATTERN"],
+		Branch:      cmp.Or(props["teamcity.build.branch"], props["BRANCH_NAME"]),
+		TestPattern: cmp.Or(props["TEST_PATTERN"], props["TEST_PREFIX"]),
 	})
+	m.tagged = append(m.tagged, 0)
+	for _, tag := range req.Tags.Tag {
+		if tag.Name == "tctest" {
+			m.throttled++
+			m.tagged[len(m.tagged)-1] = id
+		}
+	}
 	m.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/xml")
@@ -402,6 +675,12 @@ type runResult struct {
 // ~/.tctest, gitconfig) can leak into the run.
 func runTCTest(t *testing.T, env map[string]string, args ...string) runResult {
 	t.Helper()
+	return runTCTestIn(t, harnessHome, env, args...) // no .tctest config in CWD either
+}
+
+// runTCTestIn is runTCTest with the binary running in dir.
+func runTCTestIn(t *testing.T, dir string, env map[string]string, args ...string) runResult {
+	t.Helper()
 
 	full := map[string]string{
 		"PATH":                 os.Getenv("PATH"), // git must be findable
@@ -409,6 +688,7 @@ func runTCTest(t *testing.T, env map[string]string, args ...string) runResult {
 		"TMPDIR":               os.TempDir(),
 		"TCTEST_TOKEN_TC":      "integration-test-token",
 		"TCTEST_BUILD_TYPE_ID": "TF_E2E",
+		"TCTEST_STATE_DIR":     t.TempDir(), // the audit log, tests sharing state set their own
 	}
 	maps.Copy(full, env)
 	envSlice := make([]string, 0, len(full))
@@ -417,7 +697,7 @@ func runTCTest(t *testing.T, env map[string]string, args ...string) runResult {
 	}
 
 	cmd := exec.CommandContext(context.Background(), binPath, args...) //nolint:gosec // running the binary under test
-	cmd.Dir = harnessHome                                              // no .tctest config in CWD either
+	cmd.Dir = dir
 	cmd.Env = envSlice
 
 	var out bytes.Buffer
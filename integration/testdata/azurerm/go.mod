@@ -1,3 +1,9 @@
 module github.com/hashicorp/terraform-provider-azurerm
 
 go 1.22
+
+require (
+	github.com/hashicorp/go-azure-helpers v0.71.0
+	github.com/hashicorp/go-azure-sdk/resource-manager v0.20250131.1134653
+	github.com/hashicorp/go-azure-sdk/sdk v0.20250131.1134653
+)
@@ -0,0 +1,26 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package acceptance
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+)
+
+type TestStep = resource.TestStep
+
+type TestData struct {
+	ResourceName  string
+	RandomInteger int
+	Locations     struct{ Primary string }
+}
+
+func BuildTestData(t *testing.T, resourceType string, resourceLabel string) TestData {
+	return TestData{ResourceName: resourceType + "." + resourceLabel}
+}
+
+func ComposeTestCheckFunc(fs ...resource.TestCheckFunc) resource.TestCheckFunc {
+	return resource.ComposeTestCheckFunc(fs...)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package timeouts
+
+import (
+	"context"
+	"time"
+)
+
+type resourceData interface {
+	Timeout(key string) time.Duration
+}
+
+func ForCreate(ctx context.Context, d resourceData) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.Timeout("create"))
+}
+
+func ForCreateUpdate(ctx context.Context, d resourceData) (context.Context, context.CancelFunc) {
+	if d.Timeout("update") > d.Timeout("create") {
+		return context.WithTimeout(ctx, d.Timeout("update"))
+	}
+	return context.WithTimeout(ctx, d.Timeout("create"))
+}
+
+func ForDelete(ctx context.Context, d resourceData) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.Timeout("delete"))
+}
+
+func ForRead(ctx context.Context, d resourceData) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.Timeout("read"))
+}
+
+func ForUpdate(ctx context.Context, d resourceData) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.Timeout("update"))
+}
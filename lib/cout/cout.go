@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 
 	c "github.com/gookit/color"
 )
@@ -26,6 +28,7 @@ var Level = VerbosityNormal
 type BuildResult struct {
 	PR          int    `json:"pr"`
 	Service     string `json:"service"`
+	Matrix      string `json:"matrix,omitempty"`
 	BuildNumber int    `json:"build_number"`
 	URL         string `json:"url"`
 }
@@ -34,10 +37,11 @@ type BuildResult struct {
 var jsonResults []BuildResult
 
 // AddResult collects a build result for JSON output
-func AddResult(pr int, service string, buildNumber int, url string) {
+func AddResult(pr int, service, matrix string, buildNumber int, url string) {
 	jsonResults = append(jsonResults, BuildResult{
 		PR:          pr,
 		Service:     service,
+		Matrix:      matrix,
 		BuildNumber: buildNumber,
 		URL:         url,
 	})
@@ -55,13 +59,27 @@ func FlushJSON() {
 		results = []BuildResult{}
 	}
 
+	// group --matrix builds by cell, keeping the trigger order within each cell
+	slices.SortStableFunc(results, func(a, b BuildResult) int {
+		return strings.Compare(a.Matrix, b.Matrix)
+	})
+
+	PrintJSON(results)
+	jsonResults = nil
+}
+
+// PrintJSON outputs v as indented JSON, only in JSON mode.
+func PrintJSON(v any) {
+	if Level != VerbosityJSON {
+		return
+	}
+
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "    ")
 	enc.SetEscapeHTML(false)
-	if err := enc.Encode(results); err != nil {
+	if err := enc.Encode(v); err != nil {
 		fmt.Fprintf(os.Stderr, "error marshalling JSON: %v\n", err)
 	}
-	jsonResults = nil
 }
 
 // Writer returns the appropriate writer for normal output (os.Stdout or discard)
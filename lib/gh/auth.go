@@ -0,0 +1,66 @@
+package gh
+
+import (
+	"strings"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+)
+
+// TokenInfo describes who a token authenticates as.
+type TokenInfo struct {
+	Login string
+
+	// Scopes are the OAuth scopes of a classic token, fine-grained and app tokens have none (Classic is false).
+	Scopes  []string
+	Classic bool
+}
+
+// TokenInfo returns the user the token authenticates as and its scopes.
+func (r Repo) TokenInfo() (*TokenInfo, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("getting the authenticated user...")
+	user, resp, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, WrapGitHubError(err, "getting the authenticated user")
+	}
+
+	info := &TokenInfo{Login: user.GetLogin()}
+	if scopes, ok := resp.Header["X-Oauth-Scopes"]; ok {
+		info.Classic = true
+		for s := range strings.SplitSeq(strings.Join(scopes, ","), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				info.Scopes = append(info.Scopes, s)
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// CoreRateLimit returns the REST API rate limit budget of the token, or of the IP address without one.
+func (r Repo) CoreRateLimit() (*github.Rate, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("getting the rate limit...")
+	limits, _, err := client.RateLimit.Get(ctx)
+	if err != nil {
+		return nil, WrapGitHubError(err, "getting the rate limit")
+	}
+
+	return limits.GetCore(), nil
+}
+
+// Get returns the repository, checking it exists and the token can see it.
+func (r Repo) Get() (*github.Repository, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("getting %s/%s...", r.Owner, r.Name)
+	repo, _, err := client.Repositories.Get(ctx, r.Owner, r.Name)
+	if err != nil {
+		return nil, WrapGitHubError(err, "getting "+r.Owner+"/"+r.Name)
+	}
+
+	return repo, nil
+}
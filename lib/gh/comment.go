@@ -0,0 +1,110 @@
+package gh
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+)
+
+// ListIssueComments lists all comments on an issue or pull request, oldest first.
+func (r Repo) ListIssueComments(number int) ([]*github.IssueComment, error) {
+	client, ctx := r.NewClient()
+
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{
+			Page:    1,
+			PerPage: 100,
+		},
+	}
+
+	var all []*github.IssueComment
+	for {
+		clog.Log.Debugf("Listing comments for %s/%s/%d (Page %d)...", r.Owner, r.Name, number, opts.Page)
+		comments, resp, err := client.Issues.ListComments(ctx, r.Owner, r.Name, number, opts)
+		if err != nil {
+			return nil, WrapGitHubError(err, fmt.Sprintf("listing comments for %s/%s#%d (Page %d)", r.Owner, r.Name, number, opts.Page))
+		}
+
+		all = append(all, comments...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return all, nil
+}
+
+// CreateComment posts a comment on an issue or pull request.
+func (r Repo) CreateComment(number int, body string) error {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("commenting on %s/%s/%d...", r.Owner, r.Name, number)
+	if _, _, err := client.Issues.CreateComment(ctx, r.Owner, r.Name, number, &github.IssueComment{Body: &body}); err != nil {
+		return WrapGitHubError(err, fmt.Sprintf("commenting on %s/%s#%d", r.Owner, r.Name, number))
+	}
+
+	return nil
+}
+
+// AddCommentReaction reacts to an issue or pull request comment, content is one of GitHub's reaction names
+// such as "rocket" or "-1".
+func (r Repo) AddCommentReaction(commentID int64, content string) error {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("reacting %s to comment %d on %s/%s...", content, commentID, r.Owner, r.Name)
+	if _, _, err := client.Reactions.CreateIssueCommentReaction(ctx, r.Owner, r.Name, commentID, content); err != nil {
+		return WrapGitHubError(err, fmt.Sprintf("reacting to comment %d on %s/%s", commentID, r.Owner, r.Name))
+	}
+
+	return nil
+}
+
+// ListCommentReactions lists all reactions to an issue or pull request comment.
+func (r Repo) ListCommentReactions(commentID int64) ([]*github.Reaction, error) {
+	client, ctx := r.NewClient()
+
+	opts := &github.ListReactionOptions{
+		ListOptions: github.ListOptions{
+			Page:    1,
+			PerPage: 100,
+		},
+	}
+
+	var all []*github.Reaction
+	for {
+		clog.Log.Debugf("Listing reactions to comment %d on %s/%s (Page %d)...", commentID, r.Owner, r.Name, opts.Page)
+		reactions, resp, err := client.Reactions.ListIssueCommentReactions(ctx, r.Owner, r.Name, commentID, opts)
+		if err != nil {
+			return nil, WrapGitHubError(err, fmt.Sprintf("listing reactions to comment %d on %s/%s (Page %d)", commentID, r.Owner, r.Name, opts.Page))
+		}
+
+		all = append(all, reactions...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return all, nil
+}
+
+// GetPermissionLevel returns a user's permission on the repo: admin, maintain, write, triage, read or none.
+func (r Repo) GetPermissionLevel(user string) (string, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("checking %s's permission on %s/%s...", user, r.Owner, r.Name)
+	perm, _, err := client.Repositories.GetPermissionLevel(ctx, r.Owner, r.Name, user)
+	if err != nil {
+		return "", WrapGitHubError(err, fmt.Sprintf("checking %s's permission on %s/%s", user, r.Owner, r.Name))
+	}
+
+	// role_name distinguishes maintain/triage, which permission folds into write/read
+	if role := perm.GetRoleName(); role != "" {
+		return role, nil
+	}
+	return perm.GetPermission(), nil
+}
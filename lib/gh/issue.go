@@ -0,0 +1,48 @@
+package gh
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/v89/github"
+	"github.com/katbyte/tctest/lib/clog"
+)
+
+// ListIssueTimeline lists all timeline events of an issue or pull request, oldest first.
+func (r Repo) ListIssueTimeline(number int) ([]*github.Timeline, error) {
+	client, ctx := r.NewClient()
+
+	opts := &github.ListOptions{
+		Page:    1,
+		PerPage: 100,
+	}
+
+	var all []*github.Timeline
+	for {
+		clog.Log.Debugf("Listing timeline for %s/%s/%d (Page %d)...", r.Owner, r.Name, number, opts.Page)
+		events, resp, err := client.Issues.ListIssueTimeline(ctx, r.Owner, r.Name, number, opts)
+		if err != nil {
+			return nil, WrapGitHubError(err, fmt.Sprintf("listing timeline for %s/%s#%d (Page %d)", r.Owner, r.Name, number, opts.Page))
+		}
+
+		all = append(all, events...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return all, nil
+}
+
+// RemoveLabel removes a label from an issue or pull request.
+func (r Repo) RemoveLabel(number int, label string) error {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("removing label %s from %s/%s/%d...", label, r.Owner, r.Name, number)
+	if _, err := client.Issues.RemoveLabelForIssue(ctx, r.Owner, r.Name, number, label); err != nil {
+		return WrapGitHubError(err, fmt.Sprintf("removing label %s from %s/%s#%d", label, r.Owner, r.Name, number))
+	}
+
+	return nil
+}
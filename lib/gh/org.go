@@ -0,0 +1,21 @@
+package gh
+
+import (
+	"fmt"
+
+	"github.com/katbyte/tctest/lib/clog"
+)
+
+// IsOrgMember returns true if the user is a member of the org (not necessarily the repo owner). Private
+// memberships are only visible when the token belongs to a member of the org.
+func (r Repo) IsOrgMember(org, user string) (bool, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("checking if %s is a member of %s...", user, org)
+	member, _, err := client.Organizations.IsMember(ctx, org, user)
+	if err != nil {
+		return false, WrapGitHubError(err, fmt.Sprintf("checking %s's membership of %s", user, org))
+	}
+
+	return member, nil
+}
@@ -38,6 +38,19 @@ func (r Repo) CheckoutPR(repoPath string, prNumber int) (string, error) {
 	return sha, nil
 }
 
+// GetPullRequest fetches a single pull request.
+func (r Repo) GetPullRequest(number int) (*github.PullRequest, error) {
+	client, ctx := r.NewClient()
+
+	clog.Log.Debugf("fetching data for PR %s/%s/#%d...", r.Owner, r.Name, number)
+	pr, _, err := client.PullRequests.Get(ctx, r.Owner, r.Name, number)
+	if err != nil {
+		return nil, WrapGitHubError(err, fmt.Sprintf("fetching PR %s/%s/#%d", r.Owner, r.Name, number))
+	}
+
+	return pr, nil
+}
+
 func (r Repo) ListAllPullRequests(state string, cb func([]*github.PullRequest, *github.Response) error) error {
 	client, ctx := r.NewClient()
 
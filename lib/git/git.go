@@ -177,3 +177,51 @@ func normaliseGitURL(u string) string {
 	}
 	return u
 }
+
+// RemoteRepos returns the "owner/repo" each remote of the git repo at repoPath points at, keyed by remote name.
+// Remotes whose URL has no owner/repo path (such as local paths) are left out.
+func RemoteRepos(repoPath string) (map[string]string, error) {
+	out, err := Run(repoPath, "config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := map[string]string{}
+	for line := range strings.Lines(out) {
+		key, url, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+
+		// host/owner/repo, the host telling a URL from a local path
+		parts := strings.Split(normaliseGitURL(url), "/")
+		if len(parts) < 3 || !strings.Contains(parts[0], ".") {
+			continue
+		}
+		repos[name] = parts[len(parts)-2] + "/" + parts[len(parts)-1]
+	}
+
+	return repos, nil
+}
+
+// Version returns the version of the installed git, ie "2.43.0".
+func Version() (string, error) {
+	out, err := Run("", "--version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(out, "git version "), nil
+}
+
+// CanFetch checks the repo's origin can be reached with the configured credentials, without fetching anything.
+func CanFetch(repoPath string) error {
+	_, err := Run(repoPath, "ls-remote", "--exit-code", "origin", "HEAD")
+	return err
+}
+
+// DiffFromFirstParent returns the zero-context diff of a file between the checked out commit and its first parent,
+// for a PR merge commit the base branch it merges into.
+func DiffFromFirstParent(repoPath, relPath string) (string, error) {
+	return Run(repoPath, "diff", "-U0", "HEAD^1", "HEAD", "--", relPath)
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LineRange is an inclusive range of 1-based line numbers.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+func (r LineRange) overlaps(start, end int) bool {
+	return r.Start <= end && start <= r.End
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// ParsePatchLines returns the lines of the new file a unified diff patch changes: added lines, and for removed lines
+// the line that now follows them so a removal inside a declaration counts as changing it.
+func ParsePatchLines(patch string) ([]LineRange, error) {
+	var lines []int
+	line := 0
+	inHunk := false
+	for _, l := range strings.Split(patch, "\n") {
+		if m := hunkHeaderRe.FindStringSubmatch(l); m != nil {
+			start, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("parsing hunk header %q: %w", l, err)
+			}
+			// a hunk only removing lines gives the line before the removal, with -U0 or GitHub's patches alike
+			line = start
+			if strings.Contains(m[0], ",0 @@") {
+				line++
+			}
+			inHunk = true
+			continue
+		}
+		if !inHunk || l == "" {
+			continue
+		}
+
+		switch l[0] {
+		case '+':
+			lines = append(lines, line)
+			line++
+		case '-':
+			lines = append(lines, line)
+		case ' ':
+			line++
+		case '\\': // \ No newline at end of file
+		default:
+			inHunk = false // the next file's header in a multi-file diff
+		}
+	}
+
+	return lineRanges(lines), nil
+}
+
+// lineRanges collapses line numbers into sorted, merged ranges
+func lineRanges(lines []int) []LineRange {
+	slices.Sort(lines)
+	lines = slices.Compact(lines)
+
+	var ranges []LineRange
+	for _, l := range lines {
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 >= l {
+			ranges[n-1].End = l
+			continue
+		}
+		ranges = append(ranges, LineRange{Start: l, End: l})
+	}
+	return ranges
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParsePatchLines(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		patch string
+		want  []LineRange
+	}{
+		"github patch with context": {
+			patch: "@@ -10,7 +10,8 @@ func ParseID(input string) (*ID, error) {\n a\n b\n-c\n+C\n+D\n d\n e\n@@ -40,3 +41,3 @@ func other() {\n x\n-y\n+Y\n z",
+			want:  []LineRange{{12, 13}, {42, 42}},
+		},
+		"zero context removal": {
+			patch: "diff --git a/f.go b/f.go\nindex 1..2 100644\n--- a/f.go\n+++ b/f.go\n@@ -5,2 +4,0 @@ func a() {\n-x\n-y",
+			want:  []LineRange{{5, 5}},
+		},
+		"no newline marker": {
+			patch: "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file",
+			want:  []LineRange{{1, 1}},
+		},
+		"no hunks": {
+			patch: "",
+			want:  nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParsePatchLines(tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParsePatchLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangedSymbols(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := `package parse
+
+// ParseID parses an ID
+func ParseID() string { return normalise() }
+
+func normalise() string { return "" }
+
+const (
+	A = 1
+	B = 2
+)
+
+type Unchanged struct{}
+`
+	if err := os.WriteFile(filepath.Join(dir, "id.go"), []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other := "package parse\n\nfunc ValidateID() { _ = normalise() }\n\nfunc Unrelated() {}\n"
+	if err := os.WriteFile(filepath.Join(dir, "validate.go"), []byte(other), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFileWithPath("id.go", dir)
+	if got := f.ChangedSymbols(false); len(got) != 5 {
+		t.Errorf("ChangedSymbols() without changed lines = %v, want every symbol", got)
+	}
+
+	tests := map[string]struct {
+		lines []LineRange
+		want  []string
+	}{
+		"doc comment":       {[]LineRange{{3, 3}}, []string{"ParseID"}},
+		"unexported helper": {[]LineRange{{6, 6}}, []string{"normalise"}},
+		"grouped constant":  {[]LineRange{{10, 10}}, []string{"B"}},
+		"between decls":     {[]LineRange{{7, 7}}, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := NewFileWithPath("id.go", dir)
+			f.ChangedLines = tt.lines
+			if got := f.ChangedSymbols(false); !slices.Equal(got, tt.want) {
+				t.Errorf("ChangedSymbols() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := PackageReferrers(dir, []string{"normalise"}), []string{"ParseID", "ValidateID", "normalise"}; !slices.Equal(got, want) {
+		t.Errorf("PackageReferrers() = %v, want %v", got, want)
+	}
+}
@@ -4,6 +4,11 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/katbyte/tctest/lib/clog"
 )
@@ -30,29 +35,167 @@ func (f *File) Symbols(exportedOnly bool) []string {
 // SymbolsFromAST extracts all globally declared function/type/variable/constant names
 // from an already-parsed file. If exportedOnly is true, it only returns exported names.
 func SymbolsFromAST(parsed *ast.File, exportedOnly bool) []string {
+	return symbolsFromAST(parsed, exportedOnly, func(ast.Node) bool { return true })
+}
+
+// ChangedSymbols returns the globally declared names whose declarations, including their doc comments, overlap the
+// lines the PR changed. With no ChangedLines every symbol is returned, see Symbols.
+func (f *File) ChangedSymbols(exportedOnly bool) []string {
+	if f.ChangedLines == nil {
+		return f.Symbols(exportedOnly)
+	}
+
+	content, err := f.GetContent()
+	if err != nil {
+		clog.Log.Debugf("    failed to read %s for symbols: %v", f.RelPath, err)
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, f.RelPath, content, parser.ParseComments)
+	if err != nil {
+		clog.Log.Debugf("    failed to parse %s for symbols: %v", f.RelPath, err)
+		return nil
+	}
+
+	return symbolsFromAST(parsed, exportedOnly, f.inChangedLines(fset))
+}
+
+// inChangedLines returns whether a node of the file parsed into fset overlaps the lines the PR changed
+func (f *File) inChangedLines(fset *token.FileSet) func(ast.Node) bool {
+	return func(n ast.Node) bool {
+		start, end := fset.Position(n.Pos()).Line, fset.Position(n.End()).Line
+		return slices.ContainsFunc(f.ChangedLines, func(r LineRange) bool { return r.overlaps(start, end) })
+	}
+}
+
+// symbolsFromAST extracts the globally declared names whose declaration, or spec within a grouped declaration,
+// is included.
+func symbolsFromAST(parsed *ast.File, exportedOnly bool, include func(ast.Node) bool) []string {
 	var symbols []string
-	for _, decl := range parsed.Decls {
+	for _, ident := range declIdents(parsed.Decls, include) {
+		if !exportedOnly || ident.IsExported() {
+			symbols = append(symbols, ident.Name)
+		}
+	}
+	return symbols
+}
+
+// declIdents returns the identifiers of the globally declared names whose declaration, or spec within a grouped
+// declaration, is included.
+func declIdents(decls []ast.Decl, include func(ast.Node) bool) []*ast.Ident {
+	var idents []*ast.Ident
+	for _, decl := range decls {
 		switch d := decl.(type) {
 		case *ast.FuncDecl:
-			if !exportedOnly || d.Name.IsExported() {
-				symbols = append(symbols, d.Name.Name)
+			if include(withDoc{d, d.Doc}) {
+				idents = append(idents, d.Name)
 			}
 		case *ast.GenDecl:
 			for _, spec := range d.Specs {
+				// a spec in a ( ) group is changed by its own lines, a lone one by any line of the declaration
+				var span ast.Node = withDoc{d, d.Doc}
+				if d.Lparen.IsValid() {
+					span = spec
+				}
+				if !include(span) {
+					continue
+				}
+
 				switch s := spec.(type) {
 				case *ast.TypeSpec:
-					if !exportedOnly || s.Name.IsExported() {
-						symbols = append(symbols, s.Name.Name)
-					}
+					idents = append(idents, s.Name)
 				case *ast.ValueSpec:
-					for _, name := range s.Names {
-						if !exportedOnly || name.IsExported() {
-							symbols = append(symbols, name.Name)
-						}
-					}
+					idents = append(idents, s.Names...)
 				}
 			}
 		}
 	}
-	return symbols
+	return idents
+}
+
+// withDoc spans a declaration and its doc comment
+type withDoc struct {
+	ast.Node
+	doc *ast.CommentGroup
+}
+
+func (w withDoc) Pos() token.Pos {
+	if w.doc != nil {
+		return w.doc.Pos()
+	}
+	return w.Node.Pos()
+}
+
+// PackageReferrers returns the symbols and every top-level declaration of the package in dir that refers to one of
+// them, directly or through another declaration, so a change to an unexported function also seeds the exported
+// functions calling it.
+func PackageReferrers(dir string, symbols []string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		clog.Log.Debugf("    failed to read %s for referrers: %v", dir, err)
+		return symbols
+	}
+
+	var decls []ast.Decl
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, 0)
+		if err != nil {
+			continue
+		}
+		decls = append(decls, parsed.Decls...)
+	}
+
+	return referrers(decls, symbols)
+}
+
+// referrers returns the symbols and the names of the declarations referring to one of them, directly or through
+// another declaration.
+func referrers(decls []ast.Decl, symbols []string) []string {
+	// the names each declaration declares and the identifiers it uses
+	type declRefs struct {
+		names []string
+		uses  []string
+	}
+	refs := make([]declRefs, 0, len(decls))
+	for _, decl := range decls {
+		names := symbolsFromAST(&ast.File{Decls: []ast.Decl{decl}}, false, func(ast.Node) bool { return true })
+		if len(names) == 0 {
+			continue
+		}
+		var uses []string
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				uses = append(uses, ident.Name)
+			}
+			return true
+		})
+		refs = append(refs, declRefs{names: names, uses: uses})
+	}
+
+	found := map[string]bool{}
+	for _, s := range symbols {
+		found[s] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, d := range refs {
+			if !slices.ContainsFunc(d.names, func(n string) bool { return !found[n] }) {
+				continue
+			}
+			if !slices.ContainsFunc(d.uses, func(u string) bool { return found[u] }) {
+				continue
+			}
+			for _, n := range d.names {
+				found[n] = true
+			}
+			changed = true
+		}
+	}
+
+	return slices.Sorted(maps.Keys(found))
 }
@@ -4,6 +4,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"slices"
 	"strings"
 
 	"github.com/katbyte/tctest/lib/clog"
@@ -14,6 +15,10 @@ import (
 // If AST parsing fails, it falls back to string regex matching.
 // It uses f.GetContent() to read the file (from cached Content or from disk).
 // It also applies the provided `splitOn` and `reappend` logic.
+//
+// A test file only discovered because the PR changed it, with its ChangedLines known, returns just the tests the PR
+// edited or whose helpers (such as a resource's config functions) it edited, unsplit so the other tests sharing
+// their prefix aren't run.
 func (f *File) ExtractTests(splitOn string, reappend bool) ([]string, error) {
 	content, err := f.GetContent()
 	if err != nil {
@@ -24,7 +29,7 @@ func (f *File) ExtractTests(splitOn string, reappend bool) ([]string, error) {
 	fset := token.NewFileSet()
 
 	// Try parsing with AST
-	parsed, parseErr := parser.ParseFile(fset, f.Path, content, 0)
+	parsed, parseErr := parser.ParseFile(fset, f.Path, content, parser.ParseComments)
 	if parseErr != nil {
 		clog.Log.Debugf("    failed to parse %s, falling back to string match: %v", f.RelPath, parseErr)
 		// fallback: scan lines for "func TestAcc" if AST parsing fails
@@ -46,6 +51,12 @@ func (f *File) ExtractTests(splitOn string, reappend bool) ([]string, error) {
 		}
 	}
 
+	if parseErr == nil && f.onlyChanged() {
+		if changed := f.changedTests(fset, parsed, tests); changed != nil {
+			return changed, nil
+		}
+	}
+
 	// process test names: split and optionally reappend split character
 	processedTests := make([]string, 0, len(tests))
 	for _, t := range tests {
@@ -61,3 +72,39 @@ func (f *File) ExtractTests(splitOn string, reappend bool) ([]string, error) {
 
 	return processedTests, nil
 }
+
+// onlyChanged returns whether the file is a test file discovered only because the PR changed it, with the changed
+// lines known. A test file also derived or traced from a changed resource or helper runs all its tests.
+func (f *File) onlyChanged() bool {
+	return f.ChangedLines != nil && slices.Equal(f.DiscoveredBy, []string{"CHANGED"})
+}
+
+// changedTests returns the tests whose declarations, or the declarations they use in the file, overlap the changed
+// lines, or nil when any test could be affected: the changes are outside every declaration (an import, say), or a
+// changed declaration is used by no test, such as a test resource's Exists or Destroy method, which the test framework
+// calls through the resource rather than by name.
+func (f *File) changedTests(fset *token.FileSet, parsed *ast.File, tests []string) []string {
+	changed := symbolsFromAST(parsed, false, f.inChangedLines(fset))
+	if len(changed) == 0 {
+		return nil
+	}
+
+	var affected []string
+	for _, c := range changed {
+		refs := referrers(parsed.Decls, []string{c})
+		if !slices.ContainsFunc(tests, func(t string) bool { return slices.Contains(refs, t) }) {
+			clog.Log.Debugf("    %s changed %s, which no test uses by name, selecting every test", f.RelPath, c)
+			return nil
+		}
+		affected = append(affected, refs...)
+	}
+
+	selected := make([]string, 0, len(tests))
+	for _, t := range tests {
+		if slices.Contains(affected, t) {
+			selected = append(selected, t)
+		}
+	}
+	clog.Log.Debugf("    %s changed %v, selecting tests %v", f.RelPath, changed, selected)
+	return selected
+}
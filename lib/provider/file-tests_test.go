@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOnlyChanged(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		lines []LineRange
+		by    []string
+		want  bool
+	}{
+		"changed with lines":    {[]LineRange{{1, 1}}, []string{"CHANGED"}, true},
+		"changed without lines": {nil, []string{"CHANGED"}, false},
+		"also derived":          {[]LineRange{{1, 1}}, []string{"CHANGED", "DERIVED"}, false},
+		"traced":                {[]LineRange{{1, 1}}, []string{"TRACED"}, false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := File{ChangedLines: tt.lines, DiscoveredBy: tt.by}
+			if got := f.onlyChanged(); got != tt.want {
+				t.Errorf("onlyChanged() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractChangedTests(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := `package batch
+
+import "testing"
+
+type AccountResource struct{}
+
+func (AccountResource) Exists() bool { return true }
+
+func TestAccAccount_basic(t *testing.T) { _ = AccountResource{}.config() }
+
+func TestAccAccount_update(t *testing.T) {}
+
+func (AccountResource) config() string { return "" }
+
+func unused() {}
+`
+	if err := os.WriteFile(filepath.Join(dir, "account_test.go"), []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"TestAccAccount_basic", "TestAccAccount_update"}
+
+	tests := map[string]struct {
+		lines []LineRange
+		by    []string
+		want  []string
+	}{
+		"test":              {[]LineRange{{11, 11}}, []string{"CHANGED"}, []string{"TestAccAccount_update"}},
+		"helper":            {[]LineRange{{13, 13}}, []string{"CHANGED"}, []string{"TestAccAccount_basic"}},
+		"resource method":   {[]LineRange{{7, 7}}, []string{"CHANGED"}, all},
+		"helper and method": {[]LineRange{{7, 7}, {13, 13}}, []string{"CHANGED"}, all},
+		"import":            {[]LineRange{{3, 3}}, []string{"CHANGED"}, all},
+		"no test affected":  {[]LineRange{{15, 15}}, []string{"CHANGED"}, all},
+		"also derived":      {[]LineRange{{11, 11}}, []string{"CHANGED", "DERIVED"}, all},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := NewFileWithPath("account_test.go", dir)
+			f.ChangedLines, f.DiscoveredBy = tt.lines, tt.by
+			got, err := f.ExtractTests("(", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ExtractTests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
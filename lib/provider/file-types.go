@@ -64,26 +64,31 @@ func (f *File) Classify() FileType {
 	return f.Type
 }
 
-// TypeLabel returns the display label for this file type (e.g. "[RESOURCE]", "[HELPER]").
-func (f *File) TypeLabel() string {
-	switch f.Type {
+// String returns the name of the file type (e.g. "RESOURCE", "HELPER").
+func (t FileType) String() string {
+	switch t {
 	case FileTypeOther:
-		return "[OTHER]"
+		return "OTHER"
 	case FileTypeResource:
-		return "[RESOURCE]"
+		return "RESOURCE"
 	case FileTypeHelper:
-		return "[HELPER]"
+		return "HELPER"
 	case FileTypeTest:
-		return "[TEST]"
+		return "TEST"
 	case FileTypeUnitTest:
-		return "[UNIT]"
+		return "UNIT"
 	case FileTypeVendor:
-		return "[VENDOR]"
+		return "VENDOR"
 	default:
-		return "[OTHER]"
+		return "OTHER"
 	}
 }
 
+// TypeLabel returns the display label for this file type (e.g. "[RESOURCE]", "[HELPER]").
+func (f *File) TypeLabel() string {
+	return "[" + f.Type.String() + "]"
+}
+
 const (
 	FileColourOther    = "<darkGray>"
 	FileColourResource = "<fg=36>"
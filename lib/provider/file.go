@@ -26,8 +26,10 @@ type File struct {
 	BaseName     string // filename without .go: "batch_account_resource"
 	Service      string // the extracted service name from the path, e.g. "batch"
 	Type         FileType
-	DiscoveredBy []string // e.g. CHANGED, DERIVED, TRACED, VENDOR
-	content      []byte   // optional file content for self-reading methods
+	DiscoveredBy []string    // e.g. CHANGED, DERIVED, TRACED, VENDOR
+	Chains       []Chain     // how a test file was discovered, see AddChain
+	ChangedLines []LineRange // lines the PR changed, nil when unknown so every symbol counts as changed
+	content      []byte      // optional file content for self-reading methods
 }
 
 // NewFileWithPath creates a File from a relative path and a local repository root.
@@ -105,6 +107,13 @@ func (f *File) InServicePackage() bool {
 	return false
 }
 
+// InSharedPackage returns true for a non-test file of a package shared by the services, e.g. internal/tf/validation,
+// that service packages can import.
+func (f *File) InSharedPackage() bool {
+	return strings.HasPrefix(f.RelPath, "internal/") && !f.InServicePackage() &&
+		strings.HasSuffix(f.Name, ".go") && !strings.HasSuffix(f.Name, "_test.go")
+}
+
 // ResourcePrefix returns the prefix used for test file discovery.
 // For "batch_account_resource.go" → "batch_account".
 // For "batch_account_data_source.go" → "batch_account_data_source".
@@ -0,0 +1,30 @@
+package provider
+
+import "testing"
+
+func TestInSharedPackage(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"internal/tf/validation/name.go":                  true,
+		"internal/clients/client.go":                      true,
+		"internal/tf/validation/name_test.go":             false,
+		"internal/services/dns/dns_zone_resource.go":      false,
+		"internal/services/dns/parse/zone.go":             false,
+		"internal/service/ec2/vpc.go":                     false,
+		"internal/tf/validation/testdata/config.tf":       false,
+		"vendor/github.com/hashicorp/go-azure-sdk/sdk.go": false,
+		"main.go": false,
+	}
+
+	for relPath, want := range tests {
+		t.Run(relPath, func(t *testing.T) {
+			t.Parallel()
+
+			f := NewFile(relPath)
+			if got := f.InSharedPackage(); got != want {
+				t.Errorf("InSharedPackage() = %t, want %t", got, want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -24,3 +25,117 @@ func GetModulePath(repoPath string) (string, error) {
 
 	return "", errors.New("module directive not found in go.mod")
 }
+
+// ModuleBump is a module whose version a PR changed, From is "" for a module it added.
+type ModuleBump struct {
+	Path  string
+	From  string
+	To    string
+	Files []string // the changed files showing the bump: go.mod, go.sum and/or vendor/modules.txt
+}
+
+func (b ModuleBump) String() string {
+	if b.From == "" {
+		return b.Path + " " + b.To
+	}
+	return b.Path + " " + b.From + " → " + b.To
+}
+
+// IsModuleFile returns whether a file records the versions of the module's dependencies.
+func IsModuleFile(relPath string) bool {
+	return relPath == "go.mod" || relPath == "go.sum" || relPath == "vendor/modules.txt"
+}
+
+// ParseModuleBumps returns the modules a diff of go.mod, go.sum or vendor/modules.txt changes the version of, sorted
+// by path. Modules the diff only removes are left out, nothing can import them any more, as are the go.sum lines
+// summing only a version's go.mod.
+func ParseModuleBumps(relPath, patch string) []ModuleBump {
+	removed := map[string]string{}
+	added := map[string]string{}
+	for line := range strings.SplitSeq(patch, "\n") {
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") {
+			continue
+		}
+		var versions map[string]string
+		switch {
+		case strings.HasPrefix(line, "-"):
+			versions = removed
+		case strings.HasPrefix(line, "+"):
+			versions = added
+		default:
+			continue
+		}
+		if module, version, ok := moduleVersion(relPath, line[1:]); ok {
+			versions[module] = version
+		}
+	}
+
+	var bumps []ModuleBump
+	for module, to := range added {
+		if from := removed[module]; from != to {
+			bumps = append(bumps, ModuleBump{Path: module, From: from, To: to, Files: []string{relPath}})
+		}
+	}
+	slices.SortFunc(bumps, func(a, b ModuleBump) int { return strings.Compare(a.Path, b.Path) })
+	return bumps
+}
+
+// moduleVersion parses the module and version from a line of go.mod (a require or replace), go.sum or
+// vendor/modules.txt ("# module version").
+func moduleVersion(relPath, line string) (string, string, bool) {
+	line, _, _ = strings.Cut(line, "//")
+	fields := strings.Fields(line)
+	switch relPath {
+	case "go.mod":
+		if len(fields) > 0 && (fields[0] == "require" || fields[0] == "replace") {
+			fields = fields[1:]
+		}
+	case "vendor/modules.txt":
+		if len(fields) == 0 || fields[0] != "#" {
+			return "", "", false
+		}
+		fields = fields[1:]
+	case "go.sum":
+		// a version whose go.mod alone is summed was only read for the module graph, not selected
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			return "", "", false
+		}
+		fields = fields[:2]
+	}
+	// a replacement is imported by the path it replaces, at the version it's replaced with
+	if i := slices.Index(fields, "=>"); i > 0 {
+		fields = append(fields[:1], fields[len(fields)-1])
+	}
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "v") {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// RequiredModules returns the paths of the modules go.mod requires.
+func RequiredModules(repoPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, "go.mod")) //nolint:gosec // path is from user-provided --local-repo-path flag
+	if err != nil {
+		return nil, fmt.Errorf("reading go.mod: %w", err)
+	}
+
+	var modules []string
+	inRequire := false
+	for line := range strings.SplitSeq(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "require ("):
+			inRequire = true
+			continue
+		case inRequire && trimmed == ")":
+			inRequire = false
+			continue
+		case !inRequire && !strings.HasPrefix(trimmed, "require "):
+			continue
+		}
+		if module, _, ok := moduleVersion("go.mod", trimmed); ok {
+			modules = append(modules, module)
+		}
+	}
+	return modules, nil
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseModuleBumps(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		file  string
+		patch string
+		want  []ModuleBump
+	}{
+		"go.mod require block": {
+			file: "go.mod",
+			patch: "@@ -5,7 +5,8 @@ require (\n \tgithub.com/a/kept v1.0.0\n-\tgithub.com/hashicorp/go-azure-sdk/sdk v0.20250101.0\n" +
+				"+\tgithub.com/hashicorp/go-azure-sdk/sdk v0.20250201.0\n-\tgithub.com/old/dropped v1.0.0 // indirect\n+\tgolang.org/x/new v0.1.0 // indirect\n )",
+			want: []ModuleBump{
+				{Path: "github.com/hashicorp/go-azure-sdk/sdk", From: "v0.20250101.0", To: "v0.20250201.0", Files: []string{"go.mod"}},
+				{Path: "golang.org/x/new", To: "v0.1.0", Files: []string{"go.mod"}},
+			},
+		},
+		"go.mod replace": {
+			file:  "go.mod",
+			patch: "-replace github.com/a/b => github.com/fork/b v1.0.0\n+replace github.com/a/b => github.com/fork/b v1.1.0",
+			want:  []ModuleBump{{Path: "github.com/a/b", From: "v1.0.0", To: "v1.1.0", Files: []string{"go.mod"}}},
+		},
+		"go.sum": {
+			file: "go.sum",
+			patch: "--- a/go.sum\n+++ b/go.sum\n-github.com/a/b v1.0.0 h1:abc=\n-github.com/a/b v1.0.0/go.mod h1:def=\n" +
+				"+github.com/a/b v1.2.0 h1:ghi=\n+github.com/a/b v1.2.0/go.mod h1:jkl=",
+			want: []ModuleBump{{Path: "github.com/a/b", From: "v1.0.0", To: "v1.2.0", Files: []string{"go.sum"}}},
+		},
+		"go.sum go.mod lines only": {
+			file:  "go.sum",
+			patch: "+github.com/a/b v1.2.0/go.mod h1:jkl=\n+github.com/c/d v0.3.0/go.mod h1:mno=",
+			want:  nil,
+		},
+		"vendor/modules.txt": {
+			file:  "vendor/modules.txt",
+			patch: "-# github.com/a/b v1.0.0\n+# github.com/a/b v1.2.0\n ## explicit; go 1.22\n github.com/a/b/c\n+github.com/a/b/d",
+			want:  []ModuleBump{{Path: "github.com/a/b", From: "v1.0.0", To: "v1.2.0", Files: []string{"vendor/modules.txt"}}},
+		},
+		"go directive only": {
+			file:  "go.mod",
+			patch: "-go 1.22\n+go 1.23",
+			want:  nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := ParseModuleBumps(tt.file, tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseModuleBumps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
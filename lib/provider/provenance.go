@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"slices"
+	"strings"
+)
+
+// link kinds, in the order they appear in a chain
+const (
+	LinkChangedFile   = "changed file"
+	LinkModule        = "module"
+	LinkVendorPackage = "vendor package"
+	LinkSymbol        = "symbol"
+	LinkPackage       = "package"
+	LinkResourceFile  = "resource file"
+	LinkTestFile      = "test file"
+	LinkTest          = "test"
+	LinkDirective     = "directive"
+	LinkSmokeSuite    = "smoke suite"
+)
+
+// Link is one step in the chain explaining why a test was selected.
+type Link struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Chain is the provenance of a selected test file or test: the changed file it starts at, any traced symbols,
+// intermediate packages and resource files, then the test file and test it ends at.
+type Chain []Link
+
+// Then returns a copy of the chain with a link appended, leaving the receiver's backing array untouched so chains
+// sharing a prefix stay independent.
+func (c Chain) Then(kind, value string) Chain {
+	out := make(Chain, len(c), len(c)+1)
+	copy(out, c)
+	return append(out, Link{Kind: kind, Value: value})
+}
+
+func (c Chain) String() string {
+	parts := make([]string, 0, len(c))
+	for _, l := range c {
+		parts = append(parts, l.Value+" ["+l.Kind+"]")
+	}
+	return strings.Join(parts, " → ")
+}
+
+// AddChain records a chain that led to the file, ending it at the file unless it already does (a changed test file
+// is both the start and the end of its chain). Duplicate chains are ignored.
+func (f *File) AddChain(c Chain) {
+	if len(c) == 0 || c[len(c)-1].Value != f.RelPath {
+		c = c.Then(LinkTestFile, f.RelPath)
+	}
+	s := c.String()
+	if slices.ContainsFunc(f.Chains, func(e Chain) bool { return e.String() == s }) {
+		return
+	}
+	f.Chains = append(f.Chains, c)
+}
@@ -0,0 +1,265 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TypeChecker type-checks the packages of a local provider clone from source, offline: the module's packages and
+// its vendor directory are read from the clone and the standard library from GOROOT. Any other import is stubbed with
+// an empty package, so uses through it go unresolved rather than failing the check.
+type TypeChecker struct {
+	RepoPath   string
+	ModulePath string
+	Fset       *token.FileSet
+
+	// Traced reports whether a module package is checked with its function bodies and the uses of its identifiers
+	// recorded, the rest are only checked for their declarations
+	Traced func(pkgPath string) bool
+
+	ctxt     build.Context
+	packages map[string]*TypedPackage
+}
+
+// TypedPackage is a type-checked package and, for a traced package, its files and the objects their identifiers
+// define and use.
+type TypedPackage struct {
+	Path  string
+	Dir   string // relative to the repo for module packages
+	Types *types.Package
+	Files map[string]*ast.File // by repo relative path
+	Info  *types.Info
+}
+
+// TypedDecl is a top-level declaration of a traced package: the objects it declares and the module objects it uses.
+type TypedDecl struct {
+	File    string
+	Package *TypedPackage
+	Defines []types.Object
+	Uses    []types.Object
+}
+
+func NewTypeChecker(repoPath, modulePath string, traced func(pkgPath string) bool) *TypeChecker {
+	ctxt := build.Default
+	ctxt.CgoEnabled = false // the pure go fallbacks type-check without running cgo
+
+	return &TypeChecker{
+		RepoPath:   repoPath,
+		ModulePath: modulePath,
+		Fset:       token.NewFileSet(),
+		Traced:     traced,
+		ctxt:       ctxt,
+		packages:   map[string]*TypedPackage{},
+	}
+}
+
+// Import implements types.Importer.
+func (c *TypeChecker) Import(pkgPath string) (*types.Package, error) {
+	if pkgPath == "unsafe" {
+		return types.Unsafe, nil
+	}
+	p, err := c.Load(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	return p.Types, nil
+}
+
+// Load type-checks a package, and the packages it imports, once.
+func (c *TypeChecker) Load(pkgPath string) (*TypedPackage, error) {
+	if p, ok := c.packages[pkgPath]; ok {
+		if p == nil {
+			return nil, fmt.Errorf("import cycle through %s", pkgPath)
+		}
+		return p, nil
+	}
+	c.packages[pkgPath] = nil
+
+	p := &TypedPackage{Path: pkgPath}
+	dir, module := c.resolve(pkgPath)
+	if dir == "" {
+		p.Types = stubPackage(pkgPath)
+		c.packages[pkgPath] = p
+		return p, nil
+	}
+	if module {
+		p.Dir = strings.TrimPrefix(strings.TrimPrefix(pkgPath, c.ModulePath), "/")
+	}
+
+	bp, err := c.ctxt.ImportDir(dir, 0)
+	if err != nil {
+		var noGo *build.NoGoError
+		if !errors.As(err, &noGo) {
+			delete(c.packages, pkgPath)
+			return nil, fmt.Errorf("listing %s: %w", dir, err)
+		}
+	}
+
+	traced := module && c.Traced(pkgPath)
+	files := make([]*ast.File, 0, len(bp.GoFiles))
+	p.Files = map[string]*ast.File{}
+	for _, name := range bp.GoFiles {
+		mode := parser.SkipObjectResolution
+		if traced {
+			mode |= parser.ParseComments
+		}
+		parsed, err := parser.ParseFile(c.Fset, filepath.Join(dir, name), nil, mode)
+		if err != nil && parsed == nil {
+			continue
+		}
+		files = append(files, parsed)
+		if traced {
+			p.Files[path.Join(p.Dir, name)] = parsed
+		}
+	}
+
+	conf := types.Config{
+		Importer:         c,
+		IgnoreFuncBodies: !traced,
+		FakeImportC:      true,
+		Error:            func(error) {}, // keep going, an unresolved import only hides the uses through it
+	}
+	if traced {
+		p.Info = &types.Info{Defs: map[*ast.Ident]types.Object{}, Uses: map[*ast.Ident]types.Object{}}
+	}
+	name := bp.Name
+	if name == "" {
+		name = path.Base(pkgPath)
+	}
+	p.Types, _ = conf.Check(pkgPath, c.Fset, files, p.Info)
+	if p.Types == nil {
+		p.Types = types.NewPackage(pkgPath, name)
+	}
+
+	c.packages[pkgPath] = p
+	return p, nil
+}
+
+// resolve returns the directory holding a package's source, and whether it's one of the module's own packages
+func (c *TypeChecker) resolve(pkgPath string) (string, bool) {
+	if pkgPath == c.ModulePath || strings.HasPrefix(pkgPath, c.ModulePath+"/") {
+		return filepath.Join(c.RepoPath, filepath.FromSlash(strings.TrimPrefix(pkgPath, c.ModulePath))), true
+	}
+
+	for _, dir := range []string{
+		filepath.Join(c.RepoPath, "vendor", filepath.FromSlash(pkgPath)),
+		filepath.Join(c.ctxt.GOROOT, "src", filepath.FromSlash(pkgPath)),
+		filepath.Join(c.ctxt.GOROOT, "src", "vendor", filepath.FromSlash(pkgPath)),
+	} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, false
+		}
+	}
+	return "", false
+}
+
+// stubPackage is an empty, complete package standing in for one whose source isn't available
+func stubPackage(pkgPath string) *types.Package {
+	name := path.Base(pkgPath)
+	if strings.HasPrefix(name, "v") && strings.Trim(name[1:], "0123456789") == "" {
+		name = path.Base(path.Dir(pkgPath))
+	}
+	p := types.NewPackage(pkgPath, strings.ReplaceAll(name, "-", "_"))
+	p.MarkComplete()
+	return p
+}
+
+// ChangedObjects returns the package-level objects and methods of a file of a traced package whose declarations
+// overlap the lines the PR changed, or every one it declares when the changed lines aren't known.
+func (c *TypeChecker) ChangedObjects(f File) []types.Object {
+	p, err := c.Load(path.Join(c.ModulePath, strings.TrimSuffix(f.Dir, "/")))
+	if err != nil || p.Info == nil {
+		return nil
+	}
+	parsed, ok := p.Files[f.RelPath]
+	if !ok {
+		return nil
+	}
+
+	include := func(ast.Node) bool { return true }
+	if f.ChangedLines != nil {
+		include = f.inChangedLines(c.Fset)
+	}
+	var objects []types.Object
+	for _, ident := range declIdents(parsed.Decls, include) {
+		if obj := p.Info.Defs[ident]; obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// Decls returns the top-level declarations of a traced package, in file order.
+func (c *TypeChecker) Decls(p *TypedPackage) []TypedDecl {
+	var decls []TypedDecl
+	for _, relPath := range slices.Sorted(maps.Keys(p.Files)) {
+		for _, decl := range p.Files[relPath].Decls {
+			d := TypedDecl{File: relPath, Package: p}
+			for _, ident := range declIdents([]ast.Decl{decl}, func(ast.Node) bool { return true }) {
+				if obj := p.Info.Defs[ident]; obj != nil {
+					d.Defines = append(d.Defines, obj)
+				}
+			}
+			seen := map[types.Object]bool{}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				ident, ok := n.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				obj := Origin(p.Info.Uses[ident])
+				if obj == nil || obj.Pkg() == nil || seen[obj] || !strings.HasPrefix(obj.Pkg().Path()+"/", c.ModulePath+"/") {
+					return true
+				}
+				// locals are covered by the declaration they're in
+				if obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope() {
+					return true
+				}
+				seen[obj] = true
+				d.Uses = append(d.Uses, obj)
+				return true
+			})
+			decls = append(decls, d)
+		}
+	}
+	return decls
+}
+
+// Origin returns the generic object an instantiated method or field was created from.
+func Origin(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Origin()
+	case *types.Var:
+		return o.Origin()
+	}
+	return obj
+}
+
+// ObjectName names an object for a chain link, methods by their receiver type: "Client.Get".
+func ObjectName(obj types.Object) string {
+	if f, ok := obj.(*types.Func); ok && f.Signature().Recv() != nil {
+		return namedType(f.Signature().Recv().Type()) + "." + f.Name()
+	}
+	return obj.Name()
+}
+
+func namedType(t types.Type) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if n, ok := types.Unalias(t).(*types.Named); ok {
+		return n.Obj().Name()
+	}
+	return t.String()
+}
@@ -12,9 +12,34 @@ import (
 	"github.com/katbyte/tctest/lib/cout"
 )
 
-func (s Server) RunBuild(buildTypeID, buildProperties, branch, testRegEx string, skipQueue bool) (buildID int, buildURL string, err error) {
+// Property is a single TeamCity build parameter sent when triggering a build.
+type Property struct {
+	Name  string
+	Value string
+}
+
+// ParseProperties parses a 'KEY1=VALUE1;KEY2=VALUE2' properties string.
+func ParseProperties(properties string) ([]Property, error) {
+	if properties == "" {
+		return nil, nil
+	}
+
+	var props []Property
+	for p := range strings.SplitSeq(properties, ";") {
+		// Cut so values may themselves contain '=' (base64, -run=Foo, URLs)
+		name, value, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, fmt.Errorf("unable to parse build property '%s': expected KEY=VALUE", p)
+		}
+		props = append(props, Property{Name: name, Value: value})
+	}
+
+	return props, nil
+}
+
+func (s Server) RunBuild(buildTypeID string, properties []Property, tags []string, skipQueue bool) (buildID int, buildURL string, err error) {
 	clog.Log.Debugf("triggering build for %q", buildTypeID)
-	statusCode, body, err := s.TriggerBuild(buildTypeID, branch, testRegEx, buildProperties, skipQueue)
+	statusCode, body, err := s.TriggerBuild(buildTypeID, properties, tags, skipQueue)
 	if err != nil {
 		return 0, "", fmt.Errorf("error creating build request: %w", err)
 	}
@@ -39,42 +64,38 @@ func (s Server) RunBuild(buildTypeID, buildProperties, branch, testRegEx string,
 	return bid, fmt.Sprintf("https://%s/viewQueued.html?itemId=%d", s.Server, bid), nil
 }
 
-// TriggerBuild queues a TeamCity build for the given build type and branch with the test pattern and additional properties.
+// TriggerBuild queues a TeamCity build for the given build type with the given properties. The branch and test
+// pattern are ordinary properties here, their names depend on how the build configuration is set up. The tags are
+// part of the queue request, so the build is never seen without them by those counting builds by tag.
 // todo is there any reason to not inline this into runbuild?
-func (s Server) TriggerBuild(buildTypeID, branch, testPattern, buildProperties string, skipQueue bool) (statusCode int, respBody string, err error) {
-	var additionalProps strings.Builder
+func (s Server) TriggerBuild(buildTypeID string, properties []Property, tags []string, skipQueue bool) (statusCode int, respBody string, err error) {
+	var props strings.Builder
 
-	if buildProperties != "" {
-		clog.Log.Debugf("adding additional properties:")
-
-		for p := range strings.SplitSeq(buildProperties, ";") {
-			// SplitN so values may themselves contain '=' (base64, -run=Foo, URLs)
-			parts := strings.SplitN(p, "=", 2)
-			if len(parts) != 2 {
-				return 0, "", fmt.Errorf("unable to parse build property '%s': expected KEY=VALUE", p)
-			}
+	clog.Log.Debugf("build properties:")
+	for _, p := range properties {
+		clog.Log.Debugf("  property:%s=%s", p.Name, p.Value)
+		fmt.Fprintf(&props, "\t\t<property name=\"%s\" value=\"%s\"/>\n", xmlEscape(p.Name), xmlEscape(p.Value))
+	}
 
-			clog.Log.Debugf("  property:%s=%s", parts[0], parts[1])
-			fmt.Fprintf(&additionalProps, "\t\t<property name=\"%s\" value=\"%s\"/>\n", xmlEscape(parts[0]), xmlEscape(parts[1]))
+	var tagsXML strings.Builder
+	for _, t := range tags {
+		if t == "" {
+			return 0, "", fmt.Errorf("received an empty string to tag the %s build with", buildTypeID)
 		}
+		clog.Log.Debugf("  tag:%s", t)
+		fmt.Fprintf(&tagsXML, "\t\t<tag name=\"%s\"/>\n", xmlEscape(t))
 	}
 
-	bodyAdditionalProperties := additionalProps.String()
-
-	// for now, we have two types of build - historical providers (BRANCH_NAME & TEST_PATTERN), new azurerm (teamcity.build.branch, TEST_PREFIX)
-	// should be safe to send both
 	body := fmt.Sprintf(`
 <build>
-	<triggeringOptions queueAtTop="%[5]s"/>
+	<triggeringOptions queueAtTop="%[3]s"/>
 	<buildType id="%[1]s"/>
 	<properties>
-        <property name="teamcity.build.branch" value="%[2]s"/>
-		<property name="BRANCH_NAME" value="%[2]s"/>
-		<property name="TEST_PATTERN" value="%[3]s"/>
-        <property name="TEST_PREFIX" value="%[3]s"/>
-%[4]s	</properties>
+%[2]s	</properties>
+	<tags>
+%[4]s	</tags>
 </build>
-`, xmlEscape(buildTypeID), xmlEscape(branch), xmlEscape(testPattern), bodyAdditionalProperties, strconv.FormatBool(skipQueue))
+`, xmlEscape(buildTypeID), props.String(), strconv.FormatBool(skipQueue), tagsXML.String())
 
 	return s.makePostRequestWithXMLContentType("/app/rest/2018.1/buildQueue", body)
 }
@@ -161,30 +182,20 @@ func (s Server) CheckBuildLogStatus(statusCode, buildID int) error {
 	return nil
 }
 
-// AddTags adds Tags to a TeamCity build run using the REST API
-func (s Server) AddTags(buildID int, tags []string) error {
-	if len(tags) == 0 {
-		return nil // Nothing to do
+// CancelBuild cancels a queued build, or stops a running one, leaving the comment on it.
+func (s Server) CancelBuild(buildID int, queued bool, comment string) error {
+	endpoint := fmt.Sprintf("/app/rest/2018.1/builds/id:%d", buildID)
+	if queued {
+		endpoint = fmt.Sprintf("/app/rest/2018.1/buildQueue/id:%d", buildID)
 	}
 
-	clog.Log.Debugf("adding tags %v to build %d", tags, buildID)
-
-	for _, tag := range tags {
-		if tag == "" {
-			return fmt.Errorf("received an empty string to add as tag to build %d", buildID)
-		}
-
-		// TeamCity REST API expects a simple text body for adding tags
-		statusCode, _, err := s.makePostRequestWithContentType(fmt.Sprintf("/app/rest/2018.1/builds/id:%d/tags", buildID), tag, "text/plain")
-		if err != nil {
-			return fmt.Errorf("error adding tag '%s' to build %d: %w", tag, buildID, err)
-		}
-
-		if statusCode != http.StatusOK {
-			return fmt.Errorf("HTTP status NOT OK when adding tag '%s' to build %d: %d", tag, buildID, statusCode)
-		}
-
-		clog.Log.Debugf("added tag '%s' to build %d", tag, buildID)
+	body := fmt.Sprintf(`<buildCancelRequest comment="%s" readdIntoQueue="false"/>`, xmlEscape(comment))
+	statusCode, _, err := s.makePostRequestWithXMLContentType(endpoint, body)
+	if err != nil {
+		return fmt.Errorf("error cancelling build %d: %w", buildID, err)
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status NOT OK cancelling build %d: %d", buildID, statusCode)
 	}
 
 	return nil
@@ -19,3 +19,25 @@ func TestXmlEscape(t *testing.T) {
 		}
 	}
 }
+
+func TestParseProperties(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseProperties("A=1;B=x=y;C=")
+	if err != nil {
+		t.Fatalf("ParseProperties unexpected error: %v", err)
+	}
+	want := []Property{{Name: "A", Value: "1"}, {Name: "B", Value: "x=y"}, {Name: "C", Value: ""}}
+	if len(got) != len(want) {
+		t.Fatalf("ParseProperties = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseProperties[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := ParseProperties("A=1;B"); err == nil {
+		t.Error("ParseProperties(\"A=1;B\") expected an error")
+	}
+}
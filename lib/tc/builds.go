@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -14,34 +15,43 @@ type buildsResp struct {
 }
 
 type buildsRespBuild struct {
-	XMLName    xml.Name `xml:"build"`
-	ID         string   `xml:"id,attr"`
-	Number     string   `xml:"number,attr"`
-	State      string   `xml:"state,attr"`
-	BranchName string   `xml:"branchName,attr"`
-	WebURL     string   `xml:"webUrl,attr"`
+	XMLName     xml.Name `xml:"build"`
+	ID          string   `xml:"id,attr"`
+	BuildTypeID string   `xml:"buildTypeId,attr"`
+	Number      string   `xml:"number,attr"`
+	State       string   `xml:"state,attr"`
+	BranchName  string   `xml:"branchName,attr"`
+	WebURL      string   `xml:"webUrl,attr"`
+	Tags        []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"tags>tag"`
 }
 
+// ErrNoBuilds is returned (wrapped) by GetBuildsForPR when the build type has no builds for the PR.
+var ErrNoBuilds = errors.New("no builds found")
+
 type Build struct {
-	ID     int
-	Number int
-	Branch string
-	URL    string
-	State  string
+	ID          int
+	BuildTypeID string
+	Number      int
+	Branch      string
+	URL         string
+	State       string
+	Tags        []string
 }
 
-func (s Server) GetBuildsForPR(buildTypeID string, pr int, latest, wait bool, queueTimeout, runTimeout int) (*[]Build, error) {
+// GetBuildsForPR returns the builds of a build type for a PR, newest first.
+func (s Server) GetBuildsForPR(buildTypeID string, pr int) (*[]Build, error) {
 	queryArgs := fmt.Sprintf("buildType:%s,branch:name:refs/pull/%d/merge,running:any", buildTypeID, pr)
-	if latest {
-		queryArgs += ",count:1"
-	}
 
-	statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/builds?locator=" + queryArgs)
+	// tags aren't part of the default build fields
+	fields := "build(id,number,state,branchName,webUrl,tags(tag(name)))"
+	statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/builds?locator=" + queryArgs + "&fields=" + fields)
 	if err != nil {
 		return nil, fmt.Errorf("unable to list builds (%s): %w", queryArgs, err)
 	}
 	if statusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("no build for PR %d found in running builds or queue", pr)
+		return nil, fmt.Errorf("no build for PR %d found in running builds or queue: %w", pr, ErrNoBuilds)
 	}
 	if statusCode != http.StatusOK {
 		return nil, fmt.Errorf("HTTP status NOT OK: %d", statusCode)
@@ -57,7 +67,7 @@ func (s Server) GetBuildsForPR(buildTypeID string, pr int, latest, wait bool, qu
 		return nil, err
 	}
 	if len(tcb.Builds) == 0 {
-		return nil, errors.New("no builds parsed from XML response")
+		return nil, fmt.Errorf("no builds parsed from XML response: %w", ErrNoBuilds)
 	}
 
 	builds := []Build{}
@@ -67,6 +77,9 @@ func (s Server) GetBuildsForPR(buildTypeID string, pr int, latest, wait bool, qu
 			URL:    build.WebURL,
 			State:  build.State,
 		}
+		for _, t := range build.Tags {
+			b.Tags = append(b.Tags, t.Name)
+		}
 
 		b.ID, err = strconv.Atoi(build.ID)
 		if err != nil {
@@ -78,15 +91,72 @@ func (s Server) GetBuildsForPR(buildTypeID string, pr int, latest, wait bool, qu
 			return nil, fmt.Errorf("unable to convert build.Number (%s) from response into an integer: %w", build.Number, err)
 		}
 
-		if build.State != "finished" && wait {
-			err := s.WaitForBuild(b.ID, queueTimeout, runTimeout)
+		builds = append(builds, b)
+	}
+
+	return &builds, nil
+}
+
+type buildsCountResp struct {
+	XMLName xml.Name `xml:"builds"`
+	Count   int      `xml:"count,attr"`
+}
+
+// CountActiveBuildsWithTag returns how many running and queued builds, on any branch, carry the tag.
+func (s Server) CountActiveBuildsWithTag(tag string) (int, error) {
+	total := 0
+	for _, state := range []string{"running", "queued"} {
+		// branch:default:any, otherwise TeamCity only counts builds of the default branch
+		locator := fmt.Sprintf("tag:%s,state:%s,branch:default:any,count:10000", tag, state)
+
+		statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/builds?locator=" + url.QueryEscape(locator) + "&fields=count")
+		if err != nil {
+			return 0, fmt.Errorf("unable to count %s builds tagged %s: %w", state, tag, err)
+		}
+		if statusCode != http.StatusOK {
+			return 0, fmt.Errorf("HTTP status NOT OK counting %s builds tagged %s: %d", state, tag, statusCode)
+		}
+
+		var resp buildsCountResp
+		if err := xml.Unmarshal([]byte(body), &resp); err != nil {
+			return 0, fmt.Errorf("unable to decode %s builds tagged %s: %w", state, tag, err)
+		}
+		total += resp.Count
+	}
+
+	return total, nil
+}
+
+// GetActiveBuildsWithTag returns the queued and running builds of a branch with a tag, in any build type.
+func (s Server) GetActiveBuildsWithTag(tag, branch string) ([]Build, error) {
+	var builds []Build
+	for _, state := range []string{"queued", "running"} {
+		locator := fmt.Sprintf("tag:%s,branch:name:%s,state:%s,count:10000", tag, branch, state)
+
+		statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/builds?locator=" + url.QueryEscape(locator) + "&fields=" + url.QueryEscape("build(id,buildTypeId,branchName,webUrl)"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %s builds of %s tagged %s: %w", state, branch, tag, err)
+		}
+		if statusCode == http.StatusNotFound {
+			continue // nothing matched
+		}
+		if statusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP status NOT OK listing %s builds of %s tagged %s: %d", state, branch, tag, statusCode)
+		}
+
+		var tcb buildsResp
+		if err := xml.Unmarshal([]byte(body), &tcb); err != nil {
+			return nil, fmt.Errorf("unable to decode %s builds of %s tagged %s: %w", state, branch, tag, err)
+		}
+
+		for _, build := range tcb.Builds {
+			id, err := strconv.Atoi(build.ID)
 			if err != nil {
-				return nil, fmt.Errorf("error waiting for PR %d, build %d to finish: %w", pr, b.ID, err)
+				return nil, fmt.Errorf("unable to convert build.ID (%s) from response into an integer: %w", build.ID, err)
 			}
+			builds = append(builds, Build{ID: id, BuildTypeID: build.BuildTypeID, Branch: build.BranchName, URL: build.WebURL, State: state})
 		}
-
-		builds = append(builds, b)
 	}
 
-	return &builds, nil
+	return builds, nil
 }
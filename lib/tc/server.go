@@ -0,0 +1,57 @@
+package tc
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorised is returned (wrapped) when TeamCity rejects the credentials.
+var ErrUnauthorised = errors.New("authentication failed")
+
+// Version returns the TeamCity server version, checking the server is reachable and accepts the credentials.
+func (s Server) Version() (string, error) {
+	statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/server/version")
+	if err != nil {
+		return "", fmt.Errorf("unable to reach %s: %w", s.baseURL(), err)
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return strings.TrimSpace(body), nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("%w (HTTP %d)", ErrUnauthorised, statusCode)
+	}
+	return "", fmt.Errorf("HTTP status NOT OK getting the server version: %d", statusCode)
+}
+
+type buildTypesResp struct {
+	XMLName    xml.Name `xml:"buildTypes"`
+	BuildTypes []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"buildType"`
+}
+
+// BuildTypeIDs returns the IDs of every build configuration the credentials can see.
+func (s Server) BuildTypeIDs() ([]string, error) {
+	statusCode, body, err := s.makeGetRequest("/app/rest/2018.1/buildTypes?fields=buildType(id)")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list build types: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status NOT OK listing build types: %d", statusCode)
+	}
+
+	var resp buildTypesResp
+	if err := xml.Unmarshal([]byte(body), &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode build types: %w", err)
+	}
+
+	ids := make([]string, 0, len(resp.BuildTypes))
+	for _, bt := range resp.BuildTypes {
+		ids = append(ids, bt.ID)
+	}
+	return ids, nil
+}
@@ -2,9 +2,14 @@
 package tc
 
 import (
+	"errors"
+
 	"github.com/katbyte/tctest/lib/clog"
 )
 
+// ErrNoCredentials is returned by NewServer when neither a token nor a username is set.
+var ErrNoCredentials = errors.New("no TeamCity credentials, a token or username is required")
+
 type Server struct {
 	Server string
 	token  *string
@@ -12,17 +17,16 @@ type Server struct {
 	Pass   *string
 }
 
-func NewServer(server, token, username, password string) Server {
+func NewServer(server, token, username, password string) (Server, error) {
 	if token != "" {
-		return NewServerUsingTokenAuth(server, token)
+		return NewServerUsingTokenAuth(server, token), nil
 	}
 
 	if username != "" {
-		return NewServerUsingBasicAuth(server, username, password)
+		return NewServerUsingBasicAuth(server, username, password), nil
 	}
 
-	// should probably do something better here
-	panic("token & username are both empty")
+	return Server{}, ErrNoCredentials
 }
 
 func NewServerUsingTokenAuth(server, token string) Server {
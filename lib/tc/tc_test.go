@@ -0,0 +1,20 @@
+package tc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewServer(t *testing.T) {
+	t.Parallel()
+
+	if s, err := NewServer("tc.example.com", "token", "", ""); err != nil || s.token == nil {
+		t.Errorf("NewServer with a token = %+v, %v, want token auth", s, err)
+	}
+	if s, err := NewServer("tc.example.com", "", "user", "pass"); err != nil || s.User == nil {
+		t.Errorf("NewServer with a username = %+v, %v, want basic auth", s, err)
+	}
+	if _, err := NewServer("tc.example.com", "", "", ""); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("NewServer without credentials = %v, want ErrNoCredentials", err)
+	}
+}